@@ -0,0 +1,56 @@
+// Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/materials-commons/mql/internal/mql"
+	"github.com/materials-commons/mql/internal/mql/parser"
+	"github.com/spf13/cobra"
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check <query>",
+	Short: "Check an MQL query for errors",
+	Long: `Check parses and compiles an MQL query without running it. Syntax errors are shown with the
+line of the query they occur on and a caret pointing at the error.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, _, err := mql.Compile(args[0])
+		if err == nil {
+			fmt.Println("Query is valid")
+			return
+		}
+
+		var parseErrors parser.ParseErrors
+		if !errors.As(err, &parseErrors) {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, parseError := range parseErrors {
+			fmt.Printf("%d:%d: %s\n%s\n", parseError.Line, parseError.Column, parseError.Message, parseError.Snippet)
+		}
+		os.Exit(1)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+}
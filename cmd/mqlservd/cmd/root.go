@@ -17,6 +17,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/labstack/echo/v4"
@@ -28,8 +29,12 @@ import (
 )
 
 var (
-	cfgFile    string
-	dotenvPath string
+	cfgFile        string
+	dotenvPath     string
+	snapshotDir    string
+	snapshotMaxAge time.Duration
+	maxProjects    int
+	maxMemoryMB    int64
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -47,11 +52,25 @@ to query their materials data and find matching samples and processes.`,
 		db := mcdb.MustConnectToDB()
 
 		api.Init(db)
+		api.SetProjectBudget(maxProjects, maxMemoryMB*1024*1024)
+
+		if snapshotDir != "" {
+			if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+				log.Fatalf("Unable to create snapshot directory %s: %s", snapshotDir, err)
+			}
+			api.EnableSnapshots(snapshotDir, snapshotMaxAge)
+			api.WarmStart()
+		}
 
 		g := e.Group("/api")
 		g.POST("/load-project", api.LoadProjectController)
 		g.POST("/reload-project", api.ReloadProjectController)
+		g.POST("/unload-project", api.UnloadProjectController)
+		g.GET("/load-status/:project_id", api.LoadStatusController)
+		g.GET("/load-job/:job_id", api.LoadJobController)
 		g.POST("/execute-query", api.ExecuteQueryController)
+		g.POST("/execute-mql", api.ExecuteMQLController)
+		g.GET("/catalog/:project_id", api.CatalogController)
 
 		if err := e.Start("localhost:1324"); err != nil {
 			log.Fatalf("Unable to start web server: %s", err)
@@ -79,6 +98,10 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.Flags().StringVar(&snapshotDir, "snapshot-dir", "", "directory to keep project snapshots in and warm start from")
+	rootCmd.Flags().IntVar(&maxProjects, "max-projects", 0, "most projects to keep loaded, least recently used are unloaded (0 for no limit)")
+	rootCmd.Flags().Int64Var(&maxMemoryMB, "max-memory-mb", 0, "approximate memory in MB loaded projects can use, least recently used are unloaded (0 for no limit)")
+	rootCmd.Flags().DurationVar(&snapshotMaxAge, "snapshot-max-age", 0, "reload projects from the database when their snapshot is older than this (0 for no limit)")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -6,10 +6,12 @@ require (
 	github.com/apex/log v1.9.0
 	github.com/labstack/echo/v4 v4.3.0
 	github.com/materials-commons/gomcdb v0.0.0-20210610132919-cd6b83149837
+	github.com/mattn/go-sqlite3 v1.14.6 // indirect
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/spf13/cobra v1.1.3
 	github.com/spf13/viper v1.7.1
-	github.com/subosito/gotenv v1.2.0 // indirect
+	github.com/subosito/gotenv v1.2.0
 	gorm.io/driver/mysql v1.1.0
+	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.21.10
 )
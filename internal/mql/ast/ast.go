@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"strings"
 
 	"github.com/materials-commons/mql/internal/mql/token"
 )
@@ -47,10 +48,16 @@ func (m *MQL) String() string {
 
 /////////////////////////////////////////
 
+// SelectStatement is a complete query. GroupBy, OrderBy, Limit and Offset are empty when the
+// query doesn't have a group by, order by, limit or offset clause.
 type SelectStatement struct {
 	Token               token.Token
 	SelectionStatements []Statement
 	WhereStatement      WhereStatement
+	GroupBy             []Expression
+	OrderBy             []*OrderByField
+	Limit               *IntegerLiteral
+	Offset              *IntegerLiteral
 }
 
 func (s *SelectStatement) statementNode() {
@@ -63,17 +70,156 @@ func (s *SelectStatement) TokenLiteral() string {
 func (s *SelectStatement) String() string {
 	var out bytes.Buffer
 
+	var selections []string
 	for _, st := range s.SelectionStatements {
-		out.WriteString(st.String())
+		selections = append(selections, st.String())
+	}
+
+	out.WriteString("select ")
+	out.WriteString(strings.Join(selections, ", "))
+
+	if len(s.WhereStatement.Statements) != 0 {
+		out.WriteString(" ")
+		out.WriteString(s.WhereStatement.String())
 	}
 
-	out.WriteString(s.WhereStatement.String())
+	if len(s.GroupBy) != 0 {
+		var fields []string
+		for _, field := range s.GroupBy {
+			fields = append(fields, field.String())
+		}
+		out.WriteString(" group by ")
+		out.WriteString(strings.Join(fields, ", "))
+	}
+
+	if len(s.OrderBy) != 0 {
+		var fields []string
+		for _, field := range s.OrderBy {
+			fields = append(fields, field.String())
+		}
+		out.WriteString(" order by ")
+		out.WriteString(strings.Join(fields, ", "))
+	}
+
+	if s.Limit != nil {
+		out.WriteString(" limit " + s.Limit.String())
+	}
+
+	if s.Offset != nil {
+		out.WriteString(" offset " + s.Offset.String())
+	}
 
 	return out.String()
 }
 
 /////////////////////////////////////////
 
+// OrderByField is one of the fields or attributes in an order by clause, as in
+// order by s:a:hardness desc.
+type OrderByField struct {
+	Field      Expression
+	Descending bool
+}
+
+func (f *OrderByField) String() string {
+	if f.Descending {
+		return f.Field.String() + " desc"
+	}
+
+	return f.Field.String()
+}
+
+/////////////////////////////////////////
+
+// ProcessSelectionStatement is the p:[...] portion of a select statement. When Fields
+// is empty all of the process is selected.
+type ProcessSelectionStatement struct {
+	Token  token.Token
+	Fields []Expression
+}
+
+func (s *ProcessSelectionStatement) statementNode() {
+}
+
+func (s *ProcessSelectionStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *ProcessSelectionStatement) String() string {
+	return s.Token.Literal + selectionFieldsString(s.Fields)
+}
+
+/////////////////////////////////////////
+
+// SampleSelectionStatement is the s:[...] portion of a select statement. When Fields
+// is empty all of the sample is selected.
+type SampleSelectionStatement struct {
+	Token  token.Token
+	Fields []Expression
+}
+
+func (s *SampleSelectionStatement) statementNode() {
+}
+
+func (s *SampleSelectionStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *SampleSelectionStatement) String() string {
+	return s.Token.Literal + selectionFieldsString(s.Fields)
+}
+
+// selectionFieldsString writes out the list of fields in a selection. The fields in a
+// selection are implicitly scoped to the process or sample, so the p: or s: is left off.
+func selectionFieldsString(fields []Expression) string {
+	if len(fields) == 0 {
+		return ""
+	}
+
+	var names []string
+	for _, field := range fields {
+		if f, ok := field.(*FieldIdentifier); ok {
+			names = append(names, f.unscopedString())
+		} else {
+			names = append(names, field.String())
+		}
+	}
+
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+/////////////////////////////////////////
+
+// AggregateSelectionStatement is an aggregate function in the selections of a select statement,
+// for example avg(s:a:hardness). Field is nil when a count is of the processes or samples
+// themselves, as in count(s:), in which case Entity is token.PROCESS or token.SAMPLE.
+type AggregateSelectionStatement struct {
+	Token    token.Token
+	Function string
+	Entity   token.TokenType
+	Field    *FieldIdentifier
+}
+
+func (s *AggregateSelectionStatement) statementNode() {
+}
+
+func (s *AggregateSelectionStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *AggregateSelectionStatement) String() string {
+	switch {
+	case s.Field != nil:
+		return s.Function + "(" + s.Field.String() + ")"
+	case s.Entity == token.PROCESS:
+		return s.Function + "(p:)"
+	default:
+		return s.Function + "(s:)"
+	}
+}
+
+/////////////////////////////////////////
+
 type WhereStatement struct {
 	Token      token.Token
 	Statements []Statement
@@ -89,6 +235,7 @@ func (s *WhereStatement) TokenLiteral() string {
 func (s *WhereStatement) String() string {
 	var out bytes.Buffer
 
+	out.WriteString("where ")
 	for _, st := range s.Statements {
 		out.WriteString(st.String())
 	}
@@ -98,9 +245,285 @@ func (s *WhereStatement) String() string {
 
 /////////////////////////////////////////
 
+// ExpressionStatement wraps an expression, such as the conditions in a where clause, so
+// that it can be used where a Statement is expected.
+type ExpressionStatement struct {
+	Token      token.Token
+	Expression Expression
+}
+
+func (s *ExpressionStatement) statementNode() {
+}
+
+func (s *ExpressionStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *ExpressionStatement) String() string {
+	if s.Expression == nil {
+		return ""
+	}
+
+	return s.Expression.String()
+}
+
+/////////////////////////////////////////
+
+// FieldIdentifier references a field (p:name) or an attribute (s:a:hardness) on either a
+// process or a sample. Entity is token.PROCESS or token.SAMPLE. Quantifier is set to "any"
+// or "all" when an attribute is prefixed with one, as in all a:'grain size' > 10.
+type FieldIdentifier struct {
+	Token      token.Token
+	Entity     token.TokenType
+	Attribute  bool
+	Name       string
+	Quantifier string
+}
+
+func (i *FieldIdentifier) expressionNode() {
+}
+
+func (i *FieldIdentifier) TokenLiteral() string {
+	return i.Token.Literal
+}
+
+func (i *FieldIdentifier) String() string {
+	var out bytes.Buffer
+
+	if i.Quantifier != "" {
+		out.WriteString(i.Quantifier + " ")
+	}
+
+	if i.Entity == token.PROCESS {
+		out.WriteString("p:")
+	} else {
+		out.WriteString("s:")
+	}
+
+	out.WriteString(i.unscopedString())
+
+	return out.String()
+}
+
+func (i *FieldIdentifier) unscopedString() string {
+	if i.Attribute {
+		return "a:" + quoteName(i.Name)
+	}
+
+	return quoteName(i.Name)
+}
+
+// quoteName puts single quotes around a name when it contains characters that are not
+// allowed in an unquoted identifier.
+func quoteName(name string) string {
+	for _, ch := range name {
+		if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '_' || ch == '-') {
+			return "'" + name + "'"
+		}
+	}
+
+	return name
+}
+
+/////////////////////////////////////////
+
+// BuiltinExpression is a call to one of the built-in matching functions, for example
+// has-process:"Heat Treatment" or p:has-attribute:'Beam Type'.
+type BuiltinExpression struct {
+	Token    token.Token
+	Entity   token.TokenType
+	Function string
+	Argument Expression
+}
+
+func (e *BuiltinExpression) expressionNode() {
+}
+
+func (e *BuiltinExpression) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *BuiltinExpression) String() string {
+	var out bytes.Buffer
+
+	if e.Entity == token.PROCESS {
+		out.WriteString("p:")
+	} else {
+		out.WriteString("s:")
+	}
+
+	out.WriteString(e.Function + ":")
+	out.WriteString(e.Argument.String())
+
+	return out.String()
+}
+
+/////////////////////////////////////////
+
+// SequenceLiteral is the ordered list of processes a sample has to have gone through for has-sequence,
+// for example ("Solution Treatment", not "Quench", "Aging"). An excluded step is a process that can't
+// come between the steps around it.
+type SequenceLiteral struct {
+	Token token.Token
+	Steps []*SequenceStep
+}
+
+// SequenceStep is a process in a SequenceLiteral. Excluded is true for a step written as not "Quench".
+type SequenceStep struct {
+	Name     *StringLiteral
+	Excluded bool
+}
+
+func (l *SequenceLiteral) expressionNode() {
+}
+
+func (l *SequenceLiteral) TokenLiteral() string {
+	return l.Token.Literal
+}
+
+func (l *SequenceLiteral) String() string {
+	var steps []string
+	for _, step := range l.Steps {
+		if step.Excluded {
+			steps = append(steps, "not "+step.Name.String())
+		} else {
+			steps = append(steps, step.Name.String())
+		}
+	}
+
+	return "(" + strings.Join(steps, ", ") + ")"
+}
+
+/////////////////////////////////////////
+
+// ScopeExpression groups conditions that have to be satisfied together, for example
+// same-state(a:hardness > 5 and a:phase = "beta") requires both conditions to hold in
+// the same state of a sample. Scope is the keyword, same-state or same-sample.
+type ScopeExpression struct {
+	Token      token.Token
+	Scope      string
+	Expression Expression
+}
+
+func (e *ScopeExpression) expressionNode() {
+}
+
+func (e *ScopeExpression) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *ScopeExpression) String() string {
+	return e.Scope + "(" + e.Expression.String() + ")"
+}
+
+/////////////////////////////////////////
+
+// LineageExpression matches the processes and samples upstream or downstream of the ones matching
+// Expression, for example downstream(p:name = "Casting") or upstream(s:name = "S1" within 2).
+// Direction is the keyword, upstream or downstream. Within is nil when the number of steps isn't
+// limited.
+type LineageExpression struct {
+	Token      token.Token
+	Direction  string
+	Expression Expression
+	Within     *IntegerLiteral
+}
+
+func (e *LineageExpression) expressionNode() {
+}
+
+func (e *LineageExpression) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *LineageExpression) String() string {
+	if e.Within != nil {
+		return e.Direction + "(" + e.Expression.String() + " within " + e.Within.String() + ")"
+	}
+
+	return e.Direction + "(" + e.Expression.String() + ")"
+}
+
+/////////////////////////////////////////
+
+// InExpression tests whether a field or attribute equals one of a list of values, for example
+// p:name in ("EBSD", "SEM", "TEM").
+type InExpression struct {
+	Token  token.Token
+	Left   Expression
+	Values []Expression
+}
+
+func (e *InExpression) expressionNode() {
+}
+
+func (e *InExpression) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *InExpression) String() string {
+	var values []string
+	for _, value := range e.Values {
+		values = append(values, value.String())
+	}
+
+	return "(" + e.Left.String() + " in (" + strings.Join(values, ", ") + "))"
+}
+
+/////////////////////////////////////////
+
+// BetweenExpression tests whether a field or attribute is within an inclusive range, for example
+// a:temperature between 400 and 600.
+type BetweenExpression struct {
+	Token token.Token
+	Left  Expression
+	Lower Expression
+	Upper Expression
+}
+
+func (e *BetweenExpression) expressionNode() {
+}
+
+func (e *BetweenExpression) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *BetweenExpression) String() string {
+	return "(" + e.Left.String() + " between " + e.Lower.String() + " and " + e.Upper.String() + ")"
+}
+
+/////////////////////////////////////////
+
+// NullExpression tests whether a field or attribute has a value, as in a:'grain size' is null
+// or a:'grain size' is not null.
+type NullExpression struct {
+	Token token.Token
+	Left  Expression
+	Not   bool
+}
+
+func (e *NullExpression) expressionNode() {
+}
+
+func (e *NullExpression) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *NullExpression) String() string {
+	if e.Not {
+		return "(" + e.Left.String() + " is not null)"
+	}
+
+	return "(" + e.Left.String() + " is null)"
+}
+
+/////////////////////////////////////////
+
+// IntegerLiteral is an integer with an optional unit, as in 500 C.
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Unit  string
 }
 
 func (l *IntegerLiteral) expressionNode() {
@@ -110,14 +533,16 @@ func (l *IntegerLiteral) TokenLiteral() string {
 	return l.Token.Literal
 }
 func (l *IntegerLiteral) String() string {
-	return l.Token.Literal
+	return l.Token.Literal + unitString(l.Unit)
 }
 
 /////////////////////////////////////////
 
+// FloatLiteral is a floating point number with an optional unit, as in 2.5 mm.
 type FloatLiteral struct {
 	Token token.Token
 	Value float64
+	Unit  string
 }
 
 func (l *FloatLiteral) expressionNode() {
@@ -127,7 +552,15 @@ func (l *FloatLiteral) TokenLiteral() string {
 	return l.Token.Literal
 }
 func (l *FloatLiteral) String() string {
-	return l.Token.Literal
+	return l.Token.Literal + unitString(l.Unit)
+}
+
+func unitString(unit string) string {
+	if unit == "" {
+		return ""
+	}
+
+	return " " + quoteName(unit)
 }
 
 /////////////////////////////////////////
@@ -145,7 +578,7 @@ func (l *StringLiteral) TokenLiteral() string {
 }
 
 func (l *StringLiteral) String() string {
-	return l.Token.Literal
+	return `"` + l.Value + `"`
 }
 
 /////////////////////////////////////////
@@ -168,7 +601,7 @@ func (e *PrefixExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("(")
-	out.WriteString(e.Operator)
+	out.WriteString(e.Operator + " ")
 	out.WriteString(e.Right.String())
 	out.WriteString(")")
 
@@ -0,0 +1,505 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/materials-commons/mql/internal/mql/ast"
+	"github.com/materials-commons/mql/internal/mql/token"
+	"github.com/materials-commons/mql/internal/mqldb"
+)
+
+// Compile lowers a parsed select statement into the selection and statement tree that mqldb
+// evaluates. A select statement without a where clause compiles to a nil statement, which
+// mqldb treats as matching everything.
+func Compile(statement *ast.SelectStatement) (mqldb.Selection, mqldb.Statement, error) {
+	selection, err := compileSelection(statement.SelectionStatements)
+	if err != nil {
+		return selection, nil, err
+	}
+
+	if err := compileGroupBy(statement, &selection); err != nil {
+		return selection, nil, err
+	}
+
+	if err := compileOrderByAndLimit(statement, &selection); err != nil {
+		return selection, nil, err
+	}
+
+	if err := mqldb.ValidateAggregates(selection); err != nil {
+		return selection, nil, err
+	}
+
+	if len(statement.WhereStatement.Statements) == 0 {
+		return selection, nil, nil
+	}
+
+	if len(statement.WhereStatement.Statements) != 1 {
+		return selection, nil, fmt.Errorf("where clause can only contain a single expression")
+	}
+
+	where, ok := statement.WhereStatement.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		return selection, nil, fmt.Errorf("unexpected statement in where clause: %s", statement.WhereStatement.Statements[0])
+	}
+
+	s, err := compileExpression(where.Expression)
+	if err != nil {
+		return selection, nil, err
+	}
+
+	// Catch problems such as an invalid regular expression now rather than when the query runs.
+	if err := mqldb.ValidateStatement(s); err != nil {
+		return selection, nil, err
+	}
+
+	return selection, s, nil
+}
+
+// compileSelection builds the Selection from the p:[...] and s:[...] statements. The evaluator
+// returns whole processes and samples, so a p: or s: always sets All. The listed fields and
+// attributes are recorded as well.
+func compileSelection(statements []ast.Statement) (mqldb.Selection, error) {
+	var selection mqldb.Selection
+	for _, statement := range statements {
+		switch s := statement.(type) {
+		case *ast.ProcessSelectionStatement:
+			selection.ProcessSelection.All = true
+			for _, field := range s.Fields {
+				f := field.(*ast.FieldIdentifier)
+				switch {
+				case f.Attribute:
+					selection.ProcessSelection.Attributes = append(selection.ProcessSelection.Attributes, f.Name)
+				case f.Name == "name":
+					selection.ProcessSelection.Name = true
+				case f.Name == "id":
+					selection.ProcessSelection.ID = true
+				default:
+					return selection, fmt.Errorf("unknown process field %q", f.Name)
+				}
+			}
+		case *ast.SampleSelectionStatement:
+			selection.SampleSelection.All = true
+			for _, field := range s.Fields {
+				f := field.(*ast.FieldIdentifier)
+				switch {
+				case f.Attribute:
+					selection.SampleSelection.Attributes = append(selection.SampleSelection.Attributes, f.Name)
+				case f.Name == "name":
+					selection.SampleSelection.Name = true
+				case f.Name == "id":
+					selection.SampleSelection.ID = true
+				default:
+					return selection, fmt.Errorf("unknown sample field %q", f.Name)
+				}
+			}
+		case *ast.AggregateSelectionStatement:
+			aggregate, err := compileAggregate(s)
+			if err != nil {
+				return selection, err
+			}
+			selection.Aggregates = append(selection.Aggregates, aggregate)
+		default:
+			return selection, fmt.Errorf("unexpected selection %s", statement)
+		}
+	}
+
+	if len(selection.Aggregates) != 0 && (selection.ProcessSelection.All || selection.SampleSelection.All) {
+		return selection, fmt.Errorf("can't select p: or s: along with aggregates, use group by to add fields to the results")
+	}
+
+	return selection, nil
+}
+
+// compileAggregate turns an aggregate such as avg(s:a:hardness) into an mqldb.Aggregate. count(p:) and
+// count(s:) have no field and are given the process or sample field type.
+func compileAggregate(s *ast.AggregateSelectionStatement) (mqldb.Aggregate, error) {
+	aggregate := mqldb.Aggregate{Function: s.Function}
+	if s.Field == nil {
+		aggregate.FieldType = mqldb.SampleFieldType
+		if s.Entity == token.PROCESS {
+			aggregate.FieldType = mqldb.ProcessFieldType
+		}
+		return aggregate, nil
+	}
+
+	if s.Field.Quantifier != "" {
+		return aggregate, fmt.Errorf("can't use %s in %s, any and all only apply to conditions", s.Field.Quantifier, s)
+	}
+
+	fieldType, err := fieldTypeOf(s.Field)
+	if err != nil {
+		return aggregate, err
+	}
+
+	aggregate.FieldType = fieldType
+	aggregate.FieldName = s.Field.Name
+	return aggregate, nil
+}
+
+// compileGroupBy adds the group by clause to the selection.
+func compileGroupBy(statement *ast.SelectStatement, selection *mqldb.Selection) error {
+	for _, groupByField := range statement.GroupBy {
+		field := groupByField.(*ast.FieldIdentifier)
+		if field.Quantifier != "" {
+			return fmt.Errorf("can't group by %s, any and all only apply to conditions", field)
+		}
+
+		fieldType, err := fieldTypeOf(field)
+		if err != nil {
+			return err
+		}
+
+		selection.GroupBy = append(selection.GroupBy, mqldb.GroupBy{FieldType: fieldType, FieldName: field.Name})
+	}
+
+	return nil
+}
+
+// compileOrderByAndLimit adds the order by, limit and offset clauses to the selection.
+func compileOrderByAndLimit(statement *ast.SelectStatement, selection *mqldb.Selection) error {
+	for _, orderByField := range statement.OrderBy {
+		field := orderByField.Field.(*ast.FieldIdentifier)
+		if field.Quantifier != "" {
+			return fmt.Errorf("can't order by %s, any and all only apply to conditions", field)
+		}
+
+		fieldType, err := fieldTypeOf(field)
+		if err != nil {
+			return err
+		}
+
+		selection.OrderBy = append(selection.OrderBy, mqldb.OrderBy{
+			FieldType:  fieldType,
+			FieldName:  field.Name,
+			Descending: orderByField.Descending,
+		})
+	}
+
+	if statement.Limit != nil {
+		if statement.Limit.Value == 0 {
+			return fmt.Errorf("limit must be at least 1")
+		}
+		selection.Limit = int(statement.Limit.Value)
+	}
+
+	if statement.Offset != nil {
+		selection.Offset = int(statement.Offset.Value)
+	}
+
+	return nil
+}
+
+// compileExpression recursively lowers a where clause expression into a statement.
+func compileExpression(expression ast.Expression) (mqldb.Statement, error) {
+	switch e := expression.(type) {
+	case *ast.InfixExpression:
+		return compileInfixExpression(e)
+	case *ast.BuiltinExpression:
+		return compileBuiltinExpression(e)
+	case *ast.PrefixExpression:
+		return compilePrefixExpression(e)
+	case *ast.ScopeExpression:
+		return compileScopeExpression(e)
+	case *ast.LineageExpression:
+		return compileLineageExpression(e)
+	case *ast.InExpression:
+		return compileListMatch(e, e.Left, "in", e.Values)
+	case *ast.BetweenExpression:
+		return compileListMatch(e, e.Left, "between", []ast.Expression{e.Lower, e.Upper})
+	case *ast.NullExpression:
+		return compileNullExpression(e)
+	default:
+		return nil, fmt.Errorf("%s is not a condition", expression)
+	}
+}
+
+func compilePrefixExpression(e *ast.PrefixExpression) (mqldb.Statement, error) {
+	if e.Operator != "not" {
+		return nil, fmt.Errorf("unknown operator %s", e.Operator)
+	}
+
+	statement, err := compileExpression(e.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	return mqldb.NotStatement{Statement: statement}, nil
+}
+
+func compileScopeExpression(e *ast.ScopeExpression) (mqldb.Statement, error) {
+	statement, err := compileExpression(e.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Scope {
+	case "same-state":
+		return mqldb.SameStateStatement{Statement: statement}, nil
+	case "same-sample":
+		return mqldb.SameSampleStatement{Statement: statement}, nil
+	default:
+		return nil, fmt.Errorf("unknown scope %s", e.Scope)
+	}
+}
+
+func compileLineageExpression(e *ast.LineageExpression) (mqldb.Statement, error) {
+	statement, err := compileExpression(e.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	lineage := mqldb.LineageStatement{Direction: e.Direction, Statement: statement}
+	if e.Within != nil {
+		if e.Within.Value == 0 {
+			return nil, fmt.Errorf("%s must be within at least 1 step", e.Direction)
+		}
+		lineage.MaxSteps = int(e.Within.Value)
+	}
+
+	return lineage, nil
+}
+
+func compileInfixExpression(e *ast.InfixExpression) (mqldb.Statement, error) {
+	switch e.Operator {
+	case "and":
+		left, right, err := compileOperands(e)
+		if err != nil {
+			return nil, err
+		}
+		return mqldb.AndStatement{Left: left, Right: right}, nil
+	case "or":
+		left, right, err := compileOperands(e)
+		if err != nil {
+			return nil, err
+		}
+		return mqldb.OrStatement{Left: left, Right: right}, nil
+	default:
+		return compileComparison(e)
+	}
+}
+
+func compileOperands(e *ast.InfixExpression) (mqldb.Statement, mqldb.Statement, error) {
+	left, err := compileExpression(e.Left)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	right, err := compileExpression(e.Right)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return left, right, nil
+}
+
+// flippedOperators maps an operator to its equivalent when the operands are swapped. It is used
+// to turn 5 < a:hardness into a:hardness > 5. The string pattern operators have no flipped form
+// and so are missing from the map.
+var flippedOperators = map[string]string{
+	"=":  "=",
+	"<>": "<>",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+// compileComparison turns a comparison between a field and a value into a MatchStatement.
+func compileComparison(e *ast.InfixExpression) (mqldb.Statement, error) {
+	operator := e.Operator
+	field, ok := e.Left.(*ast.FieldIdentifier)
+	valueExpression := e.Right
+	if !ok {
+		if field, ok = e.Right.(*ast.FieldIdentifier); !ok {
+			return nil, fmt.Errorf("%s does not compare a field or attribute", e)
+		}
+		valueExpression = e.Left
+		if operator, ok = flippedOperators[operator]; !ok {
+			return nil, fmt.Errorf("%s must have the field or attribute on the left", e)
+		}
+	}
+
+	value, err := compileValue(valueExpression)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, isString := value.(string); isStringOperator(operator) && !isString {
+		return nil, fmt.Errorf("%s requires a string pattern", e)
+	}
+
+	fieldType, err := fieldTypeOf(field)
+	if err != nil {
+		return nil, err
+	}
+
+	return mqldb.MatchStatement{
+		FieldType:  fieldType,
+		FieldName:  field.Name,
+		Operation:  operator,
+		Value:      value,
+		Quantifier: field.Quantifier,
+		Unit:       unitOf(valueExpression),
+	}, nil
+}
+
+// compileListMatch turns an in or between expression into a MatchStatement whose value is the list of
+// values, or for between the lower and upper bound.
+func compileListMatch(e ast.Expression, left ast.Expression, operator string, valueExpressions []ast.Expression) (mqldb.Statement, error) {
+	field, ok := left.(*ast.FieldIdentifier)
+	if !ok {
+		return nil, fmt.Errorf("%s must have a field or attribute on the left", e)
+	}
+
+	fieldType, err := fieldTypeOf(field)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	for _, valueExpression := range valueExpressions {
+		value, err := compileValue(valueExpression)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	// The values are compared in a single unit, so they all have to be given in the same one
+	unit := unitOf(valueExpressions[0])
+	for _, valueExpression := range valueExpressions[1:] {
+		if unitOf(valueExpression) != unit {
+			return nil, fmt.Errorf("%s must use the same unit for every value", e)
+		}
+	}
+
+	return mqldb.MatchStatement{
+		FieldType:  fieldType,
+		FieldName:  field.Name,
+		Operation:  operator,
+		Value:      values,
+		Quantifier: field.Quantifier,
+		Unit:       unit,
+	}, nil
+}
+
+// isStringOperator returns true for the operators that only apply to strings.
+func isStringOperator(operator string) bool {
+	switch operator {
+	case "like", "ilike", "contains", "starts-with", "~":
+		return true
+	default:
+		return false
+	}
+}
+
+// fieldTypeOf maps a p:, s: or a: reference to the mqldb field type it matches against.
+func fieldTypeOf(field *ast.FieldIdentifier) (int, error) {
+	if !field.Attribute && field.Name != "name" && field.Name != "id" {
+		return 0, fmt.Errorf("unknown field %s, only name and id are supported", field)
+	}
+
+	switch {
+	case field.Entity == token.PROCESS && field.Attribute:
+		return mqldb.ProcessAttributeFieldType, nil
+	case field.Entity == token.PROCESS:
+		return mqldb.ProcessFieldType, nil
+	case field.Attribute:
+		return mqldb.SampleAttributeFieldType, nil
+	default:
+		return mqldb.SampleFieldType, nil
+	}
+}
+
+// compileValue converts a literal into the value type the mqldb matchers expect.
+func compileValue(expression ast.Expression) (interface{}, error) {
+	switch e := expression.(type) {
+	case *ast.IntegerLiteral:
+		return int(e.Value), nil
+	case *ast.FloatLiteral:
+		return e.Value, nil
+	case *ast.StringLiteral:
+		return e.Value, nil
+	default:
+		return nil, fmt.Errorf("%s is not a value", expression)
+	}
+}
+
+// compileNullExpression turns is null and is not null into a MatchStatement without a value.
+func compileNullExpression(e *ast.NullExpression) (mqldb.Statement, error) {
+	field, ok := e.Left.(*ast.FieldIdentifier)
+	if !ok {
+		return nil, fmt.Errorf("%s must have a field or attribute on the left", e)
+	}
+
+	if field.Quantifier != "" {
+		return nil, fmt.Errorf("%s can't use %s, null checks apply to all of the values", e, field.Quantifier)
+	}
+
+	fieldType, err := fieldTypeOf(field)
+	if err != nil {
+		return nil, err
+	}
+
+	operator := "is-null"
+	if e.Not {
+		operator = "is-not-null"
+	}
+
+	return mqldb.MatchStatement{
+		FieldType: fieldType,
+		FieldName: field.Name,
+		Operation: operator,
+	}, nil
+}
+
+// unitOf returns the unit given with a number, as in 500 C, or "" when there isn't one.
+func unitOf(expression ast.Expression) string {
+	switch e := expression.(type) {
+	case *ast.IntegerLiteral:
+		return e.Unit
+	case *ast.FloatLiteral:
+		return e.Unit
+	default:
+		return ""
+	}
+}
+
+func compileBuiltinExpression(e *ast.BuiltinExpression) (mqldb.Statement, error) {
+	var (
+		value interface{}
+		err   error
+	)
+
+	if sequence, ok := e.Argument.(*ast.SequenceLiteral); ok {
+		value = compileSequence(sequence)
+	} else if value, err = compileValue(e.Argument); err != nil {
+		return nil, err
+	}
+
+	fieldType := mqldb.SampleFuncType
+	if e.Entity == token.PROCESS {
+		fieldType = mqldb.ProcessFuncType
+	}
+
+	return mqldb.MatchStatement{
+		FieldType: fieldType,
+		Operation: e.Function,
+		Value:     value,
+	}, nil
+}
+
+// compileSequence turns the steps of a has-sequence into the list mqldb expects, where a process is its
+// name and an excluded process is {"not": name}.
+func compileSequence(sequence *ast.SequenceLiteral) []interface{} {
+	var steps []interface{}
+	for _, step := range sequence.Steps {
+		if step.Excluded {
+			steps = append(steps, map[string]interface{}{"not": step.Name.Value})
+		} else {
+			steps = append(steps, step.Name.Value)
+		}
+	}
+
+	return steps
+}
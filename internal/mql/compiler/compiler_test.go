@@ -0,0 +1,366 @@
+package compiler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/materials-commons/mql/internal/mql/ast"
+	"github.com/materials-commons/mql/internal/mql/lexer"
+	"github.com/materials-commons/mql/internal/mql/parser"
+	"github.com/materials-commons/mql/internal/mqldb"
+)
+
+func TestCompileSelection(t *testing.T) {
+	selection, statement := mustCompile(t, `select p:[name, a:time], s:`)
+
+	expected := mqldb.Selection{
+		ProcessSelection: mqldb.ProcessSelection{All: true, Name: true, Attributes: []string{"time"}},
+		SampleSelection:  mqldb.SampleSelection{All: true},
+	}
+
+	if !reflect.DeepEqual(selection, expected) {
+		t.Fatalf("Expected selection %+v, got %+v", expected, selection)
+	}
+
+	if statement != nil {
+		t.Fatalf("Expected nil statement for select without where, got %+v", statement)
+	}
+}
+
+func TestCompileWhere(t *testing.T) {
+	_, statement := mustCompile(t,
+		`select s: where (p:name = "EBSD" or 3 < p:a:'frames per second') and a:hardness >= 1 and has-process:"Texture"`)
+
+	expected := mqldb.AndStatement{
+		Left: mqldb.AndStatement{
+			Left: mqldb.OrStatement{
+				Left: mqldb.MatchStatement{
+					FieldType: mqldb.ProcessFieldType,
+					FieldName: "name",
+					Operation: "=",
+					Value:     "EBSD",
+				},
+				Right: mqldb.MatchStatement{
+					FieldType: mqldb.ProcessAttributeFieldType,
+					FieldName: "frames per second",
+					Operation: ">",
+					Value:     3,
+				},
+			},
+			Right: mqldb.MatchStatement{
+				FieldType: mqldb.SampleAttributeFieldType,
+				FieldName: "hardness",
+				Operation: ">=",
+				Value:     1,
+			},
+		},
+		Right: mqldb.MatchStatement{
+			FieldType: mqldb.SampleFuncType,
+			Operation: "has-process",
+			Value:     "Texture",
+		},
+	}
+
+	if !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected statement\n%+v\ngot\n%+v", expected, statement)
+	}
+}
+
+func TestCompileNumbers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`select s: where a:zn = 0.5`, 0.5},
+		{`select s: where a:strain > -3`, -3},
+		{`select s: where a:strain > -0.02`, -0.02},
+		{`select s: where a:'lattice parameter' < 2.7e-4`, 2.7e-4},
+		{`select s: where a:hardness = 010`, 10},
+	}
+
+	for _, test := range tests {
+		_, statement := mustCompile(t, test.input)
+		if value := statement.(mqldb.MatchStatement).Value; value != test.expected {
+			t.Errorf("For %q expected value %v (%T), got %v (%T)", test.input, test.expected, test.expected, value, value)
+		}
+	}
+}
+
+func TestCompileUnits(t *testing.T) {
+	_, statement := mustCompile(t, `select p: where p:a:temperature > 500 C or p:a:length in (2.5 mm, 3 mm)`)
+
+	expected := mqldb.OrStatement{
+		Left: mqldb.MatchStatement{
+			FieldType: mqldb.ProcessAttributeFieldType,
+			FieldName: "temperature",
+			Operation: ">",
+			Value:     500,
+			Unit:      "C",
+		},
+		Right: mqldb.MatchStatement{
+			FieldType: mqldb.ProcessAttributeFieldType,
+			FieldName: "length",
+			Operation: "in",
+			Value:     []interface{}{2.5, 3},
+			Unit:      "mm",
+		},
+	}
+
+	if !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected statement %+v, got %+v", expected, statement)
+	}
+}
+
+func TestCompileNull(t *testing.T) {
+	_, statement := mustCompile(t, `select s: where a:'grain size' is null and p:a:note is not null`)
+
+	expected := mqldb.AndStatement{
+		Left: mqldb.MatchStatement{
+			FieldType: mqldb.SampleAttributeFieldType,
+			FieldName: "grain size",
+			Operation: "is-null",
+		},
+		Right: mqldb.MatchStatement{
+			FieldType: mqldb.ProcessAttributeFieldType,
+			FieldName: "note",
+			Operation: "is-not-null",
+		},
+	}
+
+	if !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected statement %+v, got %+v", expected, statement)
+	}
+}
+
+func TestCompileOrderByAndLimit(t *testing.T) {
+	selection, _ := mustCompile(t, `select p:, s: order by p:a:temperature desc, s:name limit 25 offset 50`)
+
+	expected := []mqldb.OrderBy{
+		{FieldType: mqldb.ProcessAttributeFieldType, FieldName: "temperature", Descending: true},
+		{FieldType: mqldb.SampleFieldType, FieldName: "name"},
+	}
+
+	if !reflect.DeepEqual(selection.OrderBy, expected) {
+		t.Fatalf("Expected order by %+v, got %+v", expected, selection.OrderBy)
+	}
+
+	if selection.Limit != 25 || selection.Offset != 50 {
+		t.Fatalf("Expected limit 25 offset 50, got limit %d offset %d", selection.Limit, selection.Offset)
+	}
+}
+
+func TestCompileHasSequence(t *testing.T) {
+	_, statement := mustCompile(t, `select s: where has-sequence:("Solution Treatment", not "Quench", "Aging")`)
+
+	expected := mqldb.MatchStatement{
+		FieldType: mqldb.SampleFuncType,
+		Operation: "has-sequence",
+		Value:     []interface{}{"Solution Treatment", map[string]interface{}{"not": "Quench"}, "Aging"},
+	}
+	if !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, statement)
+	}
+}
+
+func TestCompileLineage(t *testing.T) {
+	_, statement := mustCompile(t, `select s: where upstream(p:name = "Casting" within 3)`)
+
+	expected := mqldb.LineageStatement{
+		Direction: mqldb.LineageUpstream,
+		Statement: mqldb.MatchStatement{FieldType: mqldb.ProcessFieldType, FieldName: "name", Operation: "=", Value: "Casting"},
+		MaxSteps:  3,
+	}
+	if !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, statement)
+	}
+}
+
+func TestCompileAggregates(t *testing.T) {
+	selection, _ := mustCompile(t, `select count(p:), count(s:), avg(s:a:hardness) group by p:name, a:phase order by a:phase desc`)
+
+	expectedAggregates := []mqldb.Aggregate{
+		{Function: "count", FieldType: mqldb.ProcessFieldType},
+		{Function: "count", FieldType: mqldb.SampleFieldType},
+		{Function: "avg", FieldType: mqldb.SampleAttributeFieldType, FieldName: "hardness"},
+	}
+	if !reflect.DeepEqual(selection.Aggregates, expectedAggregates) {
+		t.Fatalf("Expected aggregates %+v, got %+v", expectedAggregates, selection.Aggregates)
+	}
+
+	expectedGroupBy := []mqldb.GroupBy{
+		{FieldType: mqldb.ProcessFieldType, FieldName: "name"},
+		{FieldType: mqldb.SampleAttributeFieldType, FieldName: "phase"},
+	}
+	if !reflect.DeepEqual(selection.GroupBy, expectedGroupBy) {
+		t.Fatalf("Expected group by %+v, got %+v", expectedGroupBy, selection.GroupBy)
+	}
+
+	if selection.ProcessSelection.All || selection.SampleSelection.All {
+		t.Fatalf("Expected an aggregate query not to select processes or samples, got %+v", selection)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []string{
+		`select p:[color]`,
+		`select s: where s:color = "red"`,
+		`select s: where a:zn = a:mg`,
+		`select s: where "a" = "b"`,
+		`select s: where "S%" like s:name`,
+		`select s: where a:phase contains 5`,
+		`select s: where s:name ~ "S[1"`,
+		`select s: where "S1" in (s:name)`,
+		`select p: where p:a:temperature between 400 C and 600 K`,
+		`select s: where all a:'grain size' is null`,
+		`select s: limit 0`,
+		`select s: order by all a:zn`,
+		`select s: order by s:color`,
+		`select s: where downstream(p:name = "Casting" within 0)`,
+		`select s: group by s:name`,
+		`select s:, count(s:)`,
+		`select sum(s:)`,
+		`select avg(s:color)`,
+		`select count(all a:zn)`,
+		`select count(s:) group by any a:zn`,
+		`select count(s:) group by s:name order by a:zn`,
+		`select s: where a:temperature > 500 Cx`,
+		`select s: where a:length between 2 Mm and 3 Mm`,
+	}
+
+	for _, input := range tests {
+		if _, _, err := Compile(mustParse(t, input)); err == nil {
+			t.Errorf("Expected compile error for %q", input)
+		}
+	}
+}
+
+func TestCompilePatternOperators(t *testing.T) {
+	tests := []struct {
+		input     string
+		operation string
+		value     string
+	}{
+		{`select s: where s:name like "S%"`, "like", "S%"},
+		{`select s: where s:name ilike "s_"`, "ilike", "s_"},
+		{`select s: where s:name contains "1"`, "contains", "1"},
+		{`select s: where s:name starts-with "S"`, "starts-with", "S"},
+		{`select s: where s:name ~ "^S[0-9]+$"`, "~", "^S[0-9]+$"},
+	}
+
+	for _, test := range tests {
+		_, statement := mustCompile(t, test.input)
+		match, ok := statement.(mqldb.MatchStatement)
+		if !ok {
+			t.Fatalf("For %q expected a MatchStatement, got %T", test.input, statement)
+		}
+
+		if match.Operation != test.operation || match.Value != test.value || match.FieldName != "name" {
+			t.Errorf("For %q expected %s %q on name, got %+v", test.input, test.operation, test.value, match)
+		}
+	}
+}
+
+func TestCompileInAndBetween(t *testing.T) {
+	_, statement := mustCompile(t, `select p: where p:name in ("EBSD", "SEM") and all a:'grain size' between 10 and 20`)
+
+	expected := mqldb.AndStatement{
+		Left: mqldb.MatchStatement{
+			FieldType: mqldb.ProcessFieldType,
+			FieldName: "name",
+			Operation: "in",
+			Value:     []interface{}{"EBSD", "SEM"},
+		},
+		Right: mqldb.MatchStatement{
+			FieldType:  mqldb.SampleAttributeFieldType,
+			FieldName:  "grain size",
+			Operation:  "between",
+			Value:      []interface{}{10, 20},
+			Quantifier: mqldb.QuantifierAll,
+		},
+	}
+
+	if !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected statement %+v, got %+v", expected, statement)
+	}
+}
+
+func TestCompileNot(t *testing.T) {
+	_, statement := mustCompile(t, `select s: where not has-process:"Heat Treatment"`)
+
+	expected := mqldb.NotStatement{
+		Statement: mqldb.MatchStatement{
+			FieldType: mqldb.SampleFuncType,
+			Operation: "has-process",
+			Value:     "Heat Treatment",
+		},
+	}
+
+	if !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected statement %+v, got %+v", expected, statement)
+	}
+}
+
+func TestCompileQuantifier(t *testing.T) {
+	_, statement := mustCompile(t, `select s: where all a:'grain size' > 10`)
+
+	expected := mqldb.MatchStatement{
+		FieldType:  mqldb.SampleAttributeFieldType,
+		FieldName:  "grain size",
+		Operation:  ">",
+		Value:      10,
+		Quantifier: mqldb.QuantifierAll,
+	}
+
+	if !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected statement %+v, got %+v", expected, statement)
+	}
+}
+
+func TestCompileScopes(t *testing.T) {
+	_, statement := mustCompile(t, `select p: where same-sample(has-process:"Aging" and same-state(a:hardness > 5))`)
+
+	expected := mqldb.SameSampleStatement{
+		Statement: mqldb.AndStatement{
+			Left: mqldb.MatchStatement{
+				FieldType: mqldb.SampleFuncType,
+				Operation: "has-process",
+				Value:     "Aging",
+			},
+			Right: mqldb.SameStateStatement{
+				Statement: mqldb.MatchStatement{
+					FieldType: mqldb.SampleAttributeFieldType,
+					FieldName: "hardness",
+					Operation: ">",
+					Value:     5,
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected statement %+v, got %+v", expected, statement)
+	}
+}
+
+func mustCompile(t *testing.T, input string) (mqldb.Selection, mqldb.Statement) {
+	t.Helper()
+
+	selection, statement, err := Compile(mustParse(t, input))
+	if err != nil {
+		t.Fatalf("Compiling %q failed: %s", input, err)
+	}
+
+	return selection, statement
+}
+
+func mustParse(t *testing.T, input string) *ast.SelectStatement {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	mql := p.ParseMQL()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("Parsing %q failed: %v", input, p.Errors())
+	}
+
+	return mql.Statements[0].(*ast.SelectStatement)
+}
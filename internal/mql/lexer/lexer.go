@@ -7,10 +7,12 @@ type Lexer struct {
 	curPosition  int // current position in input (points to current char)
 	readPosition int // current reading position, but not current position so this is "peeking" ahead
 	ch           byte
+	line         int // line of the current char
+	column       int // column of the current char
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
@@ -19,6 +21,7 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	offset, line, column := l.curPosition, l.line, l.column
 
 	switch l.ch {
 	case '=':
@@ -41,6 +44,8 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.GT, l.ch)
 		}
+	case '~':
+		tok = newToken(token.MATCHES, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '[':
@@ -55,34 +60,56 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.SEMICOLON, l.ch)
 	case '"':
 		tok = newTokenStr(token.STRING, l.readString())
+		if l.ch == 0 {
+			// Hit the end of input before the closing quote
+			tok = newTokenStr(token.ILLEGAL, `"`+tok.Literal)
+		}
 	case '\'':
 		tok = newTokenStr(token.IDENT, l.readQuotedIdentifier())
+		if l.ch == 0 {
+			// Hit the end of input before the closing quote
+			tok = newTokenStr(token.ILLEGAL, "'"+tok.Literal)
+		}
 	case 0:
 		tok = newTokenStr(token.EOF, "")
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Offset, tok.Line, tok.Column = offset, line, column
+			return tok
+		} else if l.isNumberStart() {
+			// A unit following the number (500 C) is lexed as a separate identifier
+			tok = newTokenStr(l.readNumber())
+			tok.Offset, tok.Line, tok.Column = offset, line, column
 			return tok
-		} else if isDigit(l.ch) {
-			// TODO: Add support for float point type
-			// TODO: Add support for units
-			return newTokenStr(token.INT, l.readNumber())
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
 	}
 
+	tok.Offset, tok.Line, tok.Column = offset, line, column
 	l.readChar() // advance
 	return tok
 }
 
+// Input returns the text being tokenized.
+func (l *Lexer) Input() string {
+	return l.input
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
 		l.ch = l.input[l.readPosition]
 	}
+	l.column++
 
 	// Advance current
 	l.curPosition = l.readPosition
@@ -103,13 +130,79 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPosition]
 }
 
-func (l *Lexer) readNumber() string {
+// peekCharAt returns the char n chars past the current char, so peekCharAt(1) is the same as peekChar().
+func (l *Lexer) peekCharAt(n int) byte {
+	position := l.curPosition + n
+	if position >= len(l.input) {
+		return 0
+	}
+
+	return l.input[position]
+}
+
+// isNumberStart returns true when the current char starts a number: a digit, or a - or . followed by
+// a digit (-3, .5, -.5).
+func (l *Lexer) isNumberStart() bool {
+	switch {
+	case isDigit(l.ch):
+		return true
+	case l.ch == '.':
+		return isDigit(l.peekChar())
+	case l.ch == '-' && l.peekChar() == '.':
+		return isDigit(l.peekCharAt(2))
+	case l.ch == '-':
+		return isDigit(l.peekChar())
+	default:
+		return false
+	}
+}
+
+// readNumber reads an integer or a floating point number. A number can have a leading minus sign,
+// a fraction and an exponent, for example -3, 1.5, .5 and 2.7e-4. Numbers with a fraction or an
+// exponent are returned as token.FLOAT, all others as token.INT.
+func (l *Lexer) readNumber() (token.TokenType, string) {
+	var tokenType token.TokenType = token.INT
 	position := l.curPosition
-	for isDigit(l.ch) {
+
+	if l.ch == '-' {
 		l.readChar()
 	}
 
-	return l.input[position:l.curPosition]
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if (l.ch == 'e' || l.ch == 'E') && l.isExponentStart() {
+		tokenType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		l.readDigits()
+	}
+
+	return tokenType, l.input[position:l.curPosition]
+}
+
+// isExponentStart returns true when the e or E at the current char is followed by the digits of an
+// exponent, with an optional sign. Otherwise the e isn't part of the number.
+func (l *Lexer) isExponentStart() bool {
+	next := l.peekChar()
+	if next == '+' || next == '-' {
+		next = l.peekCharAt(2)
+	}
+
+	return isDigit(next)
+}
+
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) {
+		l.readChar()
+	}
 }
 
 func (l *Lexer) readString() string {
@@ -156,9 +249,7 @@ func (l *Lexer) isUnquotedIdentifierChar(ch byte) bool {
 }
 
 func (l *Lexer) readQuotedIdentifier() string {
-	// Advance past first quote
-	l.readChar()
-	position := l.curPosition
+	position := l.curPosition + 1
 	for {
 		l.readChar()
 		if l.ch == '\'' || l.ch == 0 {
@@ -45,3 +45,111 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "select s:\nwhere  a:'metal hardness' > 5"
+	tests := []struct {
+		expectedLiteral string
+		expectedOffset  int
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"select", 0, 1, 1},
+		{"s:", 7, 1, 8},
+		{"where", 10, 2, 1},
+		{"a:", 17, 2, 8},
+		{"metal hardness", 19, 2, 10},
+		{">", 36, 2, 27},
+		{"5", 38, 2, 29},
+	}
+
+	l := New(input)
+	for i, test := range tests {
+		tok := l.NextToken()
+		if tok.Literal != test.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong. Expected=%q, got=%q", i, test.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Offset != test.expectedOffset {
+			t.Fatalf("tests[%d] - Offset of %q wrong. Expected=%d, got=%d", i, tok.Literal, test.expectedOffset, tok.Offset)
+		}
+
+		if tok.Line != test.expectedLine || tok.Column != test.expectedColumn {
+			t.Fatalf("tests[%d] - Position of %q wrong. Expected=%d:%d, got=%d:%d", i, tok.Literal,
+				test.expectedLine, test.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestUnterminatedQuotes(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+	}{
+		{`"EBSD`, `"EBSD`},
+		{`'Beam Type`, `'Beam Type`},
+		{`'`, `'`},
+	}
+
+	for _, test := range tests {
+		tok := New(test.input).NextToken()
+		if tok.Type != token.ILLEGAL {
+			t.Fatalf("Expected ILLEGAL for %q, got %s", test.input, token.TokenToStr(tok.Type))
+		}
+
+		if tok.Literal != test.expectedLiteral {
+			t.Fatalf("Expected literal %q for %q, got %q", test.expectedLiteral, test.input, tok.Literal)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{"42", token.INT, "42"},
+		{"-3", token.INT, "-3"},
+		{"007", token.INT, "007"},
+		{"1.5", token.FLOAT, "1.5"},
+		{".5", token.FLOAT, ".5"},
+		{"-.25", token.FLOAT, "-.25"},
+		{"-0.75", token.FLOAT, "-0.75"},
+		{"2.7e-4", token.FLOAT, "2.7e-4"},
+		{"6E+23", token.FLOAT, "6E+23"},
+		{"1e10", token.FLOAT, "1e10"},
+	}
+
+	for _, test := range tests {
+		tok := New(test.input).NextToken()
+		if tok.Type != test.expectedType || tok.Literal != test.expectedLiteral {
+			t.Errorf("For %q expected %s %q, got %s %q", test.input, token.TokenToStr(test.expectedType),
+				test.expectedLiteral, token.TokenToStr(tok.Type), tok.Literal)
+		}
+	}
+
+	// An e that isn't followed by exponent digits, and a . that isn't followed by a fraction, end the number.
+	l := New("5e 3.x -y")
+	expected := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.IDENT, "e"},
+		{token.INT, "3"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "x"},
+		{token.ILLEGAL, "-"},
+		{token.IDENT, "y"},
+		{token.EOF, ""},
+	}
+
+	for i, test := range expected {
+		tok := l.NextToken()
+		if tok.Type != test.expectedType || tok.Literal != test.expectedLiteral {
+			t.Fatalf("tests[%d] - Expected %s %q, got %s %q", i, token.TokenToStr(test.expectedType),
+				test.expectedLiteral, token.TokenToStr(tok.Type), tok.Literal)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package mql
+
+import (
+	"fmt"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+	"github.com/materials-commons/mql/internal/mql/ast"
+	"github.com/materials-commons/mql/internal/mql/compiler"
+	"github.com/materials-commons/mql/internal/mql/lexer"
+	"github.com/materials-commons/mql/internal/mql/parser"
+	"github.com/materials-commons/mql/internal/mqldb"
+)
+
+// Parse parses query text that must contain a single select statement. When the query has syntax
+// errors the returned error is a parser.ParseErrors.
+func Parse(query string) (*ast.SelectStatement, error) {
+	p := parser.New(lexer.New(query))
+	program := p.ParseMQL()
+	if len(p.Errors()) != 0 {
+		return nil, p.Errors()
+	}
+
+	if len(program.Statements) != 1 {
+		return nil, fmt.Errorf("expected a single select statement, got %d statements", len(program.Statements))
+	}
+
+	return program.Statements[0].(*ast.SelectStatement), nil
+}
+
+// Compile parses the query and compiles it into the selection and statement used by mqldb.
+func Compile(query string) (mqldb.Selection, mqldb.Statement, error) {
+	statement, err := Parse(query)
+	if err != nil {
+		return mqldb.Selection{}, nil, err
+	}
+
+	return compiler.Compile(statement)
+}
+
+// Execute parses and compiles the query and then evaluates it against db.
+func Execute(db *mqldb.DB, query string) ([]mcmodel.Activity, []mcmodel.Entity, error) {
+	selection, s, err := Compile(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	processes, samples := mqldb.EvalStatement(db, selection, s)
+	return processes, samples, nil
+}
+
+// ExecuteProjection parses and compiles the query, evaluates it against db and projects the results
+// onto the fields and attributes the query selects, for example select p:[name, a:time], s:[a:hardness].
+func ExecuteProjection(db *mqldb.DB, query string) (mqldb.Table, error) {
+	selection, s, err := Compile(query)
+	if err != nil {
+		return mqldb.Table{}, err
+	}
+
+	return mqldb.EvalProjection(db, selection, s), nil
+}
@@ -0,0 +1,119 @@
+package mql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+	"github.com/materials-commons/mql/internal/mqldb"
+)
+
+func TestExecute(t *testing.T) {
+	db := createTestDB()
+
+	tests := []struct {
+		query             string
+		expectedProcesses int
+		expectedSamples   int
+	}{
+		{`select p:, s:`, 2, 2},
+		{`select s: where a:hardness > 5`, 0, 1},
+		{`select p: where a:hardness > 5`, 1, 0},
+		{`select s: where has-process:"EBSD" and s:name = "S1"`, 0, 1},
+		{`select p: where p:a:'Beam Type' = "Wide" or p:name = "Texture"`, 2, 0},
+	}
+
+	for _, test := range tests {
+		processes, samples, err := Execute(db, test.query)
+		if err != nil {
+			t.Fatalf("Execute(%q) failed: %s", test.query, err)
+		}
+
+		if len(processes) != test.expectedProcesses {
+			t.Errorf("Execute(%q) expected %d processes, got %d", test.query, test.expectedProcesses, len(processes))
+		}
+
+		if len(samples) != test.expectedSamples {
+			t.Errorf("Execute(%q) expected %d samples, got %d", test.query, test.expectedSamples, len(samples))
+		}
+	}
+}
+
+func TestExecuteParseError(t *testing.T) {
+	if _, _, err := Execute(createTestDB(), `select s: where a:hardness >`); err == nil {
+		t.Fatalf("Expected an error for an incomplete query")
+	}
+}
+
+func TestExecuteProjection(t *testing.T) {
+	table, err := ExecuteProjection(createTestDB(), `select p:[name, a:'Beam Type'], s:[a:hardness] where s:name = "S1"`)
+	if err != nil {
+		t.Fatalf("ExecuteProjection failed: %s", err)
+	}
+
+	expectedColumns := []string{"p:name", "p:a:Beam Type", "s:a:hardness"}
+	if !reflect.DeepEqual(table.Columns, expectedColumns) {
+		t.Fatalf("Expected columns %v, got %v", expectedColumns, table.Columns)
+	}
+
+	expectedRows := [][]interface{}{{"EBSD", "Wide", int64(10)}}
+	if !reflect.DeepEqual(table.Rows, expectedRows) {
+		t.Fatalf("Expected rows %v, got %v", expectedRows, table.Rows)
+	}
+}
+
+func TestExecuteAggregate(t *testing.T) {
+	table, err := ExecuteProjection(createTestDB(), `select count(s:), max(a:hardness) group by p:name`)
+	if err != nil {
+		t.Fatalf("ExecuteProjection failed: %s", err)
+	}
+
+	expectedColumns := []string{"p:name", "count(s:)", "max(s:a:hardness)"}
+	if !reflect.DeepEqual(table.Columns, expectedColumns) {
+		t.Fatalf("Expected columns %v, got %v", expectedColumns, table.Columns)
+	}
+
+	expectedRows := [][]interface{}{{"EBSD", 1, int64(10)}, {"Texture", 1, nil}}
+	if !reflect.DeepEqual(table.Rows, expectedRows) {
+		t.Fatalf("Expected rows %v, got %v", expectedRows, table.Rows)
+	}
+}
+
+// createTestDB creates a project with two samples, S1 and S2, that went through the EBSD and Texture
+// processes respectively.
+func createTestDB() *mqldb.DB {
+	db := mqldb.NewDB(1, nil)
+
+	db.Processes = []mcmodel.Activity{
+		{ID: 1, Name: "EBSD"},
+		{ID: 2, Name: "Texture"},
+	}
+	db.ProcessAttributesByProcessID[1] = map[string]*mcmodel.Attribute{
+		"Beam Type": {
+			Name:            "Beam Type",
+			AttributeValues: []mcmodel.AttributeValue{{ValueType: mcmodel.ValueTypeString, ValueString: "Wide"}},
+		},
+	}
+	db.ProcessAttributesByProcessID[2] = map[string]*mcmodel.Attribute{}
+
+	db.Samples = []mcmodel.Entity{
+		{ID: 1, Name: "S1", EntityStates: []mcmodel.EntityState{{ID: 1}}},
+		{ID: 2, Name: "S2", EntityStates: []mcmodel.EntityState{{ID: 2}}},
+	}
+	db.SampleAttributesBySampleIDAndStates[1] = map[int]map[string]*mcmodel.Attribute{
+		1: {
+			"hardness": {
+				Name:            "hardness",
+				AttributeValues: []mcmodel.AttributeValue{{ValueType: mcmodel.ValueTypeInt, ValueInt: 10}},
+			},
+		},
+	}
+	db.SampleAttributesBySampleIDAndStates[2] = map[int]map[string]*mcmodel.Attribute{2: {}}
+
+	db.ProcessSamples[1] = []*mcmodel.Entity{&db.Samples[0]}
+	db.ProcessSamples[2] = []*mcmodel.Entity{&db.Samples[1]}
+	db.SampleProcesses[1] = []*mcmodel.Activity{&db.Processes[0]}
+	db.SampleProcesses[2] = []*mcmodel.Activity{&db.Processes[1]}
+
+	return db
+}
@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/materials-commons/mql/internal/mql/token"
+)
+
+// ParseError is a single error found while parsing, along with where in the query it occurred. When
+// the error is due to an unexpected token Expected describes what the parser was looking for and Got
+// the token it found. Snippet is the line of the query containing the error followed by a line with
+// a caret pointing at the error, for example:
+//
+//	select s: where a:hardness > and
+//	                             ^
+type ParseError struct {
+	Message  string `json:"message"`
+	Expected string `json:"expected,omitempty"`
+	Got      string `json:"got,omitempty"`
+	Offset   int    `json:"offset"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Snippet  string `json:"snippet"`
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// ParseErrors is the list of errors from parsing a query. It implements error so that it can be
+// returned as one, and recovered with errors.As by callers wanting the individual positions.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// newParseError creates a ParseError positioned at tok. The snippet is taken from input.
+func newParseError(input string, tok token.Token, msg string) *ParseError {
+	return &ParseError{
+		Message: msg,
+		Got:     describeToken(tok),
+		Offset:  tok.Offset,
+		Line:    tok.Line,
+		Column:  tok.Column,
+		Snippet: snippet(input, tok.Offset),
+	}
+}
+
+// describeToken describes the token found at the position of an error.
+func describeToken(tok token.Token) string {
+	if tok.Type == token.EOF || tok.Literal == "" {
+		return token.Describe(tok.Type)
+	}
+
+	return "'" + tok.Literal + "'"
+}
+
+// describeIllegalToken explains why the lexer could not turn the input into a valid token.
+func describeIllegalToken(tok token.Token) string {
+	switch {
+	case strings.HasPrefix(tok.Literal, `"`):
+		return "string is missing its closing \""
+	case strings.HasPrefix(tok.Literal, "'"):
+		return "quoted name is missing its closing '"
+	default:
+		return fmt.Sprintf("illegal character '%s'", tok.Literal)
+	}
+}
+
+// snippet returns the line of input containing offset, followed by a line with a caret under
+// offset. Tabs are kept in the caret line so that the caret lines up with the text above it.
+func snippet(input string, offset int) string {
+	if offset > len(input) {
+		offset = len(input)
+	}
+
+	start := strings.LastIndexByte(input[:offset], '\n') + 1
+	end := strings.IndexByte(input[offset:], '\n')
+	if end == -1 {
+		end = len(input)
+	} else {
+		end += offset
+	}
+
+	var caret strings.Builder
+	for _, ch := range input[start:offset] {
+		if ch == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+
+	return input[start:end] + "\n" + caret.String()
+}
@@ -2,7 +2,6 @@ package parser
 
 import (
 	"fmt"
-	"log"
 	"strconv"
 
 	"github.com/materials-commons/mql/internal/mql/ast"
@@ -14,21 +13,39 @@ import (
 const (
 	_ int = iota
 	LOWEST
-	EQUALS      // =
+	BOOLEAN_OR  // or
+	BOOLEAN_AND // and
+	BOOLEAN_NOT // not
+	EQUALS      // = or <> or a string pattern operator
 	LESSGREATER // > or < or <= or >=
-	BOOLEAN
 )
 
 var precendences = map[token.TokenType]int{
-	token.EQUAL: EQUALS,
-	token.NOTEQ: EQUALS,
-	token.LT:    LESSGREATER,
-	token.LTEQ:  LESSGREATER,
-	token.GT:    LESSGREATER,
-	token.GTEQ:  LESSGREATER,
-	token.AND:   BOOLEAN,
-	token.NOT:   BOOLEAN,
-	token.OR:    BOOLEAN,
+	token.EQUAL:       EQUALS,
+	token.NOTEQ:       EQUALS,
+	token.LIKE:        EQUALS,
+	token.ILIKE:       EQUALS,
+	token.CONTAINS:    EQUALS,
+	token.STARTS_WITH: EQUALS,
+	token.MATCHES:     EQUALS,
+	token.IN:          EQUALS,
+	token.BETWEEN:     EQUALS,
+	token.IS:          EQUALS,
+	token.LT:          LESSGREATER,
+	token.LTEQ:        LESSGREATER,
+	token.GT:          LESSGREATER,
+	token.GTEQ:        LESSGREATER,
+	token.AND:         BOOLEAN_AND,
+	token.OR:          BOOLEAN_OR,
+}
+
+// builtinEntities maps each built-in function to the entity (process or sample) it is evaluated
+// against when it is used without a p: or s: prefix.
+var builtinEntities = map[token.TokenType]token.TokenType{
+	token.HAS_PROCESS:   token.SAMPLE,
+	token.HAS_SAMPLE:    token.PROCESS,
+	token.HAS_ATTRIBUTE: token.SAMPLE,
+	token.HAS_SEQUENCE:  token.SAMPLE,
 }
 
 type (
@@ -38,7 +55,7 @@ type (
 
 type Parser struct {
 	l              *lexer.Lexer
-	errors         []string
+	errors         ParseErrors
 	curToken       token.Token
 	peekToken      token.Token
 	prefixParseFns map[token.TokenType]prefixParseFn
@@ -46,13 +63,35 @@ type Parser struct {
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: ParseErrors{}}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
 	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
+	p.registerPrefix(token.NOT, p.parsePrefixExpression)
+	p.registerPrefix(token.PROCESS, p.parseScopedExpression)
+	p.registerPrefix(token.SAMPLE, p.parseScopedExpression)
+	p.registerPrefix(token.ATTR, p.parseAttributeIdentifier)
+	p.registerPrefix(token.ANY, p.parseQuantifiedAttribute)
+	p.registerPrefix(token.ALL, p.parseQuantifiedAttribute)
+	p.registerPrefix(token.SAME_STATE, p.parseScopeExpression)
+	p.registerPrefix(token.SAME_SAMPLE, p.parseScopeExpression)
+	p.registerPrefix(token.UPSTREAM, p.parseLineageExpression)
+	p.registerPrefix(token.DOWNSTREAM, p.parseLineageExpression)
+	p.registerPrefix(token.HAS_PROCESS, p.parseBuiltinExpression)
+	p.registerPrefix(token.HAS_SAMPLE, p.parseBuiltinExpression)
+	p.registerPrefix(token.HAS_ATTRIBUTE, p.parseBuiltinExpression)
+	p.registerPrefix(token.HAS_SEQUENCE, p.parseBuiltinExpression)
+
+	p.infixParseFns = make(map[token.TokenType]infixParseFn)
+	for t := range precendences {
+		p.registerInfix(t, p.parseInfixExpression)
+	}
+	p.registerInfix(token.IN, p.parseInExpression)
+	p.registerInfix(token.BETWEEN, p.parseBetweenExpression)
+	p.registerInfix(token.IS, p.parseNullExpression)
 
 	// Read two tokens so that currentToken and peekToken are both set
 	p.nextToken()
@@ -61,23 +100,56 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// Errors returns the list of errors encountered while parsing.
+func (p *Parser) Errors() ParseErrors {
+	return p.errors
+}
+
+// appendError adds an error at the position of the current token.
 func (p *Parser) appendError(msg string, args ...interface{}) {
-	fmt.Sprintf(msg, args...)
-	p.errors = append(p.errors, msg)
+	p.appendErrorAt(p.curToken, msg, args...)
+}
+
+// appendErrorAt adds an error at the position of tok. Only the first error at a given position is
+// kept, as an illegal token is reported when it is read and would otherwise be reported a second time
+// by whatever part of the parser trips over it.
+func (p *Parser) appendErrorAt(tok token.Token, msg string, args ...interface{}) *ParseError {
+	for _, err := range p.errors {
+		if err.Offset == tok.Offset {
+			return err
+		}
+	}
+
+	err := newParseError(p.l.Input(), tok, fmt.Sprintf(msg, args...))
+	p.errors = append(p.errors, err)
+	return err
+}
+
+// expectedError adds an error for finding tok when the parser was looking for expected.
+func (p *Parser) expectedError(tok token.Token, expected string) {
+	err := p.appendErrorAt(tok, "expected %s, got %s instead", expected, describeToken(tok))
+	if err.Expected == "" {
+		err.Expected = expected
+	}
 }
 
 func (p *Parser) registerPrefix(t token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[t] = fn
 }
 
+func (p *Parser) registerInfix(t token.TokenType, fn infixParseFn) {
+	p.infixParseFns[t] = fn
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	var err error
 	literal := &ast.IntegerLiteral{Token: p.curToken}
-	if literal.Value, err = strconv.ParseInt(p.curToken.Literal, 0, 64); err != nil {
-		p.appendError("could not parse %q as integer", p.curToken.Literal)
+	if literal.Value, err = strconv.ParseInt(p.curToken.Literal, 10, 64); err != nil {
+		p.appendError("could not parse '%s' as an integer", p.curToken.Literal)
 		return nil
 	}
 
+	literal.Unit = p.parseUnit()
 	return literal
 }
 
@@ -85,17 +157,307 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	var err error
 	literal := &ast.FloatLiteral{Token: p.curToken}
 	if literal.Value, err = strconv.ParseFloat(p.curToken.Literal, 64); err != nil {
-		p.appendError("could not parse %q as float", p.curToken.Literal)
+		p.appendError("could not parse '%s' as a number", p.curToken.Literal)
 		return nil
 	}
 
+	literal.Unit = p.parseUnit()
 	return literal
 }
 
+// parseUnit parses the optional unit following a number, as in 500 C or 10 'mm/s'. A name can't
+// otherwise follow a number, so any name there is taken as the unit. So is the in keyword, as in 2 in,
+// since a number can't be on the left of an in list.
+func (p *Parser) parseUnit() string {
+	if !p.peekTokenIs(token.IDENT) && !p.peekTokenIs(token.IN) {
+		return ""
+	}
+
+	p.nextToken()
+	return p.curToken.Literal
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parsePrefixExpression parses "not <expression>". The not binds less tightly than comparisons
+// so that "not a:hardness > 5" negates the whole comparison.
+func (p *Parser) parsePrefixExpression() ast.Expression {
+	expression := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+	p.nextToken()
+	expression.Right = p.parseExpression(BOOLEAN_NOT)
+	if expression.Right == nil {
+		return nil
+	}
+
+	return expression
+}
+
+func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
+	expression := &ast.InfixExpression{Token: p.curToken, Operator: p.curToken.Literal, Left: left}
+	precedence := p.curPrecedence()
+	p.nextToken()
+	expression.Right = p.parseExpression(precedence)
+	if expression.Right == nil {
+		return nil
+	}
+
+	return expression
+}
+
+// parseInExpression parses the parenthesized list of values in a:x in (1, 2, 3).
+func (p *Parser) parseInExpression(left ast.Expression) ast.Expression {
+	expression := &ast.InExpression{Token: p.curToken, Left: left}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	for {
+		p.nextToken()
+		value := p.parseExpression(EQUALS)
+		if value == nil {
+			return nil
+		}
+		expression.Values = append(expression.Values, value)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return expression
+}
+
+// parseBetweenExpression parses the range in a:x between 1 and 5. The bounds are parsed at a higher
+// precedence than and, so that the and separating them isn't taken as a boolean and.
+func (p *Parser) parseBetweenExpression(left ast.Expression) ast.Expression {
+	expression := &ast.BetweenExpression{Token: p.curToken, Left: left}
+
+	p.nextToken()
+	if expression.Lower = p.parseExpression(EQUALS); expression.Lower == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.AND) {
+		return nil
+	}
+
+	p.nextToken()
+	if expression.Upper = p.parseExpression(EQUALS); expression.Upper == nil {
+		return nil
+	}
+
+	return expression
+}
+
+// parseNullExpression parses the rest of a:x is null and a:x is not null.
+func (p *Parser) parseNullExpression(left ast.Expression) ast.Expression {
+	expression := &ast.NullExpression{Token: p.curToken, Left: left}
+	if p.peekTokenIs(token.NOT) {
+		p.nextToken()
+		expression.Not = true
+	}
+
+	if !p.expectPeek(token.NULL) {
+		return nil
+	}
+
+	return expression
+}
+
+// parseScopedExpression parses an expression that starts with p: or s:. This is either a field
+// (p:name), an attribute (s:a:hardness) or a built-in function (p:has-sample:"S1").
+func (p *Parser) parseScopedExpression() ast.Expression {
+	scopeToken := p.curToken
+	p.nextToken()
+
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.FieldIdentifier{Token: scopeToken, Entity: scopeToken.Type, Name: p.curToken.Literal}
+	case token.ATTR:
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		return &ast.FieldIdentifier{Token: scopeToken, Entity: scopeToken.Type, Attribute: true, Name: p.curToken.Literal}
+	case token.HAS_PROCESS, token.HAS_SAMPLE, token.HAS_ATTRIBUTE, token.HAS_SEQUENCE:
+		return p.parseBuiltin(scopeToken.Type)
+	default:
+		p.expectedError(p.curToken, fmt.Sprintf("a field, attribute or function after %s", scopeToken.Literal))
+		return nil
+	}
+}
+
+// parseAttributeIdentifier parses an attribute that isn't scoped with p: or s:, such as a:hardness. These
+// are treated as sample attributes.
+func (p *Parser) parseAttributeIdentifier() ast.Expression {
+	attrToken := p.curToken
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	return &ast.FieldIdentifier{Token: attrToken, Entity: token.SAMPLE, Attribute: true, Name: p.curToken.Literal}
+}
+
+// parseQuantifiedAttribute parses an attribute preceded by any or all, such as all a:'grain size'. The
+// quantifier determines whether one or all of the values of a multi-valued attribute have to match.
+func (p *Parser) parseQuantifiedAttribute() ast.Expression {
+	quantifierToken := p.curToken
+	p.nextToken()
+
+	prefixFn := p.prefixParseFns[p.curToken.Type]
+	if prefixFn == nil {
+		p.expectedError(p.curToken, "an attribute")
+		return nil
+	}
+
+	expression := prefixFn()
+	if expression == nil {
+		return nil
+	}
+
+	field, ok := expression.(*ast.FieldIdentifier)
+	if !ok || !field.Attribute {
+		p.appendErrorAt(quantifierToken, "%s can only be applied to attributes", quantifierToken.Literal)
+		return nil
+	}
+
+	field.Quantifier = quantifierToken.Literal
+	return field
+}
+
+// parseScopeExpression parses same-state(<expression>) and same-sample(<expression>).
+func (p *Parser) parseScopeExpression() ast.Expression {
+	expression := &ast.ScopeExpression{Token: p.curToken, Scope: p.curToken.Literal}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	if expression.Expression = p.parseExpression(LOWEST); expression.Expression == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return expression
+}
+
+// parseLineageExpression parses upstream(<expression>) and downstream(<expression>), optionally
+// limited to a number of steps with within, as in upstream(s:name = "S1" within 2).
+func (p *Parser) parseLineageExpression() ast.Expression {
+	expression := &ast.LineageExpression{Token: p.curToken, Direction: p.curToken.Literal}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	if expression.Expression = p.parseExpression(LOWEST); expression.Expression == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.WITHIN) {
+		p.nextToken()
+		if expression.Within = p.parseCount(); expression.Within == nil {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return expression
+}
+
+// parseBuiltinExpression parses a built-in function that isn't scoped with p: or s:.
+func (p *Parser) parseBuiltinExpression() ast.Expression {
+	return p.parseBuiltin(builtinEntities[p.curToken.Type])
+}
+
+// parseBuiltin parses the function and its argument, checking that the function can be applied to
+// the given entity. The argument is either a string ("EBSD") or a quoted name ('Beam Type').
+func (p *Parser) parseBuiltin(entity token.TokenType) ast.Expression {
+	function := p.curToken.Literal[:len(p.curToken.Literal)-1]
+	expression := &ast.BuiltinExpression{Token: p.curToken, Entity: entity, Function: function}
+
+	switch {
+	case (p.curTokenIs(token.HAS_PROCESS) || p.curTokenIs(token.HAS_SEQUENCE)) && entity != token.SAMPLE:
+		p.appendError("%s can only be applied to samples", p.curToken.Literal)
+		return nil
+	case p.curTokenIs(token.HAS_SAMPLE) && entity != token.PROCESS:
+		p.appendError("%s can only be applied to processes", p.curToken.Literal)
+		return nil
+	}
+
+	if p.curTokenIs(token.HAS_SEQUENCE) {
+		if expression.Argument = p.parseSequenceLiteral(); expression.Argument == nil {
+			return nil
+		}
+		return expression
+	}
+
+	p.nextToken()
+	if !p.curTokenIs(token.STRING) && !p.curTokenIs(token.IDENT) {
+		p.expectedError(p.curToken, fmt.Sprintf("a name for %s", expression.Token.Literal))
+		return nil
+	}
+
+	expression.Argument = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	return expression
+}
+
+// parseSequenceLiteral parses the steps of a has-sequence, for example ("Solution Treatment", not "Quench",
+// "Aging"). There has to be at least one step that isn't excluded.
+func (p *Parser) parseSequenceLiteral() ast.Expression {
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	sequence := &ast.SequenceLiteral{Token: p.curToken}
+	hasProcess := false
+	for {
+		p.nextToken()
+		step := &ast.SequenceStep{}
+		if p.curTokenIs(token.NOT) {
+			step.Excluded = true
+			p.nextToken()
+		}
+
+		if !p.curTokenIs(token.STRING) && !p.curTokenIs(token.IDENT) {
+			p.expectedError(p.curToken, "a process name")
+			return nil
+		}
+
+		step.Name = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+		sequence.Steps = append(sequence.Steps, step)
+		hasProcess = hasProcess || !step.Excluded
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !hasProcess {
+		p.appendErrorAt(sequence.Token, "has-sequence: needs at least one process that isn't excluded")
+		return nil
+	}
+
+	return sequence
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 
@@ -111,11 +473,14 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefixFn := p.prefixParseFns[p.curToken.Type]
 	if prefixFn == nil {
-		p.appendError("no prefix parse function for %s found", token.TokenToStr(p.curToken.Type))
+		p.expectedError(p.curToken, "a condition or value")
 		return nil
 	}
 
 	leftExp := prefixFn()
+	if leftExp == nil {
+		return nil
+	}
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infixFn := p.infixParseFns[p.peekToken.Type]
@@ -125,6 +490,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 		p.nextToken()
 		leftExp = infixFn(leftExp)
+		if leftExp == nil {
+			return nil
+		}
 	}
 
 	return leftExp
@@ -133,6 +501,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	if p.peekToken.Type == token.ILLEGAL {
+		p.appendErrorAt(p.peekToken, describeIllegalToken(p.peekToken))
+	}
 }
 
 func (p *Parser) ParseMQL() *ast.MQL {
@@ -140,6 +511,12 @@ func (p *Parser) ParseMQL() *ast.MQL {
 	mql.Statements = []ast.Statement{}
 	for !p.curTokenIs(token.EOF) {
 		statement := p.parseStatement()
+		if len(p.errors) != 0 {
+			// Don't attempt to recover, as the errors following the first one are usually
+			// just noise caused by it.
+			break
+		}
+
 		if statement != nil {
 			mql.Statements = append(mql.Statements, statement)
 		}
@@ -156,27 +533,280 @@ func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.SELECT:
 		return p.parseSelectStatement()
-	//case token.SEMICOLON:
-	//	return
+	case token.SEMICOLON:
+		// Empty statement
+		return nil
 	default:
-		// error here for now
-		log.Fatalf("Top level statement can only be a select")
+		p.expectedError(p.curToken, token.Describe(token.SELECT))
 		return nil
 	}
 }
 
+// parseSelectStatement parses "select <selections> [where <expression>] [;]". On return curToken
+// is the last token of the statement.
 func (p *Parser) parseSelectStatement() ast.Statement {
 	statement := &ast.SelectStatement{Token: p.curToken, SelectionStatements: []ast.Statement{}}
 	p.nextToken()
 	statement.SelectionStatements = p.parseSelectionStatements()
-	if !p.expectPeek(token.WHERE) {
+	if statement.SelectionStatements == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.WHERE) {
+		p.nextToken()
+		statement.WhereStatement.Token = p.curToken
+		p.nextToken()
+		expressionStatement := &ast.ExpressionStatement{Token: p.curToken}
+		if expressionStatement.Expression = p.parseExpression(LOWEST); expressionStatement.Expression == nil {
+			return nil
+		}
+		statement.WhereStatement.Statements = []ast.Statement{expressionStatement}
+	}
+
+	if p.peekTokenIs(token.GROUP) {
+		p.nextToken()
+		if !p.expectPeek(token.BY) {
+			return nil
+		}
+		if statement.GroupBy = p.parseFieldList(); statement.GroupBy == nil {
+			return nil
+		}
+	}
+
+	if p.peekTokenIs(token.ORDER) {
+		p.nextToken()
+		if statement.OrderBy = p.parseOrderBy(); statement.OrderBy == nil {
+			return nil
+		}
+	}
+
+	if p.peekTokenIs(token.LIMIT) {
+		p.nextToken()
+		if statement.Limit = p.parseCount(); statement.Limit == nil {
+			return nil
+		}
+	}
+
+	if p.peekTokenIs(token.OFFSET) {
+		p.nextToken()
+		if statement.Offset = p.parseCount(); statement.Offset == nil {
+			return nil
+		}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
 		return statement
 	}
-	return nil
+
+	if !p.peekTokenIs(token.EOF) {
+		p.expectedError(p.peekToken, "'and', 'or', 'group by', 'order by', 'limit', 'offset', ';' or end of query")
+		return nil
+	}
+
+	return statement
 }
 
+// parseOrderBy parses the comma separated list of fields and attributes following order, each of
+// which can be followed by asc or desc.
+func (p *Parser) parseOrderBy() []*ast.OrderByField {
+	if !p.expectPeek(token.BY) {
+		return nil
+	}
+
+	var fields []*ast.OrderByField
+	for {
+		p.nextToken()
+		fieldToken := p.curToken
+		field, ok := p.parseExpression(EQUALS).(*ast.FieldIdentifier)
+		if !ok {
+			if len(p.errors) == 0 {
+				p.expectedError(fieldToken, "a field or attribute")
+			}
+			return nil
+		}
+
+		orderByField := &ast.OrderByField{Field: field}
+		switch {
+		case p.peekTokenIs(token.DESC):
+			p.nextToken()
+			orderByField.Descending = true
+		case p.peekTokenIs(token.ASC):
+			p.nextToken()
+		}
+		fields = append(fields, orderByField)
+
+		if !p.peekTokenIs(token.COMMA) {
+			return fields
+		}
+		p.nextToken()
+	}
+}
+
+// parseCount parses the number following limit or offset, which can't be negative.
+func (p *Parser) parseCount() *ast.IntegerLiteral {
+	keyword := p.curToken.Literal
+	if !p.expectPeek(token.INT) {
+		return nil
+	}
+
+	count, ok := p.parseIntegerLiteral().(*ast.IntegerLiteral)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case count.Value < 0:
+		p.appendErrorAt(count.Token, "%s can't be negative, got %s", keyword, count.Token.Literal)
+		return nil
+	case count.Unit != "":
+		p.appendError("%s takes a number without a unit", keyword)
+		return nil
+	}
+
+	return count
+}
+
+// parseSelectionStatements parses the comma separated list of p:[...] and s:[...] selections. A
+// selection without a field list (for example just "s:") selects the whole process or sample.
 func (p *Parser) parseSelectionStatements() []ast.Statement {
-	return nil
+	var statements []ast.Statement
+	for {
+		switch p.curToken.Type {
+		case token.PROCESS:
+			statement := &ast.ProcessSelectionStatement{Token: p.curToken}
+			if !p.parseSelectionFields(&statement.Fields) {
+				return nil
+			}
+			statements = append(statements, statement)
+		case token.SAMPLE:
+			statement := &ast.SampleSelectionStatement{Token: p.curToken}
+			if !p.parseSelectionFields(&statement.Fields) {
+				return nil
+			}
+			statements = append(statements, statement)
+		case token.IDENT:
+			statement := p.parseAggregateSelection()
+			if statement == nil {
+				return nil
+			}
+			statements = append(statements, statement)
+		default:
+			p.expectedError(p.curToken, "'p:', 's:' or an aggregate such as count(s:)")
+			return nil
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			return statements
+		}
+
+		// Skip past the comma to the next selection
+		p.nextToken()
+		p.nextToken()
+	}
+}
+
+// aggregateFunctions are the functions that can be used in the selections of a select statement.
+var aggregateFunctions = map[string]bool{
+	"count": true,
+	"min":   true,
+	"max":   true,
+	"avg":   true,
+	"sum":   true,
+}
+
+// parseAggregateSelection parses an aggregate function such as count(s:) or avg(s:a:hardness).
+func (p *Parser) parseAggregateSelection() ast.Statement {
+	statement := &ast.AggregateSelectionStatement{Token: p.curToken, Function: p.curToken.Literal}
+	if !aggregateFunctions[statement.Function] {
+		p.appendError("unknown aggregate function '%s', expected count, min, max, avg or sum", statement.Function)
+		return nil
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	argumentToken := p.curToken
+	if (argumentToken.Type == token.PROCESS || argumentToken.Type == token.SAMPLE) && p.peekTokenIs(token.RPAREN) {
+		statement.Entity = argumentToken.Type
+	} else {
+		field, ok := p.parseExpression(EQUALS).(*ast.FieldIdentifier)
+		if !ok {
+			if len(p.errors) == 0 {
+				p.expectedError(argumentToken, "'p:', 's:', a field or an attribute")
+			}
+			return nil
+		}
+		statement.Entity = field.Entity
+		statement.Field = field
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return statement
+}
+
+// parseFieldList parses a comma separated list of fields and attributes, as in group by p:name, a:phase.
+func (p *Parser) parseFieldList() []ast.Expression {
+	var fields []ast.Expression
+	for {
+		p.nextToken()
+		fieldToken := p.curToken
+		field, ok := p.parseExpression(EQUALS).(*ast.FieldIdentifier)
+		if !ok {
+			if len(p.errors) == 0 {
+				p.expectedError(fieldToken, "a field or attribute")
+			}
+			return nil
+		}
+		fields = append(fields, field)
+
+		if !p.peekTokenIs(token.COMMA) {
+			return fields
+		}
+		p.nextToken()
+	}
+}
+
+// parseSelectionFields parses the optional [field, a:attribute, ...] list following a p: or s:.
+func (p *Parser) parseSelectionFields(fields *[]ast.Expression) bool {
+	entity := p.curToken.Type
+	if !p.peekTokenIs(token.LBRACKET) {
+		return true
+	}
+
+	p.nextToken()
+	if p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		return true
+	}
+
+	for {
+		p.nextToken()
+		switch p.curToken.Type {
+		case token.IDENT:
+			*fields = append(*fields, &ast.FieldIdentifier{Token: p.curToken, Entity: entity, Name: p.curToken.Literal})
+		case token.ATTR:
+			attrToken := p.curToken
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			*fields = append(*fields, &ast.FieldIdentifier{Token: attrToken, Entity: entity, Attribute: true,
+				Name: p.curToken.Literal})
+		default:
+			p.expectedError(p.curToken, "a field or attribute")
+			return false
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			return p.expectPeek(token.RBRACKET)
+		}
+		p.nextToken()
+	}
 }
 
 func (p *Parser) expectPeek(t token.TokenType) bool {
@@ -209,6 +839,5 @@ func (p *Parser) getPrecedence(t token.TokenType) int {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	p.errors = append(p.errors, fmt.Sprintf("Expect next token to be %s, got %s instead", token.TokenToStr(t),
-		token.TokenToStr(p.peekToken.Type)))
+	p.expectedError(p.peekToken, token.Describe(t))
 }
@@ -0,0 +1,355 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/materials-commons/mql/internal/mql/ast"
+	"github.com/materials-commons/mql/internal/mql/lexer"
+	"github.com/materials-commons/mql/internal/mql/token"
+)
+
+func TestParseSelectStatement(t *testing.T) {
+	input := `select p:[name, a:time], s:[a:'metal hardness'] where p:name = "EBSD" and s:a:hardness > 5`
+
+	statement := parseSingleSelect(t, input)
+
+	if len(statement.SelectionStatements) != 2 {
+		t.Fatalf("Expected 2 selection statements, got %d", len(statement.SelectionStatements))
+	}
+
+	processSelection, ok := statement.SelectionStatements[0].(*ast.ProcessSelectionStatement)
+	if !ok {
+		t.Fatalf("Expected first selection to be *ast.ProcessSelectionStatement, got %T", statement.SelectionStatements[0])
+	}
+
+	if len(processSelection.Fields) != 2 {
+		t.Fatalf("Expected 2 process fields, got %d", len(processSelection.Fields))
+	}
+
+	timeAttr := processSelection.Fields[1].(*ast.FieldIdentifier)
+	if !timeAttr.Attribute || timeAttr.Name != "time" || timeAttr.Entity != token.PROCESS {
+		t.Fatalf("Expected process attribute 'time', got %+v", timeAttr)
+	}
+
+	sampleSelection, ok := statement.SelectionStatements[1].(*ast.SampleSelectionStatement)
+	if !ok {
+		t.Fatalf("Expected second selection to be *ast.SampleSelectionStatement, got %T", statement.SelectionStatements[1])
+	}
+
+	hardnessAttr := sampleSelection.Fields[0].(*ast.FieldIdentifier)
+	if !hardnessAttr.Attribute || hardnessAttr.Name != "metal hardness" || hardnessAttr.Entity != token.SAMPLE {
+		t.Fatalf("Expected sample attribute 'metal hardness', got %+v", hardnessAttr)
+	}
+
+	if len(statement.WhereStatement.Statements) != 1 {
+		t.Fatalf("Expected 1 where statement, got %d", len(statement.WhereStatement.Statements))
+	}
+
+	where := statement.WhereStatement.Statements[0].(*ast.ExpressionStatement)
+	and, ok := where.Expression.(*ast.InfixExpression)
+	if !ok || and.Operator != "and" {
+		t.Fatalf("Expected where to be an and expression, got %s", where.Expression)
+	}
+
+	expected := `select p:[name, a:time], s:[a:'metal hardness'] where ((p:name = "EBSD") and (s:a:hardness > 5))`
+	if statement.String() != expected {
+		t.Fatalf("Expected String() = %q, got %q", expected, statement.String())
+	}
+}
+
+func TestParseSelectWithoutWhere(t *testing.T) {
+	statement := parseSingleSelect(t, "select s:;")
+
+	sampleSelection := statement.SelectionStatements[0].(*ast.SampleSelectionStatement)
+	if len(sampleSelection.Fields) != 0 {
+		t.Fatalf("Expected no sample fields, got %d", len(sampleSelection.Fields))
+	}
+
+	if len(statement.WhereStatement.Statements) != 0 {
+		t.Fatalf("Expected no where statements, got %d", len(statement.WhereStatement.Statements))
+	}
+}
+
+func TestOperatorPrecedence(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`select s: where a:zn = 0 or a:mg = 1 and a:hardness > 2`,
+			`((s:a:zn = 0) or ((s:a:mg = 1) and (s:a:hardness > 2)))`,
+		},
+		{
+			`select s: where (a:zn = 0 or a:mg = 1) and a:hardness > 2`,
+			`(((s:a:zn = 0) or (s:a:mg = 1)) and (s:a:hardness > 2))`,
+		},
+		{
+			`select s: where not a:zn = 0 and has-process:"EBSD"`,
+			`((not (s:a:zn = 0)) and s:has-process:"EBSD")`,
+		},
+		{
+			`select s: where all a:'grain size' > 10 and not any p:a:temperature < 400`,
+			`((all s:a:'grain size' > 10) and (not (any p:a:temperature < 400)))`,
+		},
+		{
+			`select p: where p:name = "EBSD" and same-state(a:hardness > 5 and a:phase = "beta")`,
+			`((p:name = "EBSD") and same-state(((s:a:hardness > 5) and (s:a:phase = "beta"))))`,
+		},
+		{
+			`select s: where s:name like "S%" or a:phase ilike "BETA" and s:name ~ "^S[0-9]$"`,
+			`((s:name like "S%") or ((s:a:phase ilike "BETA") and (s:name ~ "^S[0-9]$")))`,
+		},
+		{
+			`select p: where p:name contains "BS" and not p:name starts-with "Tex"`,
+			`((p:name contains "BS") and (not (p:name starts-with "Tex")))`,
+		},
+		{
+			`select p: where p:name in ("EBSD", "SEM") and p:a:temperature between 400 and 600 or p:id in (1)`,
+			`(((p:name in ("EBSD", "SEM")) and (p:a:temperature between 400 and 600)) or (p:id in (1)))`,
+		},
+		{
+			`select p: where p:a:temperature > 500 C and p:a:speed between 1.5 'mm/s' and 3 'mm/s'`,
+			`((p:a:temperature > 500 C) and (p:a:speed between 1.5 'mm/s' and 3 'mm/s'))`,
+		},
+		{
+			`select s: where s:a:thickness > 2 in and s:a:width in (1 in, 2.5 in)`,
+			`((s:a:thickness > 2 in) and (s:a:width in (1 in, 2.5 in)))`,
+		},
+		{
+			`select s: where a:'grain size' is null or not a:hardness is not null and s:name is not null`,
+			`((s:a:'grain size' is null) or ((not (s:a:hardness is not null)) and (s:name is not null)))`,
+		},
+		{
+			`select p: where p:has-attribute:'Beam Type' or p:a:'frames per second' >= 3`,
+			`(p:has-attribute:"Beam Type" or (p:a:'frames per second' >= 3))`,
+		},
+	}
+
+	for _, test := range tests {
+		statement := parseSingleSelect(t, test.input)
+		where := statement.WhereStatement.Statements[0].String()
+		if where != test.expected {
+			t.Errorf("For %q expected %q, got %q", test.input, test.expected, where)
+		}
+	}
+}
+
+func TestParseOrderByAndLimit(t *testing.T) {
+	input := `select s:[name] where a:zn > 0 order by s:a:hardness desc, p:name asc, a:zn limit 10 offset 20;`
+	statement := parseSingleSelect(t, input)
+
+	if len(statement.OrderBy) != 3 || !statement.OrderBy[0].Descending || statement.OrderBy[1].Descending {
+		t.Fatalf("Expected 3 order by fields with only the first descending, got %v", statement.OrderBy)
+	}
+
+	if statement.Limit.Value != 10 || statement.Offset.Value != 20 {
+		t.Fatalf("Expected limit 10 offset 20, got limit %s offset %s", statement.Limit, statement.Offset)
+	}
+
+	expected := `select s:[name] where (s:a:zn > 0) order by s:a:hardness desc, p:name, s:a:zn limit 10 offset 20`
+	if statement.String() != expected {
+		t.Fatalf("Expected String() = %q, got %q", expected, statement.String())
+	}
+
+	statement = parseSingleSelect(t, `select p: offset 5`)
+	if statement.Limit != nil || statement.Offset.Value != 5 {
+		t.Fatalf("Expected only an offset of 5, got limit %v offset %v", statement.Limit, statement.Offset)
+	}
+}
+
+func TestParseHasSequence(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`select s: where has-sequence:("Solution Treatment", "Aging")`, `s:has-sequence:("Solution Treatment", "Aging")`},
+		{`select s: where s:has-sequence:("Solution Treatment", not "Quench", "Aging")`, `s:has-sequence:("Solution Treatment", not "Quench", "Aging")`},
+		{`select p: where has-sequence:(Casting, not Quench) and p:name = "EBSD"`, `(s:has-sequence:("Casting", not "Quench") and (p:name = "EBSD"))`},
+	}
+
+	for _, test := range tests {
+		statement := parseSingleSelect(t, test.input)
+		where := statement.WhereStatement.Statements[0].String()
+		if where != test.expected {
+			t.Errorf("For %q expected %q, got %q", test.input, test.expected, where)
+		}
+	}
+}
+
+func TestParseLineage(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`select s: where downstream(p:name = "Casting")`, `downstream((p:name = "Casting"))`},
+		{`select p: where upstream(s:name = "S1" within 2) and p:name <> "EBSD"`, `(upstream((s:name = "S1") within 2) and (p:name <> "EBSD"))`},
+		{`select s: where not downstream(p:name = "Casting" or p:name = "Forging" within 3)`, `(not downstream(((p:name = "Casting") or (p:name = "Forging")) within 3))`},
+	}
+
+	for _, test := range tests {
+		statement := parseSingleSelect(t, test.input)
+		where := statement.WhereStatement.Statements[0].String()
+		if where != test.expected {
+			t.Errorf("For %q expected %q, got %q", test.input, test.expected, where)
+		}
+	}
+}
+
+func TestParseAggregates(t *testing.T) {
+	input := `select count(s:), avg(s:a:hardness), max(p:name) where a:zn > 0 group by p:name, a:phase order by p:name`
+	statement := parseSingleSelect(t, input)
+
+	if len(statement.SelectionStatements) != 3 {
+		t.Fatalf("Expected 3 aggregates, got %d", len(statement.SelectionStatements))
+	}
+
+	if len(statement.GroupBy) != 2 {
+		t.Fatalf("Expected 2 group by fields, got %v", statement.GroupBy)
+	}
+
+	expected := `select count(s:), avg(s:a:hardness), max(p:name) where (s:a:zn > 0) group by p:name, s:a:phase order by p:name`
+	if statement.String() != expected {
+		t.Fatalf("Expected String() = %q, got %q", expected, statement.String())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		`where a:zn = 0`,
+		`select x:[name]`,
+		`select s: where a:zn =`,
+		`select s: where p:has-process:"EBSD"`,
+		`select s: where (a:zn = 0`,
+		`select s: where a:zn = 0 a:mg = 1`,
+		`select s: where all s:name = "S1"`,
+		`select s: where same-state a:hardness > 5`,
+		`select s: where same-sample(a:hardness > 5`,
+		`select s: where s:name in "S1"`,
+		`select s: where s:name in ("S1", )`,
+		`select s: where s:name in ("S1"`,
+		`select s: where a:zn between 1 or 2`,
+		`select s: where a:zn is 5`,
+		`select s: where a:zn is not`,
+		`select s: order s:name`,
+		`select s: order by 5`,
+		`select s: order by s:name up`,
+		`select s: limit -1`,
+		`select s: limit x`,
+		`select s: limit 10 mm`,
+		`select s: limit 10 where a:zn = 1`,
+		`select median(a:zn)`,
+		`select p: where p:has-sequence:("Casting", "Aging")`,
+		`select s: where has-sequence:"Casting"`,
+		`select s: where has-sequence:("Casting", )`,
+		`select s: where has-sequence:(not "Quench")`,
+		`select s: where has-sequence:("Casting" "Aging")`,
+		`select s: where downstream p:name = "Casting"`,
+		`select s: where downstream(p:name = "Casting"`,
+		`select s: where upstream(s:name = "S1" within)`,
+		`select s: where upstream(s:name = "S1" within -1)`,
+		`select count s:`,
+		`select count(s:`,
+		`select count(5)`,
+		`select count(s:) group s:name`,
+		`select count(s:) group by`,
+		`select count(s:) order by s:name group by s:name`,
+	}
+
+	for _, input := range tests {
+		p := New(lexer.New(input))
+		p.ParseMQL()
+		if len(p.Errors()) == 0 {
+			t.Errorf("Expected parse errors for %q", input)
+		}
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	p := New(lexer.New("select s:\nwhere a:hardness > and"))
+	p.ParseMQL()
+
+	if len(p.Errors()) != 1 {
+		t.Fatalf("Expected 1 error, got %d: %v", len(p.Errors()), p.Errors())
+	}
+
+	err := p.Errors()[0]
+	if err.Line != 2 || err.Column != 20 {
+		t.Fatalf("Expected error at 2:20, got %d:%d (%s)", err.Line, err.Column, err.Message)
+	}
+}
+
+func parseSingleSelect(t *testing.T, input string) *ast.SelectStatement {
+	t.Helper()
+
+	p := New(lexer.New(input))
+	mql := p.ParseMQL()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("Parsing %q failed: %v", input, p.Errors())
+	}
+
+	if len(mql.Statements) != 1 {
+		t.Fatalf("Expected 1 statement, got %d", len(mql.Statements))
+	}
+
+	statement, ok := mql.Statements[0].(*ast.SelectStatement)
+	if !ok {
+		t.Fatalf("Expected *ast.SelectStatement, got %T", mql.Statements[0])
+	}
+
+	return statement
+}
+
+func TestParseErrorDetails(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedOffset  int
+		expectedSnippet string
+	}{
+		{
+			"select s:\nwhere a:hardness > and",
+			"expected a condition or value, got 'and' instead",
+			29,
+			"where a:hardness > and\n                   ^",
+		},
+		{
+			`select p:[name, a:time where`,
+			"expected ']', got 'where' instead",
+			23,
+			"select p:[name, a:time where\n                       ^",
+		},
+		{
+			`select s: where s:name = "S1`,
+			`string is missing its closing "`,
+			25,
+			"select s: where s:name = \"S1\n                         ^",
+		},
+		{
+			`select s: where a:zn ! 5`,
+			"illegal character '!'",
+			21,
+			"select s: where a:zn ! 5\n                     ^",
+		},
+	}
+
+	for _, test := range tests {
+		p := New(lexer.New(test.input))
+		p.ParseMQL()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("Expected errors for %q", test.input)
+		}
+
+		err := p.Errors()[0]
+		if err.Message != test.expectedMessage {
+			t.Errorf("For %q expected message %q, got %q", test.input, test.expectedMessage, err.Message)
+		}
+
+		if err.Offset != test.expectedOffset {
+			t.Errorf("For %q expected offset %d, got %d", test.input, test.expectedOffset, err.Offset)
+		}
+
+		if err.Snippet != test.expectedSnippet {
+			t.Errorf("For %q expected snippet\n%s\ngot\n%s", test.input, test.expectedSnippet, err.Snippet)
+		}
+	}
+}
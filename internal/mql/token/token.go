@@ -1,6 +1,9 @@
 package token
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type TokenType int
 
@@ -24,13 +27,43 @@ const (
 	GTEQ  = 0x204 // >=
 	NOTEQ = 0x205 // <>
 
+	// String pattern operators
+	LIKE        = 0x206 // like
+	ILIKE       = 0x207 // ilike
+	CONTAINS    = 0x208 // contains
+	STARTS_WITH = 0x209 // starts-with
+	MATCHES     = 0x20A // ~
+
+	// List and range operators
+	IN      = 0x20B // in
+	BETWEEN = 0x20C // between
+
+	// Null checks
+	IS = 0x20D // is
+
 	// Logical Operators
 	AND = 0x300 // and
 	OR  = 0x301 // or
 	NOT = 0x302 // not
 
+	// Quantifiers for multi-valued attributes
+	ANY = 0x500 // any
+	ALL = 0x501 // all
+
+	// Scopes for correlating sample conditions
+	SAME_STATE  = 0x600 // same-state
+	SAME_SAMPLE = 0x601 // same-sample
+
+	// Lineage traversal through the processes samples went through
+	UPSTREAM   = 0x602 // upstream
+	DOWNSTREAM = 0x603 // downstream
+	WITHIN     = 0x604 // within
+
 	// build-in functions
-	HAS_PROCESS = 0x400 // has-process:
+	HAS_PROCESS   = 0x400 // has-process:
+	HAS_SAMPLE    = 0x401 // has-sample:
+	HAS_ATTRIBUTE = 0x402 // has-attribute:
+	HAS_SEQUENCE  = 0x403 // has-sequence:
 
 	// keywords
 	SAMPLE  = 0x700 // s:
@@ -39,6 +72,13 @@ const (
 	SELECT  = 0x703 // select
 	WHERE   = 0x704 // where
 	NULL    = 0x705 // null
+	ORDER   = 0x706 // order
+	BY      = 0x707 // by
+	ASC     = 0x708 // asc
+	DESC    = 0x709 // desc
+	LIMIT   = 0x70A // limit
+	OFFSET  = 0x70B // offset
+	GROUP   = 0x70C // group
 
 	// Elements
 	LBRACKET  = 0x800 // [
@@ -54,19 +94,46 @@ const (
 type Token struct {
 	Type    TokenType
 	Literal string
+	Offset  int // Byte offset into the input the token starts at
+	Line    int // Line the token starts on, starting at 1
+	Column  int // Column the token starts in, starting at 1
 }
 
 var keywords = map[string]TokenType{
-	"select":       SELECT,
-	"where":        WHERE,
-	"a:":           ATTR,
-	"p:":           PROCESS,
-	"s:":           SAMPLE,
-	"and":          AND,
-	"or":           OR,
-	"not":          NOT,
-	"null":         NULL,
-	"has-process:": HAS_PROCESS,
+	"select":         SELECT,
+	"where":          WHERE,
+	"a:":             ATTR,
+	"p:":             PROCESS,
+	"s:":             SAMPLE,
+	"and":            AND,
+	"or":             OR,
+	"not":            NOT,
+	"like":           LIKE,
+	"ilike":          ILIKE,
+	"contains":       CONTAINS,
+	"starts-with":    STARTS_WITH,
+	"in":             IN,
+	"between":        BETWEEN,
+	"is":             IS,
+	"null":           NULL,
+	"order":          ORDER,
+	"by":             BY,
+	"asc":            ASC,
+	"desc":           DESC,
+	"limit":          LIMIT,
+	"offset":         OFFSET,
+	"group":          GROUP,
+	"any":            ANY,
+	"all":            ALL,
+	"same-state":     SAME_STATE,
+	"same-sample":    SAME_SAMPLE,
+	"upstream":       UPSTREAM,
+	"downstream":     DOWNSTREAM,
+	"within":         WITHIN,
+	"has-process:":   HAS_PROCESS,
+	"has-sample:":    HAS_SAMPLE,
+	"has-attribute:": HAS_ATTRIBUTE,
+	"has-sequence:":  HAS_SEQUENCE,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -78,23 +145,56 @@ func LookupIdent(ident string) TokenType {
 }
 
 var tokenToStr = map[TokenType]string{
-	EQUAL:       "EQUAL: =",
-	LTEQ:        "LTEQ: <=",
-	NOTEQ:       "NOTEQ: <>",
-	LT:          "LT: <",
-	GTEQ:        "GTEQ: >=",
-	GT:          "GT: >",
-	COMMA:       "COMMA: ,",
-	LBRACKET:    "LBRACKET: [",
-	RBRACKET:    "RBRACKET: ]",
-	LPAREN:      "LPAREN: (",
-	RPAREN:      "RPAREN: )",
-	SEMICOLON:   "SEMICOLON: ;",
-	WHERE:       "WHERE: where",
-	AND:         "AND: and",
-	NOT:         "NOT: not",
-	NULL:        "NULL: null",
-	HAS_PROCESS: "HAS_PROCESS: has-process:",
+	ILLEGAL:       "ILLEGAL",
+	FLOAT:         "float",
+	STRING:        "string",
+	EQUAL:         "EQUAL: =",
+	LTEQ:          "LTEQ: <=",
+	NOTEQ:         "NOTEQ: <>",
+	LT:            "LT: <",
+	GTEQ:          "GTEQ: >=",
+	GT:            "GT: >",
+	LIKE:          "LIKE: like",
+	ILIKE:         "ILIKE: ilike",
+	CONTAINS:      "CONTAINS: contains",
+	STARTS_WITH:   "STARTS_WITH: starts-with",
+	MATCHES:       "MATCHES: ~",
+	IN:            "IN: in",
+	BETWEEN:       "BETWEEN: between",
+	IS:            "IS: is",
+	COMMA:         "COMMA: ,",
+	LBRACKET:      "LBRACKET: [",
+	RBRACKET:      "RBRACKET: ]",
+	LPAREN:        "LPAREN: (",
+	RPAREN:        "RPAREN: )",
+	SEMICOLON:     "SEMICOLON: ;",
+	SELECT:        "SELECT: select",
+	WHERE:         "WHERE: where",
+	SAMPLE:        "SAMPLE: s:",
+	PROCESS:       "PROCESS: p:",
+	ATTR:          "ATTR: a:",
+	AND:           "AND: and",
+	OR:            "OR: or",
+	NOT:           "NOT: not",
+	NULL:          "NULL: null",
+	ORDER:         "ORDER: order",
+	BY:            "BY: by",
+	ASC:           "ASC: asc",
+	DESC:          "DESC: desc",
+	LIMIT:         "LIMIT: limit",
+	OFFSET:        "OFFSET: offset",
+	GROUP:         "GROUP: group",
+	ANY:           "ANY: any",
+	ALL:           "ALL: all",
+	SAME_STATE:    "SAME_STATE: same-state",
+	SAME_SAMPLE:   "SAME_SAMPLE: same-sample",
+	UPSTREAM:      "UPSTREAM: upstream",
+	DOWNSTREAM:    "DOWNSTREAM: downstream",
+	WITHIN:        "WITHIN: within",
+	HAS_PROCESS:   "HAS_PROCESS: has-process:",
+	HAS_SAMPLE:    "HAS_SAMPLE: has-sample:",
+	HAS_ATTRIBUTE: "HAS_ATTRIBUTE: has-attribute:",
+	HAS_SEQUENCE:  "HAS_SEQUENCE: has-sequence:",
 }
 
 func TokenToStr(token TokenType) string {
@@ -116,3 +216,27 @@ func TokenToStr(token TokenType) string {
 
 	return fmt.Sprintf("(%d) unknown", token)
 }
+
+// Describe returns a description of the token type suitable for showing to users in error messages,
+// for example "']'" rather than "RBRACKET: ]".
+func Describe(token TokenType) string {
+	switch token {
+	case EOF:
+		return "end of query"
+	case IDENT:
+		return "name"
+	case INT:
+		return "integer"
+	case FLOAT:
+		return "number"
+	case STRING:
+		return "string"
+	}
+
+	str := TokenToStr(token)
+	if i := strings.Index(str, ": "); i != -1 {
+		return "'" + str[i+2:] + "'"
+	}
+
+	return str
+}
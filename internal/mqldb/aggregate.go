@@ -0,0 +1,444 @@
+package mqldb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// Aggregate functions.
+const (
+	AggregateCount = "count"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateAvg   = "avg"
+	AggregateSum   = "sum"
+)
+
+// Aggregate is an aggregate function computed over the results of a query, for example avg(s:a:hardness).
+// FieldType is one of ProcessFieldType, ProcessAttributeFieldType, SampleFieldType or
+// SampleAttributeFieldType. A count with an empty FieldName counts the processes (ProcessFieldType) or
+// samples (SampleFieldType) themselves, as in count(s:).
+type Aggregate struct {
+	Function  string `json:"function"`
+	FieldType int    `json:"field_type"`
+	FieldName string `json:"field_name"`
+}
+
+// GroupBy is a field or attribute to group the results of an aggregate query by.
+type GroupBy struct {
+	FieldType int    `json:"field_type"`
+	FieldName string `json:"field_name"`
+}
+
+// Column returns the name of the Table column for the aggregate, written the way it is in a query.
+func (a Aggregate) Column() string {
+	if a.FieldName == "" {
+		return a.Function + "(" + entityPrefix(a.FieldType) + ")"
+	}
+
+	return a.Function + "(" + fieldColumn(a.FieldType, a.FieldName) + ")"
+}
+
+// IsAggregate returns true when the selection computes aggregates rather than listing results.
+func (s Selection) IsAggregate() bool {
+	return len(s.Aggregates) != 0
+}
+
+// ValidateAggregates checks that the aggregates and group by fields of a selection can be computed.
+func ValidateAggregates(selection Selection) error {
+	if len(selection.Aggregates) == 0 {
+		if len(selection.GroupBy) != 0 {
+			return fmt.Errorf("group by requires an aggregate such as count(s:)")
+		}
+		return nil
+	}
+
+	for _, aggregate := range selection.Aggregates {
+		switch aggregate.Function {
+		case AggregateCount:
+		case AggregateMin, AggregateMax, AggregateAvg, AggregateSum:
+			if aggregate.FieldName == "" {
+				return fmt.Errorf("%s needs a field or attribute, for example %s(s:a:hardness)", aggregate.Function, aggregate.Function)
+			}
+		default:
+			return fmt.Errorf("unknown aggregate function '%s'", aggregate.Function)
+		}
+
+		if !isFieldOrAttributeType(aggregate.FieldType) {
+			return fmt.Errorf("unknown field type %d in %s", aggregate.FieldType, aggregate.Function)
+		}
+	}
+
+	for _, groupBy := range selection.GroupBy {
+		if !isFieldOrAttributeType(groupBy.FieldType) {
+			return fmt.Errorf("unknown field type %d in group by", groupBy.FieldType)
+		}
+	}
+
+	for _, o := range selection.OrderBy {
+		if !isGroupedBy(selection.GroupBy, o.FieldType, o.FieldName) {
+			return fmt.Errorf("can't order by %s, it isn't in the group by", fieldColumn(o.FieldType, o.FieldName))
+		}
+	}
+
+	return nil
+}
+
+func isFieldOrAttributeType(fieldType int) bool {
+	switch fieldType {
+	case ProcessFieldType, ProcessAttributeFieldType, SampleFieldType, SampleAttributeFieldType:
+		return true
+	default:
+		return false
+	}
+}
+
+func isGroupedBy(groupBy []GroupBy, fieldType int, fieldName string) bool {
+	for _, g := range groupBy {
+		if g.FieldType == fieldType && g.FieldName == fieldName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EvalAggregate runs a query and computes the selection's aggregates over the results. The results are
+// split into groups that have the same values for the GroupBy fields and attributes, and the Table has
+// a row for each group with the group's values followed by its aggregates. Without a GroupBy there is
+// a single row, even when nothing matched.
+//
+// The results are the same rows EvalProjection builds: processes when only process fields and attributes
+// are aggregated or grouped by, sample states when only sample ones are, and the sample states of each
+// process when both are. Every process, sample or sample state is only counted once for a function,
+// however many rows it is in. For example count(s:) counts each sample once even though each of its
+// matching states has a row. Attributes with several values contribute all of them to min, max, avg
+// and sum, while count counts the processes or sample states that have a value, the way count(col)
+// counts the rows where col isn't null in SQL.
+//
+// Grouping is consistent with this: a process or sample state whose group by attribute has several
+// values is in the group of each of them, and one without the attribute is in the nil group.
+//
+// The groups are ordered by their values, or by the selection's OrderBy, which can only refer to the
+// GroupBy fields and attributes, and then limited to the page given by the selection's Limit and Offset.
+func EvalAggregate(db *DB, selection Selection, statement Statement) Table {
+	return evalAggregate(db, selection, prepareQuery(statement))
+}
+
+// evalAggregate computes the aggregates for a prepared statement.
+func evalAggregate(db *DB, selection Selection, statement Statement) Table {
+	rowSelection := Selection{
+		ProcessSelection: ProcessSelection{All: selection.aggregatesProcesses()},
+		SampleSelection:  SampleSelection{All: selection.aggregatesSamples()},
+	}
+
+	processes, samples := evalStatement(db, rowSelection, statement, nil)
+	rows := projectRows(db, rowSelection, statement, processes, samples, projectionColumns{}, projectionColumns{})
+
+	var groups []*aggregateGroup
+	groupsByKey := make(map[string]*aggregateGroup)
+	for _, row := range rows {
+		for _, values := range groupValueCombinations(db, row, selection.GroupBy) {
+			key := groupKey(values)
+			group, ok := groupsByKey[key]
+			if !ok {
+				group = &aggregateGroup{values: values}
+				groupsByKey[key] = group
+				groups = append(groups, group)
+			}
+			group.rows = append(group.rows, row)
+		}
+	}
+
+	if len(selection.GroupBy) == 0 && len(groups) == 0 {
+		groups = append(groups, &aggregateGroup{})
+	}
+
+	orderBy := selection.OrderBy
+	if len(orderBy) == 0 {
+		for _, g := range selection.GroupBy {
+			orderBy = append(orderBy, OrderBy{FieldType: g.FieldType, FieldName: g.FieldName})
+		}
+	}
+
+	sort.SliceStable(groups, orderLess(orderBy, func(i int, o OrderBy) interface{} {
+		for j, g := range selection.GroupBy {
+			if g.FieldType == o.FieldType && g.FieldName == o.FieldName {
+				return groups[i].values[j]
+			}
+		}
+		return nil
+	}))
+
+	start, end := pageBounds(len(groups), selection.Limit, selection.Offset)
+
+	var table Table
+	for _, g := range selection.GroupBy {
+		table.Columns = append(table.Columns, fieldColumn(g.FieldType, g.FieldName))
+	}
+
+	for _, aggregate := range selection.Aggregates {
+		table.Columns = append(table.Columns, aggregate.Column())
+	}
+
+	table.Rows = [][]interface{}{}
+	for _, group := range groups[start:end] {
+		cells := append([]interface{}{}, group.values...)
+		for _, aggregate := range selection.Aggregates {
+			cells = append(cells, group.aggregate(db, aggregate))
+		}
+		table.Rows = append(table.Rows, cells)
+	}
+
+	return table
+}
+
+func (s Selection) aggregatesProcesses() bool {
+	return s.aggregatesFieldTypes(ProcessFieldType, ProcessAttributeFieldType)
+}
+
+func (s Selection) aggregatesSamples() bool {
+	return s.aggregatesFieldTypes(SampleFieldType, SampleAttributeFieldType)
+}
+
+func (s Selection) aggregatesFieldTypes(fieldType, attributeFieldType int) bool {
+	for _, a := range s.Aggregates {
+		if a.FieldType == fieldType || a.FieldType == attributeFieldType {
+			return true
+		}
+	}
+
+	for _, g := range s.GroupBy {
+		if g.FieldType == fieldType || g.FieldType == attributeFieldType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// groupValueCombinations returns the values of the group by fields and attributes for a row, one slice
+// for each group the row is in. An attribute with several values puts the row in a group for each of
+// them, so a row is in a group for every combination of the values of its group by attributes.
+func groupValueCombinations(db *DB, row projectedRow, groupBy []GroupBy) [][]interface{} {
+	combinations := [][]interface{}{nil}
+	for _, g := range groupBy {
+		var next [][]interface{}
+		for _, combination := range combinations {
+			for _, value := range groupValues(db, row, g) {
+				next = append(next, append(append([]interface{}{}, combination...), value))
+			}
+		}
+		combinations = next
+	}
+
+	return combinations
+}
+
+// groupValues returns the distinct values of a group by field or attribute for a row, or a single nil when
+// the row doesn't have it.
+func groupValues(db *DB, row projectedRow, g GroupBy) []interface{} {
+	o := OrderBy{FieldType: g.FieldType, FieldName: g.FieldName}
+	var attribute *mcmodel.Attribute
+	switch {
+	case g.FieldType == ProcessAttributeFieldType && row.process != nil:
+		attribute = db.ProcessAttributesByProcessID[row.process.ID][g.FieldName]
+	case g.FieldType == SampleAttributeFieldType && row.sampleState != nil && row.sampleState.EntityStateID != 0:
+		sampleID, stateID := row.sampleState.sample.ID, row.sampleState.EntityStateID
+		attribute = db.SampleAttributesBySampleIDAndStates[sampleID][stateID][g.FieldName]
+	default:
+		return []interface{}{row.orderValue(db, o)}
+	}
+
+	var values []interface{}
+	seen := make(map[string]bool)
+	if attribute != nil {
+		for _, value := range attribute.AttributeValues {
+			v := attributeValueOf(value)
+			if v == nil {
+				continue
+			}
+
+			key := groupKey([]interface{}{v})
+			if !seen[key] {
+				seen[key] = true
+				values = append(values, v)
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return []interface{}{nil}
+	}
+
+	return values
+}
+
+// aggregateGroup is the rows that have the same values for the group by fields and attributes.
+type aggregateGroup struct {
+	values []interface{}
+	rows   []projectedRow
+}
+
+// groupKey turns the values of a group into a map key. The type is included so that the string "1"
+// and the number 1 are different groups, while ints and floats with the same value are the same group.
+func groupKey(values []interface{}) string {
+	var key strings.Builder
+	for _, value := range values {
+		if number, ok := orderNumber(value); ok {
+			value = number
+		}
+		fmt.Fprintf(&key, "%T:%v\x00", value, value)
+	}
+
+	return key.String()
+}
+
+// aggregate computes an aggregate function over the rows of the group.
+func (g *aggregateGroup) aggregate(db *DB, aggregate Aggregate) interface{} {
+	values, present := g.valuesOf(db, aggregate)
+	switch aggregate.Function {
+	case AggregateCount:
+		return present
+	case AggregateMin, AggregateMax:
+		var result interface{}
+		for _, value := range values {
+			c := 0
+			if result != nil {
+				c = compareOrderValues(value, result)
+			}
+			if result == nil || (aggregate.Function == AggregateMin && c < 0) || (aggregate.Function == AggregateMax && c > 0) {
+				result = value
+			}
+		}
+		return result
+	}
+
+	// avg and sum only apply to numbers. A sum of ints stays an int.
+	var (
+		sum     float64
+		intSum  int64
+		count   int
+		allInts = true
+	)
+
+	for _, value := range values {
+		number, ok := orderNumber(value)
+		if !ok {
+			continue
+		}
+
+		switch v := value.(type) {
+		case int64:
+			intSum += v
+		case int:
+			intSum += int64(v)
+		default:
+			allInts = false
+		}
+
+		sum += number
+		count++
+	}
+
+	switch {
+	case count == 0:
+		return nil
+	case aggregate.Function == AggregateAvg:
+		return sum / float64(count)
+	case allInts:
+		return intSum
+	default:
+		return sum
+	}
+}
+
+// valuesOf returns the values of the aggregate's field or attribute for the rows in the group, and the
+// number of processes, samples or sample states that have a value. Each process, sample or sample state
+// only contributes its values once. A count of the processes or samples themselves gets a value for each
+// distinct process or sample.
+func (g *aggregateGroup) valuesOf(db *DB, aggregate Aggregate) ([]interface{}, int) {
+	var values []interface{}
+	count := 0
+	seen := make(map[string]bool)
+	for _, row := range g.rows {
+		var (
+			key        string
+			attribute  *mcmodel.Attribute
+			fieldValue interface{}
+		)
+
+		switch aggregate.FieldType {
+		case ProcessFieldType, ProcessAttributeFieldType:
+			if row.process == nil {
+				continue
+			}
+			key = fmt.Sprintf("p%d", row.process.ID)
+			if aggregate.FieldType == ProcessAttributeFieldType {
+				attribute = db.ProcessAttributesByProcessID[row.process.ID][aggregate.FieldName]
+			} else {
+				fieldValue = fieldValueOf(row.process.Name, row.process.ID, aggregate.FieldName)
+			}
+		case SampleFieldType:
+			if row.sampleState == nil {
+				continue
+			}
+			key = fmt.Sprintf("s%d", row.sampleState.sample.ID)
+			fieldValue = fieldValueOf(row.sampleState.sample.Name, row.sampleState.sample.ID, aggregate.FieldName)
+		case SampleAttributeFieldType:
+			if row.sampleState == nil {
+				continue
+			}
+			sampleID, stateID := row.sampleState.sample.ID, row.sampleState.EntityStateID
+			key = fmt.Sprintf("s%d:%d", sampleID, stateID)
+			attribute = db.SampleAttributesBySampleIDAndStates[sampleID][stateID][aggregate.FieldName]
+		}
+
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		n := len(values)
+		switch {
+		case aggregate.FieldName == "":
+			values = append(values, key)
+		case attribute != nil:
+			for _, value := range attribute.AttributeValues {
+				if v := attributeValueOf(value); v != nil {
+					values = append(values, v)
+				}
+			}
+		case fieldValue != nil:
+			values = append(values, fieldValue)
+		}
+
+		if len(values) > n {
+			count++
+		}
+	}
+
+	return values, count
+}
+
+// entityPrefix returns p: or s: for the processes or samples a field type refers to.
+func entityPrefix(fieldType int) string {
+	if fieldType == ProcessFieldType || fieldType == ProcessAttributeFieldType {
+		return "p:"
+	}
+
+	return "s:"
+}
+
+// fieldColumn returns the name of a field or attribute the way it is written in a query, for example
+// p:name or s:a:hardness.
+func fieldColumn(fieldType int, fieldName string) string {
+	if fieldType == ProcessAttributeFieldType || fieldType == SampleAttributeFieldType {
+		return entityPrefix(fieldType) + "a:" + fieldName
+	}
+
+	return entityPrefix(fieldType) + fieldName
+}
@@ -0,0 +1,165 @@
+package mqldb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAggregateWithoutGroupBy(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		Aggregates: []Aggregate{
+			{Function: AggregateCount, FieldType: ProcessFieldType},
+			{Function: AggregateCount, FieldType: SampleFieldType},
+			{Function: AggregateMin, FieldType: SampleAttributeFieldType, FieldName: "zn"},
+			{Function: AggregateMax, FieldType: SampleAttributeFieldType, FieldName: "zn"},
+			{Function: AggregateSum, FieldType: SampleAttributeFieldType, FieldName: "grain size"},
+			{Function: AggregateAvg, FieldType: SampleAttributeFieldType, FieldName: "grain size"},
+		},
+	}
+
+	table := EvalAggregate(db, selection, nil)
+
+	expectedColumns := []string{"count(p:)", "count(s:)", "min(s:a:zn)", "max(s:a:zn)", "sum(s:a:grain size)", "avg(s:a:grain size)"}
+	if !reflect.DeepEqual(table.Columns, expectedColumns) {
+		t.Fatalf("Expected columns %v, got %v", expectedColumns, table.Columns)
+	}
+
+	// Each process and sample is counted once even though the joined rows repeat them, and the grain
+	// sizes of S2 and S3 are each counted once even though they went through two processes.
+	expectedRows := [][]interface{}{{4, 3, 0.45, 0.68, int64(46), 11.5}}
+	if !reflect.DeepEqual(table.Rows, expectedRows) {
+		t.Fatalf("Expected rows %v, got %v", expectedRows, table.Rows)
+	}
+}
+
+func TestAggregateNothingMatched(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		Aggregates: []Aggregate{
+			{Function: AggregateCount, FieldType: SampleFieldType},
+			{Function: AggregateAvg, FieldType: SampleAttributeFieldType, FieldName: "zn"},
+		},
+	}
+	noSample := MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "S99"}
+
+	table := EvalAggregate(db, selection, noSample)
+
+	expectedRows := [][]interface{}{{0, nil}}
+	if !reflect.DeepEqual(table.Rows, expectedRows) {
+		t.Fatalf("Expected rows %v, got %v", expectedRows, table.Rows)
+	}
+
+	selection.GroupBy = []GroupBy{{FieldType: SampleFieldType, FieldName: "name"}}
+	if table = EvalAggregate(db, selection, noSample); len(table.Rows) != 0 {
+		t.Fatalf("Expected no groups, got %v", table.Rows)
+	}
+}
+
+func TestAggregateGroupBy(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		Aggregates: []Aggregate{
+			{Function: AggregateCount, FieldType: SampleAttributeFieldType, FieldName: "zn"},
+			{Function: AggregateMax, FieldType: SampleAttributeFieldType, FieldName: "zn"},
+		},
+		GroupBy: []GroupBy{{FieldType: SampleFieldType, FieldName: "name"}},
+	}
+
+	// Only the sample states that match are aggregated, S1 has no zn above 0.5 so it has no group.
+	znAbove := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "zn", Operation: ">", Value: 0.5}
+	table := EvalAggregate(db, selection, znAbove)
+
+	expectedColumns := []string{"s:name", "count(s:a:zn)", "max(s:a:zn)"}
+	if !reflect.DeepEqual(table.Columns, expectedColumns) {
+		t.Fatalf("Expected columns %v, got %v", expectedColumns, table.Columns)
+	}
+
+	expectedRows := [][]interface{}{{"S2", 1, 0.6}, {"S3", 1, 0.68}}
+	if !reflect.DeepEqual(table.Rows, expectedRows) {
+		t.Fatalf("Expected rows %v, got %v", expectedRows, table.Rows)
+	}
+
+	selection.OrderBy = []OrderBy{{FieldType: SampleFieldType, FieldName: "name", Descending: true}}
+	selection.Limit = 1
+	table = EvalAggregate(db, selection, znAbove)
+
+	expectedRows = [][]interface{}{{"S3", 1, 0.68}}
+	if !reflect.DeepEqual(table.Rows, expectedRows) {
+		t.Fatalf("Expected rows %v, got %v", expectedRows, table.Rows)
+	}
+}
+
+func TestAggregateGroupByProcessAttribute(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		Aggregates: []Aggregate{
+			{Function: AggregateCount, FieldType: ProcessFieldType},
+			{Function: AggregateCount, FieldType: SampleFieldType},
+		},
+		GroupBy: []GroupBy{{FieldType: ProcessAttributeFieldType, FieldName: "Beam Type"}},
+	}
+
+	table := EvalAggregate(db, selection, nil)
+
+	// The Texture processes don't have a Beam Type, so they are grouped together under nil, which
+	// sorts last.
+	expectedRows := [][]interface{}{{"Thin", 1, 1}, {"Wide", 1, 2}, {nil, 2, 3}}
+	if !reflect.DeepEqual(table.Rows, expectedRows) {
+		t.Fatalf("Expected rows %v, got %v", expectedRows, table.Rows)
+	}
+}
+
+func TestAggregateGroupByMultiValuedAttribute(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		Aggregates: []Aggregate{
+			{Function: AggregateCount, FieldType: SampleFieldType},
+			{Function: AggregateCount, FieldType: SampleAttributeFieldType, FieldName: "grain size"},
+			{Function: AggregateSum, FieldType: SampleAttributeFieldType, FieldName: "grain size"},
+		},
+		GroupBy: []GroupBy{{FieldType: SampleAttributeFieldType, FieldName: "grain size"}},
+	}
+
+	table := EvalAggregate(db, selection, nil)
+
+	// A state is in the group of each of its grain sizes, and count(s:a:grain size) counts the states
+	// that have a grain size, not their values. The states without one are grouped under nil.
+	expectedRows := [][]interface{}{
+		{int64(8), 1, 1, int64(20)},
+		{int64(11), 1, 1, int64(26)},
+		{int64(12), 1, 1, int64(20)},
+		{int64(15), 1, 1, int64(26)},
+		{nil, 3, 0, nil},
+	}
+	if !reflect.DeepEqual(table.Rows, expectedRows) {
+		t.Fatalf("Expected rows %v, got %v", expectedRows, table.Rows)
+	}
+}
+
+func TestValidateAggregates(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection Selection
+	}{
+		{"group by without aggregates", Selection{GroupBy: []GroupBy{{FieldType: SampleFieldType, FieldName: "name"}}}},
+		{"unknown function", Selection{Aggregates: []Aggregate{{Function: "median", FieldType: SampleFieldType}}}},
+		{"sum without a field", Selection{Aggregates: []Aggregate{{Function: AggregateSum, FieldType: SampleFieldType}}}},
+		{"unknown field type", Selection{Aggregates: []Aggregate{{Function: AggregateCount, FieldType: ProcessFuncType}}}},
+		{"order by not grouped", Selection{
+			Aggregates: []Aggregate{{Function: AggregateCount, FieldType: SampleFieldType}},
+			OrderBy:    []OrderBy{{FieldType: SampleFieldType, FieldName: "name"}},
+		}},
+	}
+
+	for _, test := range tests {
+		if err := ValidateAggregates(test.selection); err == nil {
+			t.Errorf("Expected an error for %s", test.name)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package mqldb
+
+import (
+	"sort"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// CatalogTopValues is the number of most common string values a catalog lists for each attribute when no
+// other number is given.
+const CatalogTopValues = 10
+
+// Catalog describes what is in a project so that queries can be written against it. It lists the distinct
+// process names and the process and sample attributes, each with a summary of their values.
+type Catalog struct {
+	ProjectID         int                `json:"project_id"`
+	Processes         []NameCount        `json:"processes"`
+	ProcessAttributes []AttributeSummary `json:"process_attributes"`
+	SampleAttributes  []AttributeSummary `json:"sample_attributes"`
+}
+
+// NameCount is a name or value and the number of times it occurs.
+type NameCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// AttributeSummary describes the values of an attribute across a project. Count is the number of processes,
+// or sample states, that have the attribute. Types and Units are the distinct value types and units of its
+// values. Numeric values are summarized by Ranges, one for each unit, since values in different units can't
+// be compared directly. String values are summarized by their most common values in TopValues.
+type AttributeSummary struct {
+	Name      string       `json:"name"`
+	Count     int          `json:"count"`
+	Types     []string     `json:"types"`
+	Units     []string     `json:"units"`
+	Ranges    []ValueRange `json:"ranges,omitempty"`
+	TopValues []NameCount  `json:"top_values,omitempty"`
+}
+
+// ValueRange is the smallest and largest numeric value of an attribute in a unit.
+type ValueRange struct {
+	Unit string  `json:"unit"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+}
+
+// valueTypeNames are the names a catalog uses for the attribute value types.
+var valueTypeNames = map[int]string{
+	mcmodel.ValueTypeUnset:          "unknown",
+	mcmodel.ValueTypeInt:            "int",
+	mcmodel.ValueTypeFloat:          "float",
+	mcmodel.ValueTypeString:         "string",
+	mcmodel.ValueTypeComplex:        "complex",
+	mcmodel.ValueTypeArrayOfInt:     "array of int",
+	mcmodel.ValueTypeArrayOfFloat:   "array of float",
+	mcmodel.ValueTypeArrayOfString:  "array of string",
+	mcmodel.ValueTypeArrayOfComplex: "array of complex",
+}
+
+// BuildCatalog summarizes the processes and attributes of the project in db. Each attribute lists up to
+// topValues of its most common string values.
+func BuildCatalog(db *DB, topValues int) *Catalog {
+	catalog := &Catalog{ProjectID: db.ProjectID}
+
+	processNames := make(map[string]int)
+	for _, process := range db.Processes {
+		processNames[process.Name]++
+	}
+	catalog.Processes = sortedNameCounts(processNames, 0)
+
+	processAttributes := make(map[string]*attributeSummarizer)
+	for _, attributes := range db.ProcessAttributesByProcessID {
+		for _, attr := range attributes {
+			summarizeAttribute(processAttributes, attr)
+		}
+	}
+	catalog.ProcessAttributes = attributeSummaries(processAttributes, topValues)
+
+	sampleAttributes := make(map[string]*attributeSummarizer)
+	for _, states := range db.SampleAttributesBySampleIDAndStates {
+		for _, attributes := range states {
+			for _, attr := range attributes {
+				summarizeAttribute(sampleAttributes, attr)
+			}
+		}
+	}
+	catalog.SampleAttributes = attributeSummaries(sampleAttributes, topValues)
+
+	return catalog
+}
+
+// attributeSummarizer collects the values of an attribute for its AttributeSummary.
+type attributeSummarizer struct {
+	count   int
+	types   map[string]bool
+	units   map[string]bool
+	ranges  map[string]*ValueRange
+	strings map[string]int
+}
+
+func summarizeAttribute(summarizers map[string]*attributeSummarizer, attr *mcmodel.Attribute) {
+	s, ok := summarizers[attr.Name]
+	if !ok {
+		s = &attributeSummarizer{
+			types:   make(map[string]bool),
+			units:   make(map[string]bool),
+			ranges:  make(map[string]*ValueRange),
+			strings: make(map[string]int),
+		}
+		summarizers[attr.Name] = s
+	}
+
+	s.count++
+	for _, value := range attr.AttributeValues {
+		typeName, ok := valueTypeNames[value.ValueType]
+		if !ok {
+			typeName = valueTypeNames[mcmodel.ValueTypeUnset]
+		}
+		s.types[typeName] = true
+
+		if value.Unit != "" {
+			s.units[value.Unit] = true
+		}
+
+		v := attributeValueOf(value)
+		if str, ok := v.(string); ok {
+			s.strings[str]++
+			continue
+		}
+
+		number, ok := orderNumber(v)
+		if !ok {
+			continue
+		}
+
+		r, ok := s.ranges[value.Unit]
+		if !ok {
+			s.ranges[value.Unit] = &ValueRange{Unit: value.Unit, Min: number, Max: number}
+			continue
+		}
+
+		if number < r.Min {
+			r.Min = number
+		}
+
+		if number > r.Max {
+			r.Max = number
+		}
+	}
+}
+
+func attributeSummaries(summarizers map[string]*attributeSummarizer, topValues int) []AttributeSummary {
+	summaries := make([]AttributeSummary, 0, len(summarizers))
+	for name, s := range summarizers {
+		summary := AttributeSummary{
+			Name:  name,
+			Count: s.count,
+			Types: sortedNames(s.types),
+			Units: sortedNames(s.units),
+		}
+
+		if summary.Units == nil {
+			summary.Units = []string{}
+		}
+
+		if len(s.strings) != 0 {
+			summary.TopValues = sortedNameCounts(s.strings, topValues)
+		}
+
+		for _, r := range s.ranges {
+			summary.Ranges = append(summary.Ranges, *r)
+		}
+		sort.Slice(summary.Ranges, func(i, j int) bool { return summary.Ranges[i].Unit < summary.Ranges[j].Unit })
+
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+// sortedNameCounts orders counts from the most to the least common, and then by name. When limit isn't 0
+// only the first limit are returned.
+func sortedNameCounts(counts map[string]int, limit int) []NameCount {
+	sorted := make([]NameCount, 0, len(counts))
+	for name, count := range counts {
+		sorted = append(sorted, NameCount{Name: name, Count: count})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	if limit != 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+
+	return sorted
+}
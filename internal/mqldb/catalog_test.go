@@ -0,0 +1,95 @@
+package mqldb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+func TestBuildCatalog(t *testing.T) {
+	db := createTestDB()
+	db.ProcessAttributesByProcessID[3]["PF scale max"].AttributeValues[0].Unit = "mrd"
+	db.ProcessAttributesByProcessID[4]["PF scale max"].AttributeValues[0].Unit = "mrd"
+
+	catalog := BuildCatalog(db, CatalogTopValues)
+
+	expectedProcesses := []NameCount{{Name: "EBSD", Count: 2}, {Name: "Texture", Count: 2}}
+	if !reflect.DeepEqual(catalog.Processes, expectedProcesses) {
+		t.Fatalf("Expected processes %v, got %v", expectedProcesses, catalog.Processes)
+	}
+
+	expectedProcessAttributes := []AttributeSummary{
+		{
+			Name:      "Beam Type",
+			Count:     2,
+			Types:     []string{"string"},
+			Units:     []string{},
+			TopValues: []NameCount{{Name: "Thin", Count: 1}, {Name: "Wide", Count: 1}},
+		},
+		{
+			Name:   "PF scale max",
+			Count:  2,
+			Types:  []string{"int"},
+			Units:  []string{"mrd"},
+			Ranges: []ValueRange{{Unit: "mrd", Min: 2, Max: 3}},
+		},
+		{
+			Name:   "frames per second",
+			Count:  2,
+			Types:  []string{"int"},
+			Units:  []string{},
+			Ranges: []ValueRange{{Unit: "", Min: 3, Max: 5}},
+		},
+		{
+			Name:      "note",
+			Count:     2,
+			Types:     []string{"string"},
+			Units:     []string{},
+			TopValues: []NameCount{{Name: "ignore these results", Count: 2}},
+		},
+	}
+
+	if !reflect.DeepEqual(catalog.ProcessAttributes, expectedProcessAttributes) {
+		t.Fatalf("Expected process attributes %+v, got %+v", expectedProcessAttributes, catalog.ProcessAttributes)
+	}
+
+	sampleAttributes := make(map[string]AttributeSummary)
+	for _, summary := range catalog.SampleAttributes {
+		sampleAttributes[summary.Name] = summary
+	}
+
+	zn, ok := sampleAttributes["zn"]
+	if !ok {
+		t.Fatalf("Expected a summary for zn in %+v", catalog.SampleAttributes)
+	}
+
+	if !reflect.DeepEqual(zn.Types, []string{"float"}) || len(zn.Ranges) != 1 || zn.Ranges[0].Min > zn.Ranges[0].Max {
+		t.Fatalf("Unexpected summary for zn %+v", zn)
+	}
+}
+
+func TestBuildCatalogTopValues(t *testing.T) {
+	db := NewDBWithLoader(1, nil)
+	db.Processes = []mcmodel.Activity{{ID: 1, Name: "Heat Treatment"}, {ID: 2, Name: "SEM"}, {ID: 3, Name: "SEM"}}
+	for _, process := range db.Processes {
+		db.ProcessAttributesByProcessID[process.ID] = map[string]*mcmodel.Attribute{
+			"operator": {
+				Name:            "operator",
+				AttributeValues: []mcmodel.AttributeValue{{ValueType: mcmodel.ValueTypeString, ValueString: process.Name}},
+			},
+		}
+	}
+
+	catalog := BuildCatalog(db, 1)
+
+	expectedProcesses := []NameCount{{Name: "SEM", Count: 2}, {Name: "Heat Treatment", Count: 1}}
+	if !reflect.DeepEqual(catalog.Processes, expectedProcesses) {
+		t.Fatalf("Expected the processes ordered by count %v, got %v", expectedProcesses, catalog.Processes)
+	}
+
+	expectedTopValues := []NameCount{{Name: "SEM", Count: 2}}
+	if len(catalog.ProcessAttributes) != 1 || !reflect.DeepEqual(catalog.ProcessAttributes[0].TopValues, expectedTopValues) {
+		t.Fatalf("Expected only the most common value %v, got %+v", expectedTopValues, catalog.ProcessAttributes)
+	}
+}
@@ -1,6 +1,9 @@
 package mqldb
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/apex/log"
 	"github.com/materials-commons/gomcdb/mcmodel"
 	"gorm.io/gorm"
@@ -8,7 +11,16 @@ import (
 
 type DB struct {
 	ProjectID int
-	db        *gorm.DB
+	loader    Loader
+	progress  *LoadProgress
+
+	// ProjectUpdatedAt is when the project was last updated as of loading it. It is used to tell whether
+	// a snapshot of the DB is out of date.
+	ProjectUpdatedAt time.Time
+
+	// LoadedAt is when the DB was read from the database, changes made to the project after it are picked up
+	// by RefreshProject.
+	LoadedAt time.Time
 
 	// Process and process data lookups
 	Processes                    []mcmodel.Activity
@@ -16,6 +28,10 @@ type DB struct {
 	ProcessAttributesByProcessID map[int]map[string]*mcmodel.Attribute
 	ProcessSamples               map[int][]*mcmodel.Entity
 
+	// ProcessCreatedAt maps a process id to when it was created, which is the order a sample went through
+	// its processes in.
+	ProcessCreatedAt map[int]time.Time
+
 	// Sample and sample data lookups
 	Samples             []mcmodel.Entity
 	SampleProcesses     map[int][]*mcmodel.Activity
@@ -42,13 +58,19 @@ type DB struct {
 }
 
 // NewDB creates a new in memory instance of the samples, processes, attributes and their relationships DB that
-// is used by the evaluator for query processing.
+// is used by the evaluator for query processing. Load reads the project from db, the Materials Commons database.
 func NewDB(projectID int, db *gorm.DB) *DB {
+	return NewDBWithLoader(projectID, NewGormLoader(db))
+}
+
+// NewDBWithLoader creates a new, empty, DB for the project that Load fills using loader.
+func NewDBWithLoader(projectID int, loader Loader) *DB {
 	return &DB{
 		ProjectID:                           projectID,
-		db:                                  db,
+		loader:                              loader,
 		ProcessAttributesByProcessID:        make(map[int]map[string]*mcmodel.Attribute),
 		ProcessSamples:                      make(map[int][]*mcmodel.Entity),
+		ProcessCreatedAt:                    make(map[int]time.Time),
 		SampleAttributesBySampleIDAndStates: make(map[int]map[int]map[string]*mcmodel.Attribute),
 		SampleProcesses:                     make(map[int][]*mcmodel.Activity),
 	}
@@ -56,9 +78,9 @@ func NewDB(projectID int, db *gorm.DB) *DB {
 
 // Activity2Entity represents the join table for mapping the relationships between processes and samples.
 type Activity2Entity struct {
-	ID         int
-	ActivityID int
-	EntityID   int
+	ID         int `json:"id"`
+	ActivityID int `json:"activity_id"`
+	EntityID   int `json:"entity_id"`
 }
 
 func (Activity2Entity) TableName() string {
@@ -67,70 +89,61 @@ func (Activity2Entity) TableName() string {
 
 // Load loads the samples, processes and attributes for the given project into memory.
 func (db *DB) Load() error {
-	// Make sure project exists
-	var project mcmodel.Project
-	if err := db.db.First(&project, db.ProjectID).Error; err != nil {
-		return err
+	if db.loader == nil {
+		return fmt.Errorf("no loader for project %d", db.ProjectID)
 	}
 
-	if err := db.loadProcessesAndAttributes(); err != nil {
-		return err
-	}
+	return db.loader.Load(db)
+}
 
-	if err := db.loadSamplesAndAttributes(); err != nil {
-		return err
-	}
+// loadDump fills in the DB from the rows of a project that a Loader read. It builds the lookups the evaluator
+// uses and converts the attribute values.
+func (db *DB) loadDump(dump *ProjectDump) {
+	db.progress.update(LoadStageBuilding, func(counts *LoadCounts) {
+		*counts = LoadCounts{
+			Processes:         len(dump.Processes),
+			Samples:           len(dump.Samples),
+			ProcessAttributes: len(dump.ProcessAttributes),
+			SampleAttributes:  len(dump.SampleAttributes),
+		}
+	})
 
-	if err := db.loadProcessSampleMappings(); err != nil {
-		return err
+	db.ProjectUpdatedAt = dump.ProjectUpdatedAt
+	db.LoadedAt = dump.LoadedAt
+	db.Processes = dump.Processes
+	db.AllProcessAttributes = dump.ProcessAttributes
+	db.Samples = dump.Samples
+	db.AllSampleAttributes = dump.SampleAttributes
+	for _, process := range dump.ProcessCreatedAt {
+		db.ProcessCreatedAt[process.ID] = process.CreatedAt
 	}
 
+	db.loadProcessAttributes()
+	db.loadSampleAttributes()
+	db.loadProcessSampleMappings(dump.ProcessSamples)
 	db.wireupAttributesToProcessesAndSamples()
-
-	return nil
 }
 
-func (db *DB) loadProcessesAndAttributes() error {
-	if err := db.db.Where("project_id = ?", db.ProjectID).Find(&db.Processes).Error; err != nil {
-		return err
-	}
-
-	err := db.db.Preload("AttributeValues").Where("attributable_type = ?", "App\\Models\\Activity").
-		Where("attributable_id in (select id from activities where project_id = ?)", db.ProjectID).
-		Find(&db.AllProcessAttributes).Error
-	if err != nil {
-		return err
-	}
-
+func (db *DB) loadProcessAttributes() {
 	for _, process := range db.Processes {
 		db.ProcessAttributesByProcessID[process.ID] = make(map[string]*mcmodel.Attribute)
 	}
 
 	for i, attr := range db.AllProcessAttributes {
-		db.ProcessAttributesByProcessID[attr.AttributableID][attr.Name] = db.AllProcessAttributes[i]
-		if err := attr.LoadValues(); err != nil {
+		attributes, ok := db.ProcessAttributesByProcessID[attr.AttributableID]
+		if !ok {
+			log.Errorf("Attribute %d/%s belongs to unknown process %d", attr.ID, attr.Name, attr.AttributableID)
+			continue
+		}
+
+		attributes[attr.Name] = db.AllProcessAttributes[i]
+		if err := loadAttributeValues(attr); err != nil {
 			log.Errorf("Failed converting attribute %d/%s values: %s", attr.ID, attr.Name, err)
 		}
 	}
-
-	return nil
 }
 
-func (db *DB) loadSamplesAndAttributes() error {
-	err := db.db.Preload("EntityStates").Where("project_id = ?", db.ProjectID).Find(&db.Samples).Error
-	if err != nil {
-		return err
-	}
-
-	err = db.db.Preload("AttributeValues").Where("attributable_type = ?", "App\\Models\\EntityState").
-		Where(`attributable_id in 
-                       (select distinct id from entity_states where entity_id in 
-                               (select id from entities where project_id = ?))`, db.ProjectID).
-		Find(&db.AllSampleAttributes).Error
-	if err != nil {
-		return err
-	}
-
+func (db *DB) loadSampleAttributes() {
 	// Create a map of entity state ids to sample state ids because the attributes are
 	// all going to have an entity state id associated with them and we need to figure
 	// out which sample that state is associated with. Also create hash entries for the
@@ -147,25 +160,38 @@ func (db *DB) loadSamplesAndAttributes() error {
 	// Load the SampleAttributesBySampleIDAndStates map of values
 	for i, attr := range db.AllSampleAttributes {
 		// Here AttributableType == "App\Models\EntityState" and AttributableID == EntityState.ID
-		sampleID := entityStateIDToSampleID[attr.AttributableID]
+		sampleID, ok := entityStateIDToSampleID[attr.AttributableID]
+		if !ok {
+			log.Errorf("Attribute %d/%s belongs to unknown sample state %d", attr.ID, attr.Name, attr.AttributableID)
+			continue
+		}
+
 		db.SampleAttributesBySampleIDAndStates[sampleID][attr.AttributableID][attr.Name] = db.AllSampleAttributes[i]
-		if err := attr.LoadValues(); err != nil {
+		if err := loadAttributeValues(attr); err != nil {
 			log.Errorf("Failed converting attribute %d/%s values: %s", attr.ID, attr.Name, err)
 		}
 	}
-
-	return nil
 }
 
-func (db *DB) loadProcessSampleMappings() error {
-	// Now setup mapping of samples -> to their associated processes, and processes -> to their associated samples
-	var activity2entity []Activity2Entity
-	err := db.db.Where("entity_id in (select id from entities where project_id = ?)", db.ProjectID).
-		Find(&activity2entity).Error
-	if err != nil {
-		return err
+// loadAttributeValues converts the JSON encoded values of an attribute. mcmodel.Attribute.LoadValues stops
+// after the first value it converts from a string, so each value is converted on its own to make every
+// value of a multi-valued attribute available to the evaluator. Conversion continues past a value that
+// fails, and the first error is returned.
+func loadAttributeValues(attr *mcmodel.Attribute) error {
+	var firstErr error
+	for i := range attr.AttributeValues {
+		// The single value attribute shares its AttributeValues with attr, so the conversion is done in place.
+		single := mcmodel.Attribute{AttributeValues: attr.AttributeValues[i : i+1]}
+		if err := single.LoadValues(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
 
+	return firstErr
+}
+
+func (db *DB) loadProcessSampleMappings(activity2entity []Activity2Entity) {
+	// Now setup mapping of samples -> to their associated processes, and processes -> to their associated samples.
 	// For fast lookup map the samples and processes by their id. This will be used in activity2entity work below
 	// to create db entry map of samples to their list of processes, and processes to their list of samples.
 	sampleMap := make(map[int]*mcmodel.Entity)
@@ -193,8 +219,6 @@ func (db *DB) loadProcessSampleMappings() error {
 			}
 		}
 	}
-
-	return nil
 }
 
 func (db *DB) wireupAttributesToProcessesAndSamples() {
@@ -206,28 +230,17 @@ func (db *DB) wireupAttributesToProcessesAndSamples() {
 		}
 	}
 
-	// Add the sample state and sample state attributes to each sample by iterating through the
+	// Add the sample state attributes to each state of each sample by iterating through the
 	// SampleAttributesBySampleIDAndStates map that is a multi-level hash map of
 	// samples -> sample states -> attrNames ->Attribute
 	for i := range db.Samples {
 		sampleID := db.Samples[i].ID
-		for sampleStateID := range db.SampleAttributesBySampleIDAndStates[sampleID] {
-			// We have the Sample State ID, so create a sample state that will be used to
-			// add attributes specific to that state, and then append that state to the
-			// list of sample states for the sample.
-			entityState := mcmodel.EntityState{
-				ID:       sampleStateID,
-				EntityID: sampleID,
-			}
-
-			// Add attributes for that sample state to the state we just created
-			for attrName := range db.SampleAttributesBySampleIDAndStates[sampleID][sampleStateID] {
-				attr := db.SampleAttributesBySampleIDAndStates[sampleID][sampleStateID][attrName]
+		for j := range db.Samples[i].EntityStates {
+			entityState := &db.Samples[i].EntityStates[j]
+			for attrName := range db.SampleAttributesBySampleIDAndStates[sampleID][entityState.ID] {
+				attr := db.SampleAttributesBySampleIDAndStates[sampleID][entityState.ID][attrName]
 				entityState.Attributes = append(entityState.Attributes, *attr)
 			}
-
-			// Append that sample state to the list of sample states for this sample
-			db.Samples[i].EntityStates = append(db.Samples[i].EntityStates, entityState)
 		}
 	}
 }
@@ -3,6 +3,7 @@ package mqldb
 import (
 	"testing"
 
+	"github.com/materials-commons/gomcdb/mcmodel"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -19,3 +20,31 @@ func TestLoadingFromSQLDB(t *testing.T) {
 		t.Fatalf("Failed loading database: %s", err)
 	}
 }
+
+func TestLoadAttributeValuesConvertsEveryValue(t *testing.T) {
+	attr := &mcmodel.Attribute{
+		Name: "grain size",
+		AttributeValues: []mcmodel.AttributeValue{
+			{Val: `{"value": "1.5"}`},
+			{Val: `{"value": "12"}`},
+			{Val: `{"value": "coarse"}`},
+		},
+	}
+
+	if err := loadAttributeValues(attr); err != nil {
+		t.Fatalf("Failed loading attribute values: %s", err)
+	}
+
+	values := attr.AttributeValues
+	if values[0].ValueType != mcmodel.ValueTypeFloat || values[0].ValueFloat != 1.5 {
+		t.Errorf("Expected first value to be float 1.5, got %+v", values[0])
+	}
+
+	if values[1].ValueType != mcmodel.ValueTypeInt || values[1].ValueInt != 12 {
+		t.Errorf("Expected second value to be int 12, got %+v", values[1])
+	}
+
+	if values[2].ValueType != mcmodel.ValueTypeString || values[2].ValueString != "coarse" {
+		t.Errorf("Expected third value to be string 'coarse', got %+v", values[2])
+	}
+}
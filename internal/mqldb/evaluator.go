@@ -3,32 +3,116 @@ package mqldb
 import (
 	"fmt"
 
+	"github.com/apex/log"
 	"github.com/materials-commons/gomcdb/mcmodel"
 )
 
 // EvalStatement runs a query and returns the results. At the moment selection is a simple boolean flag
-// on whether to return samples and/or processes from the matches.
+// on whether to return samples and/or processes from the matches. A nil statement matches all samples
+// and processes. EvalProjection returns just the fields and attributes listed in the selection.
+//
+// The processes and samples are each ordered by the selection's OrderBy, or by ID, and then limited to
+// the page given by the selection's Limit and Offset.
 func EvalStatement(db *DB, selection Selection, statement Statement) ([]mcmodel.Activity, []mcmodel.Entity) {
+	matchingProcesses, matchingSamples := evalStatement(db, selection, prepareQuery(statement), nil)
+	return pageResults(selection, matchingProcesses, matchingSamples)
+}
+
+// QueryResult is what EvalQuery returns for a query.
+type QueryResult struct {
+	Processes    []mcmodel.Activity `json:"processes"`
+	Samples      []mcmodel.Entity   `json:"samples"`
+	Table        *Table             `json:"table,omitempty"`
+	Explanations []Explanation      `json:"explanations,omitempty"`
+}
+
+// EvalQuery runs a query once and returns the results the selection asks for: the processes and samples
+// EvalStatement returns, and when the selection is a projection the Table EvalProjection returns. An
+// aggregate selection only returns the Table. When explain is set the processes and samples also have
+// Explanations of why they matched, which are recorded as the query runs. Aggregates aren't explained.
+func EvalQuery(db *DB, selection Selection, statement Statement, explain bool) QueryResult {
+	statement = prepareQuery(statement)
+
+	if selection.IsAggregate() {
+		table := evalAggregate(db, selection, statement)
+		return QueryResult{Table: &table}
+	}
+
+	var collector *explainCollector
+	if explain {
+		collector = newExplainCollector()
+	}
+
+	var result QueryResult
+	matchingProcesses, matchingSamples := evalStatement(db, selection, statement, collector)
+	if selection.IsProjection() {
+		table := project(db, selection, statement, matchingProcesses, matchingSamples)
+		result.Table = &table
+	}
+
+	result.Processes, result.Samples = pageResults(selection, matchingProcesses, matchingSamples)
+	if explain {
+		result.Explanations = collector.explain(db, statement, result.Processes, result.Samples)
+	}
+
+	return result
+}
+
+// pageResults limits the processes and samples to the page given by the selection's Limit and Offset.
+func pageResults(selection Selection, processes []mcmodel.Activity, samples []mcmodel.Entity) ([]mcmodel.Activity, []mcmodel.Entity) {
+	start, end := pageBounds(len(processes), selection.Limit, selection.Offset)
+	processes = processes[start:end]
+
+	start, end = pageBounds(len(samples), selection.Limit, selection.Offset)
+	samples = samples[start:end]
+
+	return processes, samples
+}
+
+// prepareQuery prepares the statement of a query once for all of its evaluation, see prepareStatement.
+// Callers are expected to have checked the statement with ValidateStatement, as the API and the MQL
+// compiler do, so an error here is only logged. The matches that failed to prepare never match.
+func prepareQuery(statement Statement) Statement {
+	if statement == nil {
+		return nil
+	}
+
+	prepared, err := prepareStatement(statement)
+	if err != nil {
+		log.Errorf("Failed preparing statement: %s", err)
+	}
+
+	return prepared
+}
+
+// evalStatement runs the query and orders, but doesn't limit, the results. The statement must have been
+// prepared with prepareQuery. When collector isn't nil it records why the processes and samples matched.
+func evalStatement(db *DB, selection Selection, statement Statement, collector *explainCollector) ([]mcmodel.Activity, []mcmodel.Entity) {
 	var (
 		matchingProcesses []mcmodel.Activity
 		matchingSamples   []mcmodel.Entity
 	)
+
 	switch {
 	case selection.ProcessSelection.All && selection.SampleSelection.All:
-		matchingProcesses, matchingSamples = evalSelectProcessesAndSamples(db, statement)
+		matchingProcesses, matchingSamples = evalSelectProcessesAndSamples(db, statement, collector)
 	case selection.SampleSelection.All:
-		matchingSamples = evalSelectSamples(db, statement)
+		matchingSamples = evalSelectSamples(db, statement, collector)
 	case selection.ProcessSelection.All:
-		matchingProcesses = evalSelectProcesses(db, statement)
+		matchingProcesses = evalSelectProcesses(db, statement, collector)
 	}
 
+	// The matches are collected in maps, so put them in a stable order
+	sortProcesses(db, matchingProcesses, selection.OrderBy)
+	sortSamples(db, matchingSamples, selection.OrderBy)
+
 	return matchingProcesses, matchingSamples
 }
 
 // evalSelectProcessesAndSamples runs the match against both processes and samples.
-func evalSelectProcessesAndSamples(db *DB, statement Statement) ([]mcmodel.Activity, []mcmodel.Entity) {
-	processes := evalSelectProcesses(db, statement)
-	samples := evalSelectSamples(db, statement)
+func evalSelectProcessesAndSamples(db *DB, statement Statement, collector *explainCollector) ([]mcmodel.Activity, []mcmodel.Entity) {
+	processes := evalSelectProcesses(db, statement, collector)
+	samples := evalSelectSamples(db, statement, collector)
 	return processes, samples
 }
 
@@ -36,16 +120,30 @@ func evalSelectProcessesAndSamples(db *DB, statement Statement) ([]mcmodel.Activ
 // matching statements, and runs matches against samples and/or processes. If there is a process run it
 // then takes the results from the processes and filters it down to just the unique samples associated
 // the process.
-func evalSelectSamples(db *DB, statement Statement) []mcmodel.Entity {
+//
+// Statements containing a NotStatement are only evaluated against samples. Taking the samples of the
+// processes that match a negated condition would include samples the condition was meant to exclude,
+// for example every sample that went through a process other than the one being excluded.
+// Statements containing a LineageStatement are also only evaluated against samples, as the samples of
+// the processes in a lineage are a step further along it.
+func evalSelectSamples(db *DB, statement Statement, collector *explainCollector) []mcmodel.Entity {
 	var matchingSamples []mcmodel.Entity
 	var matchingProcesses []mcmodel.Activity
 
+	if statement == nil {
+		return append(matchingSamples, db.Samples...)
+	}
+
+	if hasNotStatement(statement) || hasLineageStatement(statement) {
+		return evalMatchingSamples(db, statement, collector)
+	}
+
 	if hasSampleMatchStatement(statement) {
-		matchingSamples = evalMatchingSamples(db, statement)
+		matchingSamples = evalMatchingSamples(db, statement, collector)
 	}
 
 	if hasProcessMatchStatement(statement) {
-		matchingProcesses = evalMatchingProcesses(db, statement)
+		matchingProcesses = evalMatchingProcesses(db, statement, collector)
 	}
 
 	processSamples := uniqueSamplesForProcesses(db, matchingProcesses)
@@ -94,17 +192,26 @@ func uniqueSamplesForProcesses(db *DB, processes []mcmodel.Activity) []mcmodel.E
 // evalSelectProcesses will only return matching processes. This method checks if there are sample or process
 // matching statements, and runs matches against samples and/or processes. If there is a sample run it
 // then takes the results from the sample and filters it down to just the unique processes associated with the
-// samples.
-func evalSelectProcesses(db *DB, statement Statement) []mcmodel.Activity {
+// samples. As with evalSelectSamples, statements containing a NotStatement or LineageStatement are only
+// evaluated against processes.
+func evalSelectProcesses(db *DB, statement Statement, collector *explainCollector) []mcmodel.Activity {
 	var matchingProcesses []mcmodel.Activity
 	var matchingSamples []mcmodel.Entity
 
+	if statement == nil {
+		return append(matchingProcesses, db.Processes...)
+	}
+
+	if hasNotStatement(statement) || hasLineageStatement(statement) {
+		return evalMatchingProcesses(db, statement, collector)
+	}
+
 	if hasProcessMatchStatement(statement) {
-		matchingProcesses = evalMatchingProcesses(db, statement)
+		matchingProcesses = evalMatchingProcesses(db, statement, collector)
 	}
 
 	if hasSampleMatchStatement(statement) {
-		matchingSamples = evalMatchingSamples(db, statement)
+		matchingSamples = evalMatchingSamples(db, statement, collector)
 	}
 
 	sampleProcesses := uniqueProcessesForSamples(db, matchingSamples)
@@ -150,13 +257,16 @@ func uniqueProcessesForSamples(db *DB, samples []mcmodel.Entity) []mcmodel.Activ
 	return processes
 }
 
-// evalMatchingProcesses finds all the matching processes with a statement
-func evalMatchingProcesses(db *DB, statement Statement) []mcmodel.Activity {
+// evalMatchingProcesses finds all the matching processes with a statement. When collector isn't nil it records
+// the matches that held for each of them.
+func evalMatchingProcesses(db *DB, statement Statement, collector *explainCollector) []mcmodel.Activity {
 	var matchingProcesses []mcmodel.Activity
 	uniqueProcessMatches := make(map[int]mcmodel.Activity)
 	for _, process := range db.Processes {
-		if eval(db, &process, nil, statement) {
+		trace := collector.newTrace()
+		if eval(db, &process, nil, statement, trace) {
 			uniqueProcessMatches[process.ID] = process
+			collector.matchedProcess(process.ID, trace)
 		}
 	}
 
@@ -168,24 +278,33 @@ func evalMatchingProcesses(db *DB, statement Statement) []mcmodel.Activity {
 }
 
 // SampleState is the state associated with a sample. The IDs contained in the structures
-// are used to look up items in the hash tables in the DB.
+// are used to look up items in the hash tables in the DB. When anyState is true the sample
+// attribute matches are evaluated against every state of the sample rather than just
+// EntityStateID. This is used to evaluate a SameSampleStatement and NotStatement. sameState
+// is true when EntityStateID was chosen by a SameStateStatement, so that negated conditions
+// are also evaluated against just that state.
 type SampleState struct {
 	sample        *mcmodel.Entity
 	EntityStateID int
+	anyState      bool
+	sameState     bool
 }
 
 // evalMatchingSamples finds all the matching samples for a statement. This method must iterate through
 // the states associated with a sample. Once it finds a match in a sample state it will stop searching
-// and ignore the other sample states.
-func evalMatchingSamples(db *DB, statement Statement) []mcmodel.Entity {
+// and ignore the other sample states. When collector isn't nil it records the matches that held for the
+// state that matched.
+func evalMatchingSamples(db *DB, statement Statement, collector *explainCollector) []mcmodel.Entity {
 	var matchingSamples []mcmodel.Entity
 	uniqueSampleMatches := make(map[int]mcmodel.Entity)
 	for _, sample := range db.Samples {
 		for _, entityState := range sample.EntityStates {
-			sampleState := SampleState{&sample, entityState.ID}
-			if eval(db, nil, &sampleState, statement) {
+			sampleState := SampleState{sample: &sample, EntityStateID: entityState.ID}
+			trace := collector.newTrace()
+			if eval(db, nil, &sampleState, statement, trace) {
 				// Found a match on the sample, no need to check other sample states so break out of the state loop
 				uniqueSampleMatches[sample.ID] = sample
+				collector.matchedSample(sample.ID, trace)
 				break
 			}
 		}
@@ -199,70 +318,250 @@ func evalMatchingSamples(db *DB, statement Statement) []mcmodel.Entity {
 }
 
 // eval is the heart of the statement evaluation. It handles individual matches as well as complex
-// statements.
-func eval(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement Statement) bool {
+// statements. When trace isn't nil the match statements that held are recorded in it. Those recorded
+// while evaluating a statement that turns out not to hold are dropped again.
+func eval(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement Statement, trace *matchTrace) bool {
+	mark := trace.mark()
+	if evalNode(db, process, sampleState, statement, trace) {
+		return true
+	}
+
+	trace.rollback(mark)
+	return false
+}
+
+func evalNode(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement Statement, trace *matchTrace) bool {
 	switch s := statement.(type) {
 	case MatchStatement:
-		return evalMatchStatement(db, process, sampleState, s)
+		return evalMatchStatement(db, process, sampleState, s, trace)
 	case AndStatement:
-		return evalAndStatement(db, process, sampleState, s)
+		return evalAndStatement(db, process, sampleState, s, trace)
 	case OrStatement:
-		return evalOrStatement(db, process, sampleState, s)
+		return evalOrStatement(db, process, sampleState, s, trace)
+	case NotStatement:
+		return evalNotStatement(db, process, sampleState, s, trace)
+	case SameStateStatement:
+		return evalSameStateStatement(db, process, sampleState, s, trace)
+	case SameSampleStatement:
+		return evalSameSampleStatement(db, process, sampleState, s, trace)
+	case LineageStatement:
+		return evalLineageStatement(db, process, sampleState, s, trace)
 	default:
 		return false
 	}
 }
 
+// evalNotStatement evaluates a NotStatement. In a sample context the negated statement is evaluated against
+// the whole sample, every one of its states, rather than just the state being evaluated. Otherwise a sample
+// would match not hardness > 5 through any state that isn't hard, even though another state is. Under a
+// SameStateStatement the negation applies to the state the statement is scoped to. The match statements
+// under a not that holds are traced as negated, as there is nothing they matched.
+func evalNotStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement NotStatement, trace *matchTrace) bool {
+	if sampleState != nil && !sampleState.anyState && !sampleState.sameState {
+		sampleState = &SampleState{sample: sampleState.sample, anyState: true}
+	}
+
+	if eval(db, process, sampleState, statement.Statement, trace) {
+		return false
+	}
+
+	trace.addLeaves(statement.Statement, func(leaf *LeafMatch) { leaf.Negated = true })
+	return true
+}
+
+// evalSameStateStatement evaluates a statement whose sample conditions must all hold in the same state of a
+// sample. In a sample context the statement is already being evaluated one state at a time. In a process
+// context each state of each of the process' samples is tried until one satisfies the whole statement.
+func evalSameStateStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement SameStateStatement, trace *matchTrace) bool {
+	if sampleState != nil {
+		if !sampleState.anyState {
+			scoped := *sampleState
+			scoped.sameState = true
+			return eval(db, process, &scoped, statement.Statement, trace)
+		}
+
+		// Nested in a SameSampleStatement, so look for a single state of the sample that matches
+		return evalSameStateForSample(db, process, sampleState.sample, statement, trace)
+	}
+
+	if process == nil {
+		return false
+	}
+
+	for _, sample := range db.ProcessSamples[process.ID] {
+		if evalSameStateForSample(db, process, sample, statement, trace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func evalSameStateForSample(db *DB, process *mcmodel.Activity, sample *mcmodel.Entity, statement SameStateStatement, trace *matchTrace) bool {
+	for _, state := range sample.EntityStates {
+		sampleState := &SampleState{
+			sample:        sample,
+			EntityStateID: state.ID,
+			sameState:     true,
+		}
+		if eval(db, process, sampleState, statement.Statement, trace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evalSameSampleStatement evaluates a statement whose sample conditions must all hold on the same sample,
+// with each condition free to match in any state of that sample. In a process context each of the process'
+// samples is tried until one satisfies the whole statement.
+func evalSameSampleStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement SameSampleStatement, trace *matchTrace) bool {
+	if sampleState != nil {
+		anySampleState := &SampleState{sample: sampleState.sample, anyState: true}
+		return eval(db, process, anySampleState, statement.Statement, trace)
+	}
+
+	if process == nil {
+		return false
+	}
+
+	for _, sample := range db.ProcessSamples[process.ID] {
+		anySampleState := &SampleState{sample: sample, anyState: true}
+		if eval(db, process, anySampleState, statement.Statement, trace) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // evalAndStatement evaluates an AndStatement. It short circuits its check by returning false if the left side
 // evaluates to false.
-func evalAndStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement AndStatement) bool {
-	if !eval(db, process, sampleState, statement.Left) {
+func evalAndStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement AndStatement, trace *matchTrace) bool {
+	if !eval(db, process, sampleState, statement.Left, trace) {
 		return false
 	}
 
-	return eval(db, process, sampleState, statement.Right)
+	return eval(db, process, sampleState, statement.Right, trace)
 }
 
 // evalOrStatement evaluates an OrStatement. It short circuits its check by returning if the left evaluates
-// to true.
-func evalOrStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement OrStatement) bool {
-	if eval(db, process, sampleState, statement.Left) {
+// to true, unless the matches are being traced, in which case the right side is evaluated too so that it is
+// traced when it also holds.
+func evalOrStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement OrStatement, trace *matchTrace) bool {
+	if eval(db, process, sampleState, statement.Left, trace) {
+		if trace != nil {
+			eval(db, process, sampleState, statement.Right, trace)
+		}
 		return true
 	}
 
-	return eval(db, process, sampleState, statement.Right)
+	return eval(db, process, sampleState, statement.Right, trace)
 }
 
 // evalMatchStatement evaluates a MatchStatment which is a leaf node matching against a specific type of item such
 // as a process or sample attribute, or similar.
-func evalMatchStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, match MatchStatement) bool {
+//
+// Both process and sampleState are set when a SameStateStatement or SameSampleStatement is evaluated in a process
+// context. In that case process matches are evaluated against the process, and sample matches against the sample
+// state.
+func evalMatchStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, match MatchStatement, trace *matchTrace) bool {
 	switch match.FieldType {
 	case ProcessFieldType:
-		return evalProcessFieldMatch(process, match)
+		// Like process attributes, process fields can be evaluated in a sample context by checking the
+		// processes associated with the sample.
+		if process == nil && sampleState != nil {
+			return evalProcessFieldMatchForSampleState(sampleState, db, match, trace)
+		}
+		return trace.process(db, process, match, evalProcessFieldMatch(process, match))
 	case ProcessAttributeFieldType:
 		// There are two contexts in which to evaluate a process attribute - A sample or a process context. When in
 		// the sample context we need to find the processes associated with a sample and then evaluate the attributes.
-		// The context is determined by checking if sampleState is nil. If sampleState is not nil, then we are in a
-		// sample context.
-		if sampleState != nil {
-			return evalProcessAttributeFieldMatchForSampleState(sampleState, db, match)
+		// The context is determined by checking if process is nil. If process is nil and sampleState is not nil,
+		// then we are in a sample context.
+		if process == nil && sampleState != nil {
+			return evalProcessAttributeFieldMatchForSampleState(sampleState, db, match, trace)
 		}
-		return evalProcessAttributeFieldMatch(process, db, match)
+		return evalProcessAttributeFieldMatch(process, db, match, trace)
 	case SampleFieldType:
-		return evalSampleFieldMatch(sampleState, match)
+		if sampleState == nil && process != nil {
+			return evalSampleFieldMatchForProcess(process, db, match, trace)
+		}
+		return trace.sample(db, sampleState, match, evalSampleFieldMatch(sampleState, match))
 	case SampleAttributeFieldType:
 		// There are two contexts in which to evaluate a sample attribute - A sample or a process context. When in
 		// the process context we need to find the samples associated with the process and then evaluate the attributes.
-		// The context is determined by checking if process is nil. If process is not nil, then we are in a process
-		// context.
-		if process != nil {
-			return evalSampleAttributeFieldMatchForProcess(process, db, match)
+		// The context is determined by checking if sampleState is nil. If sampleState is nil and process is not nil,
+		// then we are in a process context.
+		if sampleState == nil && process != nil {
+			return evalSampleAttributeFieldMatchForProcess(process, db, match, trace)
 		}
-		return evalSampleAttributeFieldMatch(sampleState, db, match)
+		return evalSampleAttributeFieldMatch(sampleState, db, match, trace)
 	case ProcessFuncType:
-		return evalProcessFuncMatch(process, db, match)
+		if process == nil && sampleState != nil {
+			return evalProcessFuncMatchForSampleState(sampleState, db, match, trace)
+		}
+		return evalProcessFuncMatch(process, db, match, trace)
 	case SampleFuncType:
-		return evalSampleFuncMatch(sampleState, db, match)
+		if sampleState == nil && process != nil {
+			return evalSampleFuncMatchForProcess(process, db, match, trace)
+		}
+		return evalSampleFuncMatch(sampleState, db, match, trace)
+	}
+
+	return false
+}
+
+// evalProcessFieldMatchForSampleState evaluates a process field match in the context of a sample by checking
+// each of the processes the sample went through.
+func evalProcessFieldMatchForSampleState(sampleState *SampleState, db *DB, match MatchStatement, trace *matchTrace) bool {
+	for _, process := range db.SampleProcesses[sampleState.sample.ID] {
+		if evalProcessFieldMatch(process, match) {
+			return trace.process(db, process, match, true)
+		}
+	}
+
+	return false
+}
+
+// evalSampleFieldMatchForProcess evaluates a sample field match in the context of a process by checking each
+// of the samples associated with the process.
+func evalSampleFieldMatchForProcess(process *mcmodel.Activity, db *DB, match MatchStatement, trace *matchTrace) bool {
+	for _, sample := range db.ProcessSamples[process.ID] {
+		sampleState := &SampleState{sample: sample}
+		if evalSampleFieldMatch(sampleState, match) {
+			return trace.sample(db, sampleState, match, true)
+		}
+	}
+
+	return false
+}
+
+// evalProcessFuncMatchForSampleState evaluates a process function in the context of a sample by checking each
+// of the processes the sample went through.
+func evalProcessFuncMatchForSampleState(sampleState *SampleState, db *DB, match MatchStatement, trace *matchTrace) bool {
+	for _, process := range db.SampleProcesses[sampleState.sample.ID] {
+		if evalProcessFuncMatch(process, db, match, trace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evalSampleFuncMatchForProcess evaluates a sample function in the context of a process by checking each of
+// the samples, and their states, associated with the process.
+func evalSampleFuncMatchForProcess(process *mcmodel.Activity, db *DB, match MatchStatement, trace *matchTrace) bool {
+	for _, sample := range db.ProcessSamples[process.ID] {
+		for _, state := range sample.EntityStates {
+			sampleState := &SampleState{
+				sample:        sample,
+				EntityStateID: state.ID,
+			}
+			if evalSampleFuncMatch(sampleState, db, match, trace) {
+				return true
+			}
+		}
 	}
 
 	return false
@@ -270,7 +569,7 @@ func evalMatchStatement(db *DB, process *mcmodel.Activity, sampleState *SampleSt
 
 // evalSampleFuncMatch is called when the user as specified one of the built in sample matching functions. It determines
 // the function being called and performs the evaluation.
-func evalSampleFuncMatch(state *SampleState, db *DB, match MatchStatement) bool {
+func evalSampleFuncMatch(state *SampleState, db *DB, match MatchStatement, trace *matchTrace) bool {
 	if state == nil {
 		return false
 	}
@@ -278,9 +577,15 @@ func evalSampleFuncMatch(state *SampleState, db *DB, match MatchStatement) bool
 	switch {
 	case match.Operation == "has-process":
 		// matching samples that are used in the given process
-		return evalSampleFuncMatchHasProcess(state, db, match.Value.(string))
+		return trace.sample(db, state, match, evalSampleFuncMatchHasProcess(state, db, match.Value.(string)))
+	case match.Operation == "has-attribute" && state.anyState:
+		return evalForEachSampleState(state, db, func(state *SampleState) bool {
+			return evalSampleFuncMatch(state, db, match, trace)
+		})
 	case match.Operation == "has-attribute":
-		return evalSampleFuncMatchHasAttribute(state, db, match.Value.(string))
+		return trace.sample(db, state, match, evalSampleFuncMatchHasAttribute(state, db, match.Value.(string)))
+	case match.Operation == "has-sequence":
+		return trace.sample(db, state, match, evalSampleFuncMatchHasSequence(state, db, match))
 	}
 	return false
 }
@@ -304,7 +609,8 @@ func evalSampleFuncMatchHasProcess(state *SampleState, db *DB, processType strin
 }
 
 // evalSampleFuncMatchHasAttribute implements the has-attribute function for samples. The has-attribute function
-// for samples determines if a sample has a particular attribute in any of it's state.
+// for samples determines if a sample has a particular attribute in the state. evalSampleFuncMatch tries each
+// state of the sample when any of its states can match.
 func evalSampleFuncMatchHasAttribute(sampleState *SampleState, db *DB, attributeName string) bool {
 	// Sanity check, make sure sampleState isn't nil
 	if sampleState == nil {
@@ -330,7 +636,7 @@ func evalSampleFuncMatchHasAttribute(sampleState *SampleState, db *DB, attribute
 }
 
 // evalProcessFuncMatch when a user has specified a process level built in function.
-func evalProcessFuncMatch(process *mcmodel.Activity, db *DB, match MatchStatement) bool {
+func evalProcessFuncMatch(process *mcmodel.Activity, db *DB, match MatchStatement, trace *matchTrace) bool {
 	if process == nil {
 		return false
 	}
@@ -338,9 +644,9 @@ func evalProcessFuncMatch(process *mcmodel.Activity, db *DB, match MatchStatemen
 	switch {
 	case match.Operation == "has-sample":
 		// matching samples that are used in the given process
-		return evalProcessFuncMatchHasSample(process, db, match.Value.(string))
+		return trace.process(db, process, match, evalProcessFuncMatchHasSample(process, db, match.Value.(string)))
 	case match.Operation == "has-attribute":
-		return evalProcessFuncMatchHasAttribute(process, db, match.Value.(string))
+		return trace.process(db, process, match, evalProcessFuncMatchHasAttribute(process, db, match.Value.(string)))
 	}
 	return false
 }
@@ -379,7 +685,7 @@ func evalProcessFuncMatchHasAttribute(process *mcmodel.Activity, db *DB, attribu
 // evalProcessAttributeFieldMatchForSampleState evaluates a process attribute match in the context of a sample. To do
 // this it uses the sample to look up all the processes associated with the sample and then evaluates them, stopping
 // if one of them evaluates to true.
-func evalProcessAttributeFieldMatchForSampleState(sampleState *SampleState, db *DB, match MatchStatement) bool {
+func evalProcessAttributeFieldMatchForSampleState(sampleState *SampleState, db *DB, match MatchStatement, trace *matchTrace) bool {
 	// Get the processes associated with the sample
 	processes, ok := db.SampleProcesses[sampleState.sample.ID]
 	if !ok {
@@ -389,7 +695,7 @@ func evalProcessAttributeFieldMatchForSampleState(sampleState *SampleState, db *
 
 	// Loop through the processes looking for a match on the specified attribute
 	for _, process := range processes {
-		if evalProcessAttributeFieldMatch(process, db, match) {
+		if evalProcessAttributeFieldMatch(process, db, match, trace) {
 			return true
 		}
 	}
@@ -399,7 +705,7 @@ func evalProcessAttributeFieldMatchForSampleState(sampleState *SampleState, db *
 // evalProcessAttributeFieldMatch evaluates the match statement against the given process. It checks the
 // process for the attribute in the match statement and if it exists evaluates the match statement against
 // that attribute.
-func evalProcessAttributeFieldMatch(process *mcmodel.Activity, db *DB, match MatchStatement) bool {
+func evalProcessAttributeFieldMatch(process *mcmodel.Activity, db *DB, match MatchStatement, trace *matchTrace) bool {
 	if process == nil {
 		// There are contexts in which a null process may be passed in. When that happens just return false
 		// (no match)
@@ -407,37 +713,24 @@ func evalProcessAttributeFieldMatch(process *mcmodel.Activity, db *DB, match Mat
 	}
 
 	// Get the attributes for the process
-	attributes, ok := db.ProcessAttributesByProcessID[process.ID]
-	if !ok {
-		fmt.Printf("    Process %d/%s has no attributes\n", process.ID, process.Name)
-		return false
-	}
+	attributes := db.ProcessAttributesByProcessID[process.ID]
 
-	// Get the given attribute in the match for the process
+	// Get the given attribute in the match for the process. A missing attribute is null.
 	attribute, ok := attributes[match.FieldName]
-	if !ok {
-		return false
+	if isNullOperation(match.Operation) {
+		return trace.process(db, process, match, evalAttributeNullMatch(attribute, match))
 	}
 
-	// An attribute may have a list of values, so evaluate the match statement against each
-	// stopping if one matches.
-	for _, value := range attribute.AttributeValues {
-		switch value.ValueType {
-		case mcmodel.ValueTypeInt:
-			return tryEvalAttributeIntMatch(value.ValueInt, match)
-		case mcmodel.ValueTypeFloat:
-			return tryEvalAttributeFloatMatch(value.ValueFloat, match)
-		case mcmodel.ValueTypeString:
-			return tryEvalAttributeStringMatch(value.ValueString, match)
-		}
+	if !ok {
+		return false
 	}
 
-	return false
+	return trace.process(db, process, match, evalAttributeMatch(attribute, match))
 }
 
 // evalSampleAttributeFieldMatchForProcess evaluates a sample field in a process context. It looks up the samples
 // for a given process and then runs an evaluation again each of them.
-func evalSampleAttributeFieldMatchForProcess(process *mcmodel.Activity, db *DB, match MatchStatement) bool {
+func evalSampleAttributeFieldMatchForProcess(process *mcmodel.Activity, db *DB, match MatchStatement, trace *matchTrace) bool {
 	// Get the list of samples associated with the process
 	samples, ok := db.ProcessSamples[process.ID]
 	if !ok {
@@ -451,7 +744,7 @@ func evalSampleAttributeFieldMatchForProcess(process *mcmodel.Activity, db *DB,
 				sample:        sample,
 				EntityStateID: state.ID,
 			}
-			if evalSampleAttributeFieldMatch(sampleState, db, match) {
+			if evalSampleAttributeFieldMatch(sampleState, db, match, trace) {
 				return true
 			}
 		}
@@ -461,40 +754,66 @@ func evalSampleAttributeFieldMatchForProcess(process *mcmodel.Activity, db *DB,
 }
 
 // evalSampleAttributeFieldMatch evaluates a attribute match against a sample in a specific sample state.
-func evalSampleAttributeFieldMatch(sampleState *SampleState, db *DB, match MatchStatement) bool {
+// is-null and is-not-null are the exception, they are evaluated against the whole sample unless scoped
+// to the state by a SameStateStatement.
+func evalSampleAttributeFieldMatch(sampleState *SampleState, db *DB, match MatchStatement, trace *matchTrace) bool {
 	// Sanity check, make sure sampleState isn't nil
 	if sampleState == nil {
 		return false
 	}
 
-	// Get all the states for the sample
-	states, ok := db.SampleAttributesBySampleIDAndStates[sampleState.sample.ID]
-	if !ok {
-		return false
+	if isNullOperation(match.Operation) && !sampleState.sameState {
+		wholeSample := &SampleState{sample: sampleState.sample}
+		return trace.sample(db, wholeSample, match, evalSampleAttributeNullMatch(sampleState.sample, db, match))
 	}
 
-	// Get all the attributes associated with the specific sample and sample state
-	attributes, ok := states[sampleState.EntityStateID]
-	if !ok {
-		fmt.Printf("     Sample %d/%s with state %d has no attributes\n", sampleState.sample.ID, sampleState.sample.Name, sampleState.EntityStateID)
-		return false
+	if sampleState.anyState {
+		return evalForEachSampleState(sampleState, db, func(state *SampleState) bool {
+			return evalSampleAttributeFieldMatch(state, db, match, trace)
+		})
 	}
 
-	// From that list of attributes, check if it contains the specific attribute
+	// Get all the attributes associated with the specific sample and sample state
+	attributes := db.SampleAttributesBySampleIDAndStates[sampleState.sample.ID][sampleState.EntityStateID]
+
+	// From that list of attributes, check if it contains the specific attribute. A missing attribute
+	// is null.
 	attribute, ok := attributes[match.FieldName]
+	if isNullOperation(match.Operation) {
+		return trace.sample(db, sampleState, match, evalAttributeNullMatch(attribute, match))
+	}
+
 	if !ok {
 		return false
 	}
 
-	// Attributes can have multiple values, loop through each value, stopping if a match evaluates to true.
-	for _, value := range attribute.AttributeValues {
-		switch value.ValueType {
-		case mcmodel.ValueTypeInt:
-			return tryEvalAttributeIntMatch(value.ValueInt, match)
-		case mcmodel.ValueTypeFloat:
-			return tryEvalAttributeFloatMatch(value.ValueFloat, match)
-		case mcmodel.ValueTypeString:
-			return tryEvalAttributeStringMatch(value.ValueString, match)
+	return trace.sample(db, sampleState, match, evalAttributeMatch(attribute, match))
+}
+
+// evalSampleAttributeNullMatch evaluates is-null and is-not-null against a sample. The attribute is null for
+// the sample when none of its states has a value for it, and not null when at least one of them does.
+func evalSampleAttributeNullMatch(sample *mcmodel.Entity, db *DB, match MatchStatement) bool {
+	hasValue := false
+	for _, attributes := range db.SampleAttributesBySampleIDAndStates[sample.ID] {
+		if !attributeIsNull(attributes[match.FieldName]) {
+			hasValue = true
+			break
+		}
+	}
+
+	return hasValue == (match.Operation == "is-not-null")
+}
+
+// evalForEachSampleState runs evalFn against each of the states of the sample in sampleState, in order,
+// stopping when evalFn returns true.
+func evalForEachSampleState(sampleState *SampleState, db *DB, evalFn func(state *SampleState) bool) bool {
+	for _, entityState := range sampleState.sample.EntityStates {
+		state := &SampleState{
+			sample:        sampleState.sample,
+			EntityStateID: entityState.ID,
+		}
+		if evalFn(state) {
+			return true
 		}
 	}
 
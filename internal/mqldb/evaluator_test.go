@@ -2,7 +2,11 @@ package mqldb
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
 	"testing"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
 )
 
 func TestSimpleProcessQueries(t *testing.T) {
@@ -259,3 +263,564 @@ func TestSimpleSelectSamplesThroughProcessesQuery(t *testing.T) {
 		t.Fatalf("Expected matchingSamples length = 2, got %d", len(matchingSamples))
 	}
 }
+
+func TestNotStatement(t *testing.T) {
+	db := createTestDB()
+
+	// S1 and S2 went through the EBSD process with a 'Wide' beam, S3 through one with a 'Thin' beam.
+	// Evaluating through the processes instead would also match S1 and S2 through their Texture
+	// process, which has no 'Beam Type'.
+	notWideBeam := NotStatement{
+		Statement: MatchStatement{
+			FieldType: ProcessAttributeFieldType,
+			FieldName: "Beam Type",
+			Operation: "=",
+			Value:     "Wide",
+		},
+	}
+
+	_, matchingSamples := EvalStatement(db, selectAllSamples(), notWideBeam)
+	if len(matchingSamples) != 1 || matchingSamples[0].Name != "S3" {
+		t.Fatalf("Expected only S3 to match not process attribute 'Beam Type' = 'Wide', got %+v", matchingSamples)
+	}
+
+	notEBSD := NotStatement{
+		Statement: MatchStatement{
+			FieldType: ProcessFieldType,
+			FieldName: "name",
+			Operation: "=",
+			Value:     "EBSD",
+		},
+	}
+
+	matchingProcesses, _ := EvalStatement(db, selectAllProcesses(), notEBSD)
+	if len(matchingProcesses) != 2 {
+		t.Fatalf("Expected 2 matches on not process name = 'EBSD', got %d", len(matchingProcesses))
+	}
+
+	notS1OrS2 := NotStatement{
+		Statement: OrStatement{
+			Left: MatchStatement{
+				FieldType: SampleFieldType,
+				FieldName: "name",
+				Operation: "=",
+				Value:     "S1",
+			},
+			Right: MatchStatement{
+				FieldType: SampleFieldType,
+				FieldName: "name",
+				Operation: "=",
+				Value:     "S2",
+			},
+		},
+	}
+
+	_, matchingSamples = EvalStatement(db, selectAllSamples(), notS1OrS2)
+	if len(matchingSamples) != 1 || matchingSamples[0].Name != "S3" {
+		t.Fatalf("Expected only S3 to match not (name = 'S1' or name = 'S2'), got %+v", matchingSamples)
+	}
+
+	// Processes not used by S3
+	matchingProcesses, _ = EvalStatement(db, selectAllProcesses(), NotStatement{
+		Statement: MatchStatement{
+			FieldType: SampleFieldType,
+			FieldName: "name",
+			Operation: "=",
+			Value:     "S3",
+		},
+	})
+	if len(matchingProcesses) != 2 {
+		t.Fatalf("Expected 2 processes to match not sample name = 'S3', got %d", len(matchingProcesses))
+	}
+}
+
+func TestNotStatementAcrossSampleStates(t *testing.T) {
+	db := createTestDB()
+
+	// S2 has a bend in state 4 but not in state 3, so it doesn't match even though one of its states has
+	// no bend.
+	notBentRight := NotStatement{
+		Statement: MatchStatement{
+			FieldType: SampleAttributeFieldType,
+			FieldName: "bend",
+			Operation: "=",
+			Value:     "Right",
+		},
+	}
+
+	_, matchingSamples := EvalStatement(db, selectAllSamples(), notBentRight)
+	if ids := sampleIDs(matchingSamples); !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Fatalf("Expected S1 and S3 to match not bend = 'Right', got %v", ids)
+	}
+
+	// Scoped to a state, the negation only applies to that state, so S2 matches in state 3.
+	sameStateNotBent := SameStateStatement{
+		Statement: AndStatement{
+			Left:  MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "alloy", Operation: "=", Value: "zn45"},
+			Right: notBentRight,
+		},
+	}
+
+	_, matchingSamples = EvalStatement(db, selectAllSamples(), sameStateNotBent)
+	if ids := sampleIDs(matchingSamples); !reflect.DeepEqual(ids, []int{2}) {
+		t.Fatalf("Expected S2 to match alloy = 'zn45' and not bend = 'Right' in the same state, got %v", ids)
+	}
+}
+
+func TestMultiValuedAttributeQuantifiers(t *testing.T) {
+	db := createTestDB()
+
+	// S2 has grain sizes 11 and 15, S3 has grain sizes 8 and 12
+	grainSizeMatch := MatchStatement{
+		FieldType: SampleAttributeFieldType,
+		FieldName: "grain size",
+		Operation: ">",
+		Value:     10,
+	}
+
+	_, matchingSamples := EvalStatement(db, selectAllSamples(), grainSizeMatch)
+	if len(matchingSamples) != 2 {
+		t.Fatalf("Expected 2 matches on any value of 'grain size' > 10, got %d", len(matchingSamples))
+	}
+
+	grainSizeMatch.Quantifier = QuantifierAny
+	_, matchingSamples = EvalStatement(db, selectAllSamples(), grainSizeMatch)
+	if len(matchingSamples) != 2 {
+		t.Fatalf("Expected 2 matches on any value of 'grain size' > 10, got %d", len(matchingSamples))
+	}
+
+	grainSizeMatch.Quantifier = QuantifierAll
+	_, matchingSamples = EvalStatement(db, selectAllSamples(), grainSizeMatch)
+	if len(matchingSamples) != 1 || matchingSamples[0].Name != "S2" {
+		t.Fatalf("Expected only S2 to match all values of 'grain size' > 10, got %+v", matchingSamples)
+	}
+
+	// The second value of S3 is the only value that matches
+	grainSizeMatch.Quantifier = QuantifierAny
+	grainSizeMatch.Operation = "="
+	grainSizeMatch.Value = 12
+	_, matchingSamples = EvalStatement(db, selectAllSamples(), grainSizeMatch)
+	if len(matchingSamples) != 1 || matchingSamples[0].Name != "S3" {
+		t.Fatalf("Expected only S3 to match any value of 'grain size' = 12, got %+v", matchingSamples)
+	}
+}
+
+func TestSameStateAndSameSampleStatements(t *testing.T) {
+	db := createTestDB()
+
+	isEBSD := MatchStatement{
+		FieldType: ProcessFieldType,
+		FieldName: "name",
+		Operation: "=",
+		Value:     "EBSD",
+	}
+
+	// zn = 0.6 only occurs in state 4 of S2, and mg = 0.4 in state 1 of S1 and state 3 of S2
+	znAndMg := AndStatement{
+		Left: MatchStatement{
+			FieldType: SampleAttributeFieldType,
+			FieldName: "zn",
+			Operation: "=",
+			Value:     0.6,
+		},
+		Right: MatchStatement{
+			FieldType: SampleAttributeFieldType,
+			FieldName: "mg",
+			Operation: "=",
+			Value:     0.4,
+		},
+	}
+
+	// In a process context each sample condition is checked independently, so the EBSD process that
+	// S1 and S2 went through matches
+	matchingProcesses, _ := EvalStatement(db, selectAllProcesses(), AndStatement{Left: isEBSD, Right: znAndMg})
+	if len(matchingProcesses) != 1 {
+		t.Fatalf("Expected 1 process to match name = 'EBSD' and zn = 0.6 and mg = 0.4, got %d", len(matchingProcesses))
+	}
+
+	matchingProcesses, _ = EvalStatement(db, selectAllProcesses(),
+		AndStatement{Left: isEBSD, Right: SameStateStatement{Statement: znAndMg}})
+	if len(matchingProcesses) != 0 {
+		t.Fatalf("Expected no processes to match name = 'EBSD' and same-state(zn = 0.6 and mg = 0.4), got %d",
+			len(matchingProcesses))
+	}
+
+	// zn = 0.6 is on S2, hardness = 1 is on S1, so different samples of the same process
+	znAndHardness := AndStatement{
+		Left: znAndMg.Left,
+		Right: MatchStatement{
+			FieldType: SampleAttributeFieldType,
+			FieldName: "hardness",
+			Operation: "=",
+			Value:     1,
+		},
+	}
+
+	matchingProcesses, _ = EvalStatement(db, selectAllProcesses(), AndStatement{Left: isEBSD, Right: znAndHardness})
+	if len(matchingProcesses) != 1 {
+		t.Fatalf("Expected 1 process to match name = 'EBSD' and zn = 0.6 and hardness = 1, got %d", len(matchingProcesses))
+	}
+
+	matchingProcesses, _ = EvalStatement(db, selectAllProcesses(),
+		AndStatement{Left: isEBSD, Right: SameSampleStatement{Statement: znAndHardness}})
+	if len(matchingProcesses) != 0 {
+		t.Fatalf("Expected no processes to match name = 'EBSD' and same-sample(zn = 0.6 and hardness = 1), got %d",
+			len(matchingProcesses))
+	}
+
+	// Samples are already evaluated a state at a time, so no sample matches unless the conditions are
+	// allowed to be satisfied in different states
+	_, matchingSamples := EvalStatement(db, selectAllSamples(), znAndMg)
+	if len(matchingSamples) != 0 {
+		t.Fatalf("Expected no samples to match zn = 0.6 and mg = 0.4, got %d", len(matchingSamples))
+	}
+
+	_, matchingSamples = EvalStatement(db, selectAllSamples(), SameSampleStatement{Statement: znAndMg})
+	if len(matchingSamples) != 1 || matchingSamples[0].Name != "S2" {
+		t.Fatalf("Expected only S2 to match same-sample(zn = 0.6 and mg = 0.4), got %+v", matchingSamples)
+	}
+}
+
+func TestStringPatternOperators(t *testing.T) {
+	db := createTestDB()
+
+	tests := []struct {
+		match    MatchStatement
+		expected int
+	}{
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "like", Value: "E%D"}, 2},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "like", Value: "e%d"}, 0},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "ilike", Value: "e%d"}, 2},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "ilike", Value: "texture"}, 2},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "like", Value: "EBS_"}, 2},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "contains", Value: "xtu"}, 2},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "starts-with", Value: "Tex"}, 2},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "~", Value: "^(EBSD|Texture)$"}, 4},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "Beam Type", Operation: "~", Value: "[Tt]hin"}, 1},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "note", Operation: "contains", Value: "ignore"}, 2},
+	}
+
+	for _, test := range tests {
+		matchingProcesses, _ := EvalStatement(db, selectAllProcesses(), test.match)
+		if len(matchingProcesses) != test.expected {
+			t.Errorf("Expected %d processes to match %s %q, got %d", test.expected, test.match.Operation,
+				test.match.Value, len(matchingProcesses))
+		}
+	}
+
+	// The % and _ wildcards apply to sample names too, and other regular expression characters are literal.
+	_, matchingSamples := EvalStatement(db, selectAllSamples(),
+		MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "like", Value: "S_"})
+	if len(matchingSamples) != 3 {
+		t.Fatalf("Expected 3 samples to match name like 'S_', got %d", len(matchingSamples))
+	}
+
+	_, matchingSamples = EvalStatement(db, selectAllSamples(),
+		MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "like", Value: "S."})
+	if len(matchingSamples) != 0 {
+		t.Fatalf("Expected no samples to match name like 'S.', got %d", len(matchingSamples))
+	}
+
+	// The wildcards match across the lines of a multi-line value.
+	db.ProcessAttributesByProcessID[3]["note"].AttributeValues[0].ValueString = "quenched in oil\nignore these results"
+	multiLineTests := []struct {
+		pattern  string
+		expected int
+	}{
+		{"%IGNORE%", 2},
+		{"quenched in oil%", 1},
+		{"%oil_ignore%", 1},
+	}
+
+	for _, test := range multiLineTests {
+		matchingProcesses, _ := EvalStatement(db, selectAllProcesses(),
+			MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "note", Operation: "ilike", Value: test.pattern})
+		if len(matchingProcesses) != test.expected {
+			t.Errorf("Expected %d processes to match note ilike %q, got %d", test.expected, test.pattern, len(matchingProcesses))
+		}
+	}
+}
+
+func TestValidateStatement(t *testing.T) {
+	valid := OrStatement{
+		Left:  MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "~", Value: "^S[0-9]$"},
+		Right: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "like", Value: "S%"},
+	}
+
+	if err := ValidateStatement(valid); err != nil {
+		t.Fatalf("Expected statement to be valid, got %s", err)
+	}
+
+	invalid := NotStatement{
+		Statement: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "~", Value: "S[1"},
+	}
+
+	if err := ValidateStatement(invalid); err == nil {
+		t.Fatalf("Expected invalid regular expression to fail validation")
+	}
+
+	prepared, err := prepareStatement(valid)
+	if err != nil {
+		t.Fatalf("Unexpected error preparing statement: %s", err)
+	}
+
+	or := prepared.(OrStatement)
+	if or.Left.(MatchStatement).regex == nil || or.Right.(MatchStatement).regex == nil {
+		t.Fatalf("Expected prepared statement to have compiled patterns, got %+v", prepared)
+	}
+}
+
+func TestInAndBetweenOperators(t *testing.T) {
+	db := createTestDB()
+
+	processTests := []struct {
+		match    MatchStatement
+		expected int
+	}{
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "in", Value: []interface{}{"EBSD", "SEM"}}, 2},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "id", Operation: "in", Value: []interface{}{1, 4.0}}, 2},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "id", Operation: "between", Value: []interface{}{2, 3}}, 2},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "id", Operation: ">", Value: 3}, 1},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "Beam Type", Operation: "in", Value: []interface{}{"Wide", "Thin"}}, 2},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "frames per second", Operation: "in", Value: []interface{}{3.0, 4}}, 1},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "PF scale max", Operation: "between", Value: []interface{}{2, 3}}, 2},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "PF scale max", Operation: "between", Value: []interface{}{2.5, 3}}, 1},
+	}
+
+	for _, test := range processTests {
+		matchingProcesses, _ := EvalStatement(db, selectAllProcesses(), test.match)
+		if len(matchingProcesses) != test.expected {
+			t.Errorf("Expected %d processes to match %s %s %v, got %d", test.expected, test.match.FieldName,
+				test.match.Operation, test.match.Value, len(matchingProcesses))
+		}
+	}
+
+	sampleTests := []struct {
+		match    MatchStatement
+		expected int
+	}{
+		{MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "in", Value: []interface{}{"S1", "S3"}}, 2},
+		{MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "zn", Operation: "between", Value: []interface{}{0.55, 0.7}}, 2},
+		{MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "grain size", Operation: "between", Value: []interface{}{10, 16}}, 2},
+		{MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "grain size", Operation: "between", Value: []interface{}{10, 16}, Quantifier: QuantifierAll}, 1},
+	}
+
+	for _, test := range sampleTests {
+		_, matchingSamples := EvalStatement(db, selectAllSamples(), test.match)
+		if len(matchingSamples) != test.expected {
+			t.Errorf("Expected %d samples to match %s %s %v, got %d", test.expected, test.match.FieldName,
+				test.match.Operation, test.match.Value, len(matchingSamples))
+		}
+	}
+
+	if err := ValidateStatement(MatchStatement{FieldType: SampleFieldType, FieldName: "id", Operation: "between", Value: []interface{}{1}}); err == nil {
+		t.Fatalf("Expected between with a single bound to fail validation")
+	}
+
+	if err := ValidateStatement(MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "in", Value: "S1"}); err == nil {
+		t.Fatalf("Expected in without a list to fail validation")
+	}
+}
+
+func TestUnitConversion(t *testing.T) {
+	db := createTestDB()
+
+	// 800 K is 526.85 C, 900 F is 482.22 C
+	temperatures := map[int]mcmodel.AttributeValue{
+		1: {ValueType: mcmodel.ValueTypeInt, ValueInt: 900, Unit: "F"},
+		3: {ValueType: mcmodel.ValueTypeFloat, ValueFloat: 800, Unit: "K"},
+		4: {ValueType: mcmodel.ValueTypeInt, ValueInt: 450, Unit: "C"},
+	}
+
+	for processID, value := range temperatures {
+		db.ProcessAttributesByProcessID[processID]["temperature"] = &mcmodel.Attribute{
+			Name:            "temperature",
+			AttributeValues: []mcmodel.AttributeValue{value},
+		}
+	}
+
+	tests := []struct {
+		match    MatchStatement
+		expected int
+	}{
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "temperature", Operation: ">", Value: 500, Unit: "C"}, 1},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "temperature", Operation: "=", Value: 450, Unit: "C"}, 1},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "temperature", Operation: "=", Value: 800, Unit: "K"}, 1},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "temperature", Operation: "between", Value: []interface{}{480, 530}, Unit: "C"}, 2},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "temperature", Operation: ">", Value: 0, Unit: "mm"}, 0},
+
+		// Without a unit the stored numbers are compared as they are
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "temperature", Operation: ">", Value: 500}, 2},
+	}
+
+	for _, test := range tests {
+		matchingProcesses, _ := EvalStatement(db, selectAllProcesses(), test.match)
+		if len(matchingProcesses) != test.expected {
+			t.Errorf("Expected %d processes to match temperature %s %v %s, got %d", test.expected,
+				test.match.Operation, test.match.Value, test.match.Unit, len(matchingProcesses))
+		}
+	}
+}
+
+func TestNullMatches(t *testing.T) {
+	db := createTestDB()
+
+	// Process 2 has a note without any values, and process 4 a note whose value failed to convert
+	db.ProcessAttributesByProcessID[2]["note"] = &mcmodel.Attribute{Name: "note"}
+	db.ProcessAttributesByProcessID[4]["note"] = &mcmodel.Attribute{
+		Name:            "note",
+		AttributeValues: []mcmodel.AttributeValue{{ValueType: mcmodel.ValueTypeUnset, Val: "unparseable"}},
+	}
+
+	processTests := []struct {
+		match    MatchStatement
+		expected []int
+	}{
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "Beam Type", Operation: "is-null"}, []int{3, 4}},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "Beam Type", Operation: "is-not-null"}, []int{1, 2}},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "note", Operation: "is-null"}, []int{2, 4}},
+		{MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "note", Operation: "is-not-null"}, []int{1, 3}},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "is-null"}, nil},
+		{MatchStatement{FieldType: ProcessFieldType, FieldName: "id", Operation: "is-not-null"}, []int{1, 2, 3, 4}},
+	}
+
+	for _, test := range processTests {
+		matchingProcesses, _ := EvalStatement(db, selectAllProcesses(), test.match)
+		var ids []int
+		for _, process := range matchingProcesses {
+			ids = append(ids, process.ID)
+		}
+		sort.Ints(ids)
+
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("Expected processes %v to match %s %s, got %v", test.expected, test.match.FieldName,
+				test.match.Operation, ids)
+		}
+	}
+
+	// Only the second state of S1 has a hardness, and only S2 and S3 have a grain size
+	_, matchingSamples := EvalStatement(db, selectAllSamples(),
+		MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "hardness", Operation: "is-not-null"})
+	if len(matchingSamples) != 1 || matchingSamples[0].Name != "S1" {
+		t.Fatalf("Expected only S1 to match hardness is not null, got %+v", matchingSamples)
+	}
+
+	// A sample only has a null hardness when none of its states has one
+	hardnessIsNull := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "hardness", Operation: "is-null"}
+	_, matchingSamples = EvalStatement(db, selectAllSamples(), hardnessIsNull)
+	if ids := sampleIDs(matchingSamples); !reflect.DeepEqual(ids, []int{2, 3}) {
+		t.Fatalf("Expected S2 and S3 to match hardness is null, got %v", ids)
+	}
+
+	// Scoped to a state, every sample has a state without a hardness
+	_, matchingSamples = EvalStatement(db, selectAllSamples(), SameStateStatement{Statement: hardnessIsNull})
+	if len(matchingSamples) != 3 {
+		t.Fatalf("Expected all 3 samples to have a state where hardness is null, got %d", len(matchingSamples))
+	}
+
+	_, matchingSamples = EvalStatement(db, selectAllSamples(),
+		MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "grain size", Operation: "is-not-null"})
+	if len(matchingSamples) != 2 {
+		t.Fatalf("Expected S2 and S3 to match grain size is not null, got %+v", matchingSamples)
+	}
+}
+
+func TestResultsOrderedByID(t *testing.T) {
+	db := createTestDB()
+
+	// Matching through both processes and samples collects the results in maps, so without sorting
+	// the order would change from run to run. S3 matches through the second Texture process, which
+	// brings in the second EBSD process as well.
+	statement := OrStatement{
+		Left:  MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "=", Value: "Texture"},
+		Right: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "S1"},
+	}
+
+	for i := 0; i < 10; i++ {
+		processes, samples := EvalStatement(db, Selection{
+			ProcessSelection: ProcessSelection{All: true},
+			SampleSelection:  SampleSelection{All: true},
+		}, statement)
+
+		if ids := processIDs(processes); !reflect.DeepEqual(ids, []int{1, 2, 3, 4}) {
+			t.Fatalf("Expected processes in ID order [1 2 3 4], got %v", ids)
+		}
+
+		if ids := sampleIDs(samples); !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+			t.Fatalf("Expected samples in ID order [1 2 3], got %v", ids)
+		}
+	}
+}
+
+func TestOrderByLimitAndOffset(t *testing.T) {
+	db := createTestDB()
+
+	tests := []struct {
+		selection Selection
+		expected  []int
+	}{
+		{
+			// Processes without a 'frames per second' sort last, in ID order
+			Selection{
+				ProcessSelection: ProcessSelection{All: true},
+				OrderBy:          []OrderBy{{FieldType: ProcessAttributeFieldType, FieldName: "frames per second"}},
+			},
+			[]int{2, 1, 3, 4},
+		},
+		{
+			Selection{
+				ProcessSelection: ProcessSelection{All: true},
+				OrderBy: []OrderBy{
+					{FieldType: ProcessFieldType, FieldName: "name", Descending: true},
+					{FieldType: ProcessFieldType, FieldName: "id", Descending: true},
+				},
+			},
+			[]int{4, 3, 2, 1},
+		},
+		{
+			Selection{ProcessSelection: ProcessSelection{All: true}, Limit: 2, Offset: 1},
+			[]int{2, 3},
+		},
+		{
+			Selection{ProcessSelection: ProcessSelection{All: true}, Offset: 3},
+			[]int{4},
+		},
+		{
+			Selection{ProcessSelection: ProcessSelection{All: true}, Offset: 10},
+			[]int{},
+		},
+	}
+
+	for i, test := range tests {
+		processes, _ := EvalStatement(db, test.selection, nil)
+		if ids := processIDs(processes); !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("tests[%d] - Expected processes %v, got %v", i, test.expected, ids)
+		}
+	}
+
+	// S2 and S3 have a grain size in their second state, S1 doesn't have one
+	_, samples := EvalStatement(db, Selection{
+		SampleSelection: SampleSelection{All: true},
+		OrderBy:         []OrderBy{{FieldType: SampleAttributeFieldType, FieldName: "grain size", Descending: true}},
+		Limit:           2,
+	}, nil)
+	if ids := sampleIDs(samples); !reflect.DeepEqual(ids, []int{2, 3}) {
+		t.Fatalf("Expected samples [2 3], got %v", ids)
+	}
+}
+
+func processIDs(processes []mcmodel.Activity) []int {
+	ids := []int{}
+	for _, process := range processes {
+		ids = append(ids, process.ID)
+	}
+	return ids
+}
+
+func sampleIDs(samples []mcmodel.Entity) []int {
+	ids := []int{}
+	for _, sample := range samples {
+		ids = append(ids, sample.ID)
+	}
+	return ids
+}
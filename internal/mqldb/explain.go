@@ -0,0 +1,298 @@
+package mqldb
+
+import "github.com/materials-commons/gomcdb/mcmodel"
+
+// The ways a process or sample can end up in the results of a query. A sample is reached via a process when
+// it wasn't matched itself, but went through a process that was. Likewise a process is reached via a sample
+// when one of its samples was matched. See evalSelectSamples and evalSelectProcesses.
+const (
+	ReachedDirectly   = "direct"
+	ReachedViaProcess = "via-process"
+	ReachedViaSample  = "via-sample"
+)
+
+// Explanation says why a process or sample is in the results of a query. Matches are the match statements
+// that held for it when it was matched directly. Via lists the related processes or samples that were matched
+// directly, each with its own explanation, that pulled it into the results. An item can be both matched
+// directly and have related items that matched.
+type Explanation struct {
+	Type    string        `json:"type"`
+	ID      int           `json:"id"`
+	Name    string        `json:"name"`
+	Reached string        `json:"reached"`
+	Matches []LeafMatch   `json:"matches,omitempty"`
+	Via     []Explanation `json:"via,omitempty"`
+}
+
+// LeafMatch is a match statement that held, along with the process, sample and sample state it held for and
+// the field or attribute value it matched. A match on an attribute with several values gives the first value
+// that matched.
+//
+// A match statement inside a not is Negated, it held because it didn't match. A match statement inside an
+// upstream or downstream lineage held for a process or sample the result is Steps away from in the Lineage
+// direction.
+type LeafMatch struct {
+	Match         MatchStatement `json:"match"`
+	ProcessID     int            `json:"process_id,omitempty"`
+	SampleID      int            `json:"sample_id,omitempty"`
+	EntityStateID int            `json:"entity_state_id,omitempty"`
+	Value         interface{}    `json:"value,omitempty"`
+	Unit          string         `json:"unit,omitempty"`
+	Negated       bool           `json:"negated,omitempty"`
+	Lineage       string         `json:"lineage,omitempty"`
+	Steps         int            `json:"steps,omitempty"`
+}
+
+// explainCollector collects, as a query runs, the match statements that held for each process and sample that
+// matched the statement directly. See evalMatchingProcesses and evalMatchingSamples.
+type explainCollector struct {
+	processes map[int][]LeafMatch
+	samples   map[int][]LeafMatch
+}
+
+func newExplainCollector() *explainCollector {
+	return &explainCollector{
+		processes: make(map[int][]LeafMatch),
+		samples:   make(map[int][]LeafMatch),
+	}
+}
+
+// newTrace returns a matchTrace to evaluate the statement against a process or sample with, or nil when
+// nothing is being collected.
+func (c *explainCollector) newTrace() *matchTrace {
+	if c == nil {
+		return nil
+	}
+
+	return &matchTrace{}
+}
+
+// matchedProcess records the trace for a process that matched directly.
+func (c *explainCollector) matchedProcess(processID int, trace *matchTrace) {
+	if c != nil {
+		c.processes[processID] = trace.matches
+	}
+}
+
+// matchedSample records the trace for a sample that matched directly.
+func (c *explainCollector) matchedSample(sampleID int, trace *matchTrace) {
+	if c != nil {
+		c.samples[sampleID] = trace.matches
+	}
+}
+
+// explain explains why each of the processes and samples the query returned matched. The explanations for the
+// processes come first, followed by those for the samples, each in the order they were returned in. A nil
+// statement matches everything, so every item is reached directly without any matches. Related items are
+// only looked at when evalSelectProcesses and evalSelectSamples would have, so an item is only reached via a
+// related item that could have pulled it into the results.
+func (c *explainCollector) explain(db *DB, statement Statement, processes []mcmodel.Activity, samples []mcmodel.Entity) []Explanation {
+	directOnly := statement == nil || hasNotStatement(statement) || hasLineageStatement(statement)
+	viaSamples := !directOnly && hasSampleMatchStatement(statement)
+	viaProcesses := !directOnly && hasProcessMatchStatement(statement)
+
+	var explanations []Explanation
+	for _, process := range processes {
+		explanation := c.explainProcess(statement, process.ID, process.Name)
+		if viaSamples {
+			for _, sample := range db.ProcessSamples[process.ID] {
+				if matches, ok := c.samples[sample.ID]; ok {
+					explanation.Via = append(explanation.Via, Explanation{
+						Type:    "sample",
+						ID:      sample.ID,
+						Name:    sample.Name,
+						Reached: ReachedDirectly,
+						Matches: matches,
+					})
+				}
+			}
+
+			if explanation.Reached == "" && len(explanation.Via) != 0 {
+				explanation.Reached = ReachedViaSample
+			}
+		}
+
+		explanations = append(explanations, explanation)
+	}
+
+	for _, sample := range samples {
+		explanation := c.explainSample(statement, sample.ID, sample.Name)
+		if viaProcesses {
+			for _, process := range db.SampleProcesses[sample.ID] {
+				if matches, ok := c.processes[process.ID]; ok {
+					explanation.Via = append(explanation.Via, Explanation{
+						Type:    "process",
+						ID:      process.ID,
+						Name:    process.Name,
+						Reached: ReachedDirectly,
+						Matches: matches,
+					})
+				}
+			}
+
+			if explanation.Reached == "" && len(explanation.Via) != 0 {
+				explanation.Reached = ReachedViaProcess
+			}
+		}
+
+		explanations = append(explanations, explanation)
+	}
+
+	return explanations
+}
+
+// explainProcess explains a process that matched directly. Reached is left empty if it didn't.
+func (c *explainCollector) explainProcess(statement Statement, id int, name string) Explanation {
+	explanation := Explanation{Type: "process", ID: id, Name: name}
+	if matches, ok := c.processes[id]; ok || statement == nil {
+		explanation.Reached = ReachedDirectly
+		explanation.Matches = matches
+	}
+
+	return explanation
+}
+
+// explainSample explains a sample that matched directly. Reached is left empty if it didn't.
+func (c *explainCollector) explainSample(statement Statement, id int, name string) Explanation {
+	explanation := Explanation{Type: "sample", ID: id, Name: name}
+	if matches, ok := c.samples[id]; ok || statement == nil {
+		explanation.Reached = ReachedDirectly
+		explanation.Matches = matches
+	}
+
+	return explanation
+}
+
+// matchTrace records the match statements that held while evaluating a statement against a single process
+// or sample state. A nil matchTrace records nothing, so the evaluator can always call it.
+type matchTrace struct {
+	matches []LeafMatch
+}
+
+// mark returns the point to rollback to.
+func (t *matchTrace) mark() int {
+	if t == nil {
+		return 0
+	}
+
+	return len(t.matches)
+}
+
+// rollback drops the matches recorded since mark, when the statement they were recorded for didn't hold.
+func (t *matchTrace) rollback(mark int) {
+	if t != nil {
+		t.matches = t.matches[:mark]
+	}
+}
+
+// addLeaves records each of the match statements in statement, without anything they matched. fn is called
+// on each of them.
+func (t *matchTrace) addLeaves(statement Statement, fn func(match *LeafMatch)) {
+	if t != nil {
+		t.matches = append(t.matches, leafMatches(statement, fn)...)
+	}
+}
+
+// process records what the match matched against the process when matched is true. It returns matched.
+func (t *matchTrace) process(db *DB, process *mcmodel.Activity, match MatchStatement, matched bool) bool {
+	if t == nil || !matched {
+		return matched
+	}
+
+	leaf := LeafMatch{Match: match, ProcessID: process.ID}
+	switch match.FieldType {
+	case ProcessFieldType:
+		leaf.Value = fieldValueOf(process.Name, process.ID, match.FieldName)
+	case ProcessAttributeFieldType:
+		leaf.Value, leaf.Unit = matchedAttributeValue(db.ProcessAttributesByProcessID[process.ID][match.FieldName], match)
+	case ProcessFuncType:
+		if match.Operation == "has-sample" {
+			leaf.SampleID = sampleNamed(db.ProcessSamples[process.ID], match.Value)
+		}
+	}
+
+	t.matches = append(t.matches, leaf)
+	return true
+}
+
+// sample records what the match matched against the sample state when matched is true. It returns matched.
+func (t *matchTrace) sample(db *DB, sampleState *SampleState, match MatchStatement, matched bool) bool {
+	if t == nil || !matched {
+		return matched
+	}
+
+	sample := sampleState.sample
+	leaf := LeafMatch{Match: match, SampleID: sample.ID}
+	switch match.FieldType {
+	case SampleFieldType:
+		leaf.Value = fieldValueOf(sample.Name, sample.ID, match.FieldName)
+	case SampleAttributeFieldType:
+		if sampleState.EntityStateID != 0 {
+			attribute := db.SampleAttributesBySampleIDAndStates[sample.ID][sampleState.EntityStateID][match.FieldName]
+			leaf.EntityStateID = sampleState.EntityStateID
+			leaf.Value, leaf.Unit = matchedAttributeValue(attribute, match)
+		}
+	case SampleFuncType:
+		switch match.Operation {
+		case "has-attribute":
+			leaf.EntityStateID = sampleState.EntityStateID
+		case "has-process":
+			leaf.ProcessID = processNamed(db.SampleProcesses[sample.ID], match.Value)
+		}
+	}
+
+	t.matches = append(t.matches, leaf)
+	return true
+}
+
+// leafMatches returns a LeafMatch, without anything it matched, for each of the match statements in statement.
+// fn is called on each of them.
+func leafMatches(statement Statement, fn func(match *LeafMatch)) []LeafMatch {
+	var matches []LeafMatch
+	_, _ = mapMatchStatements(statement, func(match MatchStatement) (MatchStatement, error) {
+		leaf := LeafMatch{Match: match}
+		fn(&leaf)
+		matches = append(matches, leaf)
+		return match, nil
+	})
+
+	return matches
+}
+
+// processNamed returns the ID of the first of the processes with the name, or 0 if there isn't one.
+func processNamed(processes []*mcmodel.Activity, name interface{}) int {
+	for _, process := range processes {
+		if process.Name == name {
+			return process.ID
+		}
+	}
+
+	return 0
+}
+
+// sampleNamed returns the ID of the first of the samples with the name, or 0 if there isn't one.
+func sampleNamed(samples []*mcmodel.Entity, name interface{}) int {
+	for _, sample := range samples {
+		if sample.Name == name {
+			return sample.ID
+		}
+	}
+
+	return 0
+}
+
+// matchedAttributeValue returns the first of the attribute's values that the match matched, along with its
+// unit. There is no value for is-null and is-not-null.
+func matchedAttributeValue(attribute *mcmodel.Attribute, match MatchStatement) (interface{}, string) {
+	if attribute == nil || isNullOperation(match.Operation) {
+		return nil, ""
+	}
+
+	for _, value := range attribute.AttributeValues {
+		if evalAttributeValueMatch(value, match) {
+			return attributeValueOf(value), value.Unit
+		}
+	}
+
+	return nil, ""
+}
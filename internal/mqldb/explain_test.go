@@ -0,0 +1,144 @@
+package mqldb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExplainSampleReachedViaProcess(t *testing.T) {
+	db := createTestDB()
+	thinBeam := MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "Beam Type", Operation: "=", Value: "Thin"}
+
+	explanations := EvalQuery(db, selectAllSamples(), thinBeam, true).Explanations
+
+	expected := []Explanation{
+		{
+			Type:    "sample",
+			ID:      3,
+			Name:    "S3",
+			Reached: ReachedViaProcess,
+			Via: []Explanation{
+				{
+					Type:    "process",
+					ID:      2,
+					Name:    "EBSD",
+					Reached: ReachedDirectly,
+					Matches: []LeafMatch{{Match: thinBeam, ProcessID: 2, Value: "Thin"}},
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(explanations, expected) {
+		t.Fatalf("Expected explanations %+v, got %+v", expected, explanations)
+	}
+}
+
+func TestExplainSampleMatchedDirectly(t *testing.T) {
+	db := createTestDB()
+	highZn := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "zn", Operation: ">", Value: 0.55}
+	ebsd := MatchStatement{FieldType: SampleFuncType, Operation: "has-process", Value: "EBSD"}
+	statement := AndStatement{Left: highZn, Right: ebsd}
+
+	explanations := EvalQuery(db, selectAllSamples(), statement, true).Explanations
+
+	// The first state of S2 with a high zn is explained, along with the process that has-process found.
+	expected := []Explanation{
+		{
+			Type:    "sample",
+			ID:      2,
+			Name:    "S2",
+			Reached: ReachedDirectly,
+			Matches: []LeafMatch{
+				{Match: highZn, SampleID: 2, EntityStateID: 4, Value: 0.6},
+				{Match: ebsd, SampleID: 2, ProcessID: 1},
+			},
+		},
+		{
+			Type:    "sample",
+			ID:      3,
+			Name:    "S3",
+			Reached: ReachedDirectly,
+			Matches: []LeafMatch{
+				{Match: highZn, SampleID: 3, EntityStateID: 5, Value: 0.68},
+				{Match: ebsd, SampleID: 3, ProcessID: 2},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(explanations, expected) {
+		t.Fatalf("Expected explanations %+v, got %+v", expected, explanations)
+	}
+}
+
+func TestExplainProcessReachedViaSample(t *testing.T) {
+	db := createTestDB()
+	alloy := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "alloy", Operation: "=", Value: "zn45"}
+
+	explanations := EvalQuery(db, selectAllProcesses(), alloy, true).Explanations
+
+	viaS2 := []Explanation{
+		{
+			Type:    "sample",
+			ID:      2,
+			Name:    "S2",
+			Reached: ReachedDirectly,
+			Matches: []LeafMatch{{Match: alloy, SampleID: 2, EntityStateID: 3, Value: "zn45"}},
+		},
+	}
+
+	expected := []Explanation{
+		{Type: "process", ID: 1, Name: "EBSD", Reached: ReachedViaSample, Via: viaS2},
+		{Type: "process", ID: 3, Name: "Texture", Reached: ReachedViaSample, Via: viaS2},
+	}
+
+	if !reflect.DeepEqual(explanations, expected) {
+		t.Fatalf("Expected explanations %+v, got %+v", expected, explanations)
+	}
+}
+
+func TestExplainOrMatchedBothSides(t *testing.T) {
+	db := createTestDB()
+	wide := MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "Beam Type", Operation: "=", Value: "Wide"}
+	fast := MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "frames per second", Operation: ">", Value: 4}
+	statement := OrStatement{Left: wide, Right: fast}
+
+	explanations := EvalQuery(db, selectAllProcesses(), statement, true).Explanations
+
+	expected := []Explanation{
+		{
+			Type:    "process",
+			ID:      1,
+			Name:    "EBSD",
+			Reached: ReachedDirectly,
+			Matches: []LeafMatch{
+				{Match: wide, ProcessID: 1, Value: "Wide"},
+				{Match: fast, ProcessID: 1, Value: int64(5)},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(explanations, expected) {
+		t.Fatalf("Expected explanations %+v, got %+v", expected, explanations)
+	}
+}
+
+func TestExplainNegatedMatch(t *testing.T) {
+	db := createTestDB()
+	bend := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "bend", Operation: "=", Value: "Right"}
+	statement := NotStatement{Statement: bend}
+
+	explanations := EvalQuery(db, selectAllSamples(), statement, true).Explanations
+
+	// S2 has a bend in one of its states, so only S1 and S3 match
+	if len(explanations) != 2 {
+		t.Fatalf("Expected 2 explanations, got %+v", explanations)
+	}
+
+	for _, explanation := range explanations {
+		expectedMatches := []LeafMatch{{Match: bend, Negated: true}}
+		if explanation.Reached != ReachedDirectly || !reflect.DeepEqual(explanation.Matches, expectedMatches) {
+			t.Fatalf("Expected sample %d to be matched directly by the negated match, got %+v", explanation.ID, explanation)
+		}
+	}
+}
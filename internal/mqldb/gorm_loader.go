@@ -0,0 +1,245 @@
+package mqldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+	"gorm.io/gorm"
+)
+
+// GormLoader loads a project from a database with the Materials Commons schema, which is MySQL in
+// production. The project's processes are in activities, its samples in entities and entity_states,
+// their attributes in attributes and attribute_values, and which samples went through which processes
+// in activity2entity.
+type GormLoader struct {
+	db *gorm.DB
+}
+
+func NewGormLoader(db *gorm.DB) *GormLoader {
+	return &GormLoader{db: db}
+}
+
+func (l *GormLoader) Load(db *DB) error {
+	// Make sure project exists
+	var project mcmodel.Project
+	if err := l.db.First(&project, db.ProjectID).Error; err != nil {
+		return err
+	}
+
+	// Anything changed while the project is being read is picked up by the next refresh.
+	loadedAt, err := l.now()
+	if err != nil {
+		return err
+	}
+
+	dump := &ProjectDump{ProjectID: db.ProjectID, ProjectUpdatedAt: project.UpdatedAt, LoadedAt: loadedAt}
+	db.progress.update(LoadStageProcesses, nil)
+	if err := l.loadProcessesAndAttributes(dump); err != nil {
+		return err
+	}
+
+	db.progress.update(LoadStageSamples, func(counts *LoadCounts) {
+		counts.Processes = len(dump.Processes)
+		counts.ProcessAttributes = len(dump.ProcessAttributes)
+	})
+	if err := l.loadSamplesAndAttributes(dump); err != nil {
+		return err
+	}
+
+	db.progress.update(LoadStageProcessSample, func(counts *LoadCounts) {
+		counts.Samples = len(dump.Samples)
+		counts.SampleAttributes = len(dump.SampleAttributes)
+	})
+	if err := l.loadProcessSampleMappings(dump); err != nil {
+		return err
+	}
+
+	db.loadDump(dump)
+
+	return nil
+}
+
+func (l *GormLoader) loadProcessesAndAttributes(dump *ProjectDump) error {
+	if err := l.db.Where("project_id = ?", dump.ProjectID).Find(&dump.Processes).Error; err != nil {
+		return err
+	}
+
+	if err := l.loadProcessCreatedAt(dump.ProjectID, &dump.ProcessCreatedAt); err != nil {
+		return err
+	}
+
+	return l.db.Preload("AttributeValues").Where("attributable_type = ?", "App\\Models\\Activity").
+		Where("attributable_id in (select id from activities where project_id = ?)", dump.ProjectID).
+		Find(&dump.ProcessAttributes).Error
+}
+
+// loadProcessCreatedAt reads when each of the project's processes was created.
+func (l *GormLoader) loadProcessCreatedAt(projectID int, createdAt *[]ProcessCreatedAt) error {
+	return l.db.Model(&mcmodel.Activity{}).Where("project_id = ?", projectID).Order("id").Find(createdAt).Error
+}
+
+func (l *GormLoader) loadSamplesAndAttributes(dump *ProjectDump) error {
+	err := l.db.Preload("EntityStates").Where("project_id = ?", dump.ProjectID).Find(&dump.Samples).Error
+	if err != nil {
+		return err
+	}
+
+	return l.db.Preload("AttributeValues").Where("attributable_type = ?", "App\\Models\\EntityState").
+		Where(`attributable_id in 
+                       (select distinct id from entity_states where entity_id in 
+                               (select id from entities where project_id = ?))`, dump.ProjectID).
+		Find(&dump.SampleAttributes).Error
+}
+
+func (l *GormLoader) loadProcessSampleMappings(dump *ProjectDump) error {
+	return l.db.Where("entity_id in (select id from entities where project_id = ?)", dump.ProjectID).
+		Find(&dump.ProcessSamples).Error
+}
+
+// LoadChanges reads the processes, samples and attributes of the project that were added or changed since the
+// DB was loaded, going by their updated_at. The database sets updated_at, so the time the DB was loaded comes
+// from the database's clock, and as updated_at may only have seconds rows updated in the second the DB was
+// loaded are read again. An attribute is also read when one of its values changed. Rows that were deleted
+// don't leave anything behind to find by updated_at, so the IDs of every process, sample, attribute and
+// attribute value are read to find what is gone. Sample states and activity2entity only hold IDs, and the creation
+// times of processes are small, so they are read in full. The counts reported to progress are of what changed.
+func (l *GormLoader) LoadChanges(db *DB, progress *LoadProgress) (*ProjectChanges, error) {
+	var project mcmodel.Project
+	if err := l.db.First(&project, db.ProjectID).Error; err != nil {
+		return nil, err
+	}
+
+	loadedAt, err := l.now()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := &ProjectChanges{ProjectUpdatedAt: project.UpdatedAt, LoadedAt: loadedAt}
+	projectID, since := db.ProjectID, db.LoadedAt
+
+	progress.update(LoadStageProcesses, nil)
+	err = l.db.Where("project_id = ? and updated_at >= ?", projectID, since).Find(&changes.Processes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.db.Model(&mcmodel.Activity{}).Where("project_id = ?", projectID).Order("id").Pluck("id", &changes.ProcessIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.loadProcessCreatedAt(projectID, &changes.ProcessCreatedAt); err != nil {
+		return nil, err
+	}
+
+	progress.update(LoadStageSamples, func(counts *LoadCounts) { counts.Processes = len(changes.Processes) })
+	if err := l.db.Where("project_id = ? and updated_at >= ?", projectID, since).Find(&changes.Samples).Error; err != nil {
+		return nil, err
+	}
+
+	err = l.db.Model(&mcmodel.Entity{}).Where("project_id = ?", projectID).Order("id").Pluck("id", &changes.SampleIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.db.Where("entity_id in (select id from entities where project_id = ?)", projectID).Order("id").
+		Find(&changes.SampleStates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	progress.update(LoadStageSamples, func(counts *LoadCounts) { counts.Samples = len(changes.Samples) })
+	processAttributes := l.db.Where("attributable_type = ?", "App\\Models\\Activity").
+		Where("attributable_id in (select id from activities where project_id = ?)", projectID)
+	err = l.loadAttributeChanges(processAttributes, since, db.AllProcessAttributes, &changes.ProcessAttributes, &changes.ProcessAttributeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	sampleAttributes := l.db.Where("attributable_type = ?", "App\\Models\\EntityState").
+		Where(`attributable_id in 
+                       (select distinct id from entity_states where entity_id in 
+                               (select id from entities where project_id = ?))`, projectID)
+	err = l.loadAttributeChanges(sampleAttributes, since, db.AllSampleAttributes, &changes.SampleAttributes, &changes.SampleAttributeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	progress.update(LoadStageProcessSample, func(counts *LoadCounts) {
+		counts.ProcessAttributes = len(changes.ProcessAttributes)
+		counts.SampleAttributes = len(changes.SampleAttributes)
+	})
+	err = l.db.Where("entity_id in (select id from entities where project_id = ?)", projectID).
+		Find(&changes.ProcessSamples).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// loadAttributeChanges reads the attributes selected by query that changed since, and the IDs of all of them.
+// Deleting a value doesn't change its attribute's updated_at, so the IDs of the values are compared with those
+// of the current attributes to find the ones that lost a value.
+func (l *GormLoader) loadAttributeChanges(query *gorm.DB, since time.Time, current []*mcmodel.Attribute, changed *[]*mcmodel.Attribute, ids *[]int) error {
+	var values []mcmodel.AttributeValue
+	err := l.db.Select("id", "attribute_id").
+		Where("attribute_id in (?)", query.Session(&gorm.Session{}).Model(&mcmodel.Attribute{}).Select("id")).
+		Find(&values).Error
+	if err != nil {
+		return err
+	}
+
+	valueIDs := make(map[int]bool)
+	for _, value := range values {
+		valueIDs[value.ID] = true
+	}
+
+	var lostValues []int
+	for _, attr := range current {
+		for _, value := range attr.AttributeValues {
+			if !valueIDs[value.ID] {
+				lostValues = append(lostValues, attr.ID)
+				break
+			}
+		}
+	}
+
+	condition := "updated_at >= ? or id in (select attribute_id from attribute_values where updated_at >= ?)"
+	args := []interface{}{since, since}
+	if len(lostValues) != 0 {
+		condition += " or id in ?"
+		args = append(args, lostValues)
+	}
+
+	err = query.Session(&gorm.Session{}).Preload("AttributeValues").Where(condition, args...).Find(changed).Error
+	if err != nil {
+		return err
+	}
+
+	return query.Session(&gorm.Session{}).Model(&mcmodel.Attribute{}).Order("id").Pluck("id", ids).Error
+}
+
+// databaseTimeLayout is the layout of a time the database returns as text, as SQLite does and MySQL does
+// without parseTime.
+const databaseTimeLayout = "2006-01-02 15:04:05"
+
+// now returns the database's current time.
+func (l *GormLoader) now() (time.Time, error) {
+	var now interface{}
+	if err := l.db.Raw("select current_timestamp").Row().Scan(&now); err != nil {
+		return time.Time{}, err
+	}
+
+	switch t := now.(type) {
+	case time.Time:
+		return t, nil
+	case []byte:
+		return time.Parse(databaseTimeLayout, string(t))
+	case string:
+		return time.Parse(databaseTimeLayout, t)
+	default:
+		return time.Time{}, fmt.Errorf("unexpected database time %v", now)
+	}
+}
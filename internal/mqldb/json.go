@@ -6,6 +6,10 @@ func MapToStatement(m map[string]interface{}) Statement {
 	//fmt.Printf("MapToStatement = %+v\n", m)
 	_, hasAnd := m["and"]
 	_, hasOr := m["or"]
+	_, hasNot := m["not"]
+	_, hasSameState := m["same_state"]
+	_, hasSameSample := m["same_sample"]
+	_, hasLineage := m["lineage"]
 	_, hasFieldName := m["field_name"]
 	switch {
 	case hasAnd:
@@ -36,16 +40,67 @@ func MapToStatement(m map[string]interface{}) Statement {
 
 		return orStatement
 
+	case hasNot:
+		notStatement := NotStatement{}
+		statement, hasStatement := m["statement"]
+		if hasStatement {
+			notStatement.Statement = MapToStatement(statement.(map[string]interface{}))
+		}
+
+		return notStatement
+
+	case hasSameState:
+		sameStateStatement := SameStateStatement{}
+		statement, hasStatement := m["statement"]
+		if hasStatement {
+			sameStateStatement.Statement = MapToStatement(statement.(map[string]interface{}))
+		}
+
+		return sameStateStatement
+
+	case hasSameSample:
+		sameSampleStatement := SameSampleStatement{}
+		statement, hasStatement := m["statement"]
+		if hasStatement {
+			sameSampleStatement.Statement = MapToStatement(statement.(map[string]interface{}))
+		}
+
+		return sameSampleStatement
+
+	case hasLineage:
+		lineageStatement := LineageStatement{}
+		lineageStatement.Direction, _ = m["lineage"].(string)
+		if maxSteps, ok := m["max_steps"].(float64); ok {
+			lineageStatement.MaxSteps = int(maxSteps)
+		}
+
+		statement, hasStatement := m["statement"]
+		if hasStatement {
+			lineageStatement.Statement = MapToStatement(statement.(map[string]interface{}))
+		}
+
+		return lineageStatement
+
 	case hasFieldName:
 		fieldName, ok := m["field_name"].(string)
 		if !ok {
 			fieldName = ""
 		}
+		quantifier, ok := m["quantifier"].(string)
+		if !ok {
+			quantifier = ""
+		}
+		unit, ok := m["unit"].(string)
+		if !ok {
+			unit = ""
+		}
 		return MatchStatement{
-			FieldType: int(m["field_type"].(float64)),
-			FieldName: fieldName,
-			Operation: m["operation"].(string),
-			Value:     m["value"],
+			FieldType:  int(m["field_type"].(float64)),
+			FieldName:  fieldName,
+			Operation:  m["operation"].(string),
+			Value:      m["value"],
+			Quantifier: quantifier,
+			Unit:       unit,
 		}
 	}
 
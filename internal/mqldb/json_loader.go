@@ -0,0 +1,44 @@
+package mqldb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// JSONLoader loads a project from a JSON encoded ProjectDump file, so that queries can be run without
+// access to the Materials Commons database.
+type JSONLoader struct {
+	path string
+}
+
+func NewJSONLoader(path string) *JSONLoader {
+	return &JSONLoader{path: path}
+}
+
+func (l *JSONLoader) Load(db *DB) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var dump ProjectDump
+	if err := json.NewDecoder(f).Decode(&dump); err != nil {
+		return fmt.Errorf("failed reading project dump %s: %s", l.path, err)
+	}
+
+	if dump.ProjectID != db.ProjectID {
+		return fmt.Errorf("project dump %s is for project %d, not %d", l.path, dump.ProjectID, db.ProjectID)
+	}
+
+	db.loadDump(&dump)
+
+	return nil
+}
+
+// WriteJSONDump writes the project in db in the format JSONLoader reads.
+func WriteJSONDump(db *DB, w io.Writer) error {
+	return json.NewEncoder(w).Encode(db.Dump())
+}
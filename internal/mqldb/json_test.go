@@ -0,0 +1,34 @@
+package mqldb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMapToStatement(t *testing.T) {
+	payload := `{
+		"and": 1,
+		"left": {"field_type": 2, "field_name": "name", "operation": "=", "value": "S1"},
+		"right": {
+			"not": 1,
+			"statement": {"field_type": 6, "operation": "has-process", "value": "Heat Treatment", "field_name": ""}
+		}
+	}`
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &m); err != nil {
+		t.Fatalf("Unable to unmarshal payload: %s", err)
+	}
+
+	expected := AndStatement{
+		Left: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "S1"},
+		Right: NotStatement{
+			Statement: MatchStatement{FieldType: SampleFuncType, Operation: "has-process", Value: "Heat Treatment"},
+		},
+	}
+
+	if statement := MapToStatement(m); !reflect.DeepEqual(statement, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, statement)
+	}
+}
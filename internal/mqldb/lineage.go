@@ -0,0 +1,218 @@
+package mqldb
+
+import (
+	"fmt"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// The lineage of a process or sample is traced through DB.ProcessSamples and DB.SampleProcesses, the
+// graph of which samples went through which processes. A sample is taken to have gone through its processes
+// in the order they were created in, see processHistory.
+//
+// A sample is taken to have been produced by the first process it went through, and to have been given to
+// the others.
+//
+// Going downstream from a process leads to its samples, and from each of those samples to the processes
+// it went through afterwards. Going upstream leads to the samples the process was given, and from them to
+// the processes they went through before, so the samples a process produced alongside each other aren't
+// upstream of one another. Starting from a sample, rather than a process, going upstream leads to the
+// process that produced it and going downstream to the processes it went through after that. For example
+// if S1 went through Casting and then Sectioning, which produced S2 and S3, then Sectioning, S2 and S3 are
+// downstream of Casting, while Sectioning, S1 and Casting are upstream of S2.
+
+// lineage is the processes and samples a LineageStatement reaches, mapped to the number of steps it took
+// to reach them.
+type lineage struct {
+	traced    bool
+	processes map[int]int
+	samples   map[int]int
+}
+
+func validateLineageStatement(statement LineageStatement) error {
+	switch {
+	case statement.Direction != LineageUpstream && statement.Direction != LineageDownstream:
+		return fmt.Errorf("unknown lineage direction '%s', expected upstream or downstream", statement.Direction)
+	case statement.Statement == nil:
+		return fmt.Errorf("%s needs a condition to start from", statement.Direction)
+	case statement.MaxSteps < 0:
+		return fmt.Errorf("%s can't be limited to a negative number of steps, got %d", statement.Direction, statement.MaxSteps)
+	}
+
+	return nil
+}
+
+// evalLineageStatement checks whether the process, sample or both being evaluated are in the lineage. The
+// lineage is traced the first time the statement is evaluated and kept for the rest of the query. A
+// statement that wasn't prepared has no place to keep it, so it is traced every time. The match statements of
+// the condition the lineage starts from are recorded in trace, along with the number of steps it took to reach
+// the process, or if there isn't one the sample.
+func evalLineageStatement(db *DB, process *mcmodel.Activity, sampleState *SampleState, statement LineageStatement, trace *matchTrace) bool {
+	reached := statement.reached
+	if reached == nil {
+		reached = &lineage{}
+	}
+
+	if !reached.traced {
+		traceLineage(db, statement, reached)
+	}
+
+	if process == nil && sampleState == nil {
+		return false
+	}
+
+	if process != nil {
+		if _, ok := reached.processes[process.ID]; !ok {
+			return false
+		}
+	}
+
+	if sampleState != nil {
+		if _, ok := reached.samples[sampleState.sample.ID]; !ok {
+			return false
+		}
+	}
+
+	var steps int
+	switch {
+	case process != nil:
+		steps = reached.processes[process.ID]
+	case sampleState != nil:
+		steps = reached.samples[sampleState.sample.ID]
+	}
+
+	trace.addLeaves(statement.Statement, func(leaf *LeafMatch) {
+		leaf.Lineage = statement.Direction
+		leaf.Steps = steps
+	})
+
+	return true
+}
+
+// lineageNode is a process or sample on the way through a lineage. bound is the position in the sample's
+// process history of the process it was reached from, the processes the sample went through after
+// (downstream) or before (upstream) it are the next step.
+type lineageNode struct {
+	process *mcmodel.Activity
+	sample  *mcmodel.Entity
+	bound   int
+	steps   int
+}
+
+// traceLineage finds the processes and samples reachable from the ones matching the statement's condition.
+// When the condition refers to processes the lineage starts from the matching processes, otherwise from the
+// matching samples. The processes or samples it starts from are left out of the lineage. The graph is walked
+// breadth first so that each process and sample is recorded with the fewest steps it takes to reach it.
+func traceLineage(db *DB, statement LineageStatement, reached *lineage) {
+	reached.traced = true
+	reached.processes = make(map[int]int)
+	reached.samples = make(map[int]int)
+
+	downstream := statement.Direction == LineageDownstream
+
+	var queue []lineageNode
+	startProcesses := make(map[int]bool)
+	startSamples := make(map[int]bool)
+	if hasProcessMatchStatement(statement.Statement) {
+		processes := evalMatchingProcesses(db, statement.Statement, nil)
+		for i := range processes {
+			startProcesses[processes[i].ID] = true
+			queue = append(queue, lineageNode{process: &processes[i]})
+		}
+	} else {
+		// A sample starts from the process that produced it, which is the only process upstream of it, and
+		// everything after it is downstream.
+		samples := evalMatchingSamples(db, statement.Statement, nil)
+		for i := range samples {
+			startSamples[samples[i].ID] = true
+			if producingProcess(db, samples[i].ID) == nil {
+				continue
+			}
+
+			bound := 0
+			if !downstream {
+				bound = 1
+			}
+			queue = append(queue, lineageNode{sample: &samples[i], bound: bound})
+		}
+	}
+
+	expandedProcesses := make(map[int]bool)
+	sampleBounds := make(map[int]int)
+	for len(queue) != 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		if statement.MaxSteps != 0 && node.steps >= statement.MaxSteps {
+			continue
+		}
+
+		if node.process != nil {
+			if expandedProcesses[node.process.ID] {
+				continue
+			}
+			expandedProcesses[node.process.ID] = true
+
+			for _, sample := range db.ProcessSamples[node.process.ID] {
+				bound := historyPosition(processHistory(db, db.SampleProcesses[sample.ID]), node.process)
+				if !downstream && bound == 0 {
+					// The process produced the sample
+					continue
+				}
+
+				if !startSamples[sample.ID] {
+					recordLineageStep(reached.samples, sample.ID, node.steps+1)
+				}
+				queue = append(queue, lineageNode{sample: sample, bound: bound, steps: node.steps + 1})
+			}
+			continue
+		}
+
+		// A sample is only followed again when it was reached from a process that lets more of its
+		// processes through, for example an earlier process when going downstream.
+		if bound, ok := sampleBounds[node.sample.ID]; ok && (downstream && bound <= node.bound || !downstream && bound >= node.bound) {
+			continue
+		}
+		sampleBounds[node.sample.ID] = node.bound
+
+		for i, process := range processHistory(db, db.SampleProcesses[node.sample.ID]) {
+			if downstream && i <= node.bound || !downstream && i >= node.bound {
+				continue
+			}
+
+			if !startProcesses[process.ID] {
+				recordLineageStep(reached.processes, process.ID, node.steps+1)
+			}
+			queue = append(queue, lineageNode{process: process, steps: node.steps + 1})
+		}
+	}
+}
+
+// producingProcess returns the process that produced a sample, the first one it went through, or nil if
+// it didn't go through any.
+func producingProcess(db *DB, sampleID int) *mcmodel.Activity {
+	history := processHistory(db, db.SampleProcesses[sampleID])
+	if len(history) == 0 {
+		return nil
+	}
+
+	return history[0]
+}
+
+// historyPosition returns the position of the process in a sample's process history.
+func historyPosition(history []*mcmodel.Activity, process *mcmodel.Activity) int {
+	for i, p := range history {
+		if p.ID == process.ID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// recordLineageStep records the steps it took to reach a process or sample, unless it was already reached.
+func recordLineageStep(steps map[int]int, id int, step int) {
+	if _, ok := steps[id]; !ok {
+		steps[id] = step
+	}
+}
@@ -0,0 +1,247 @@
+package mqldb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+func TestLineageDownstream(t *testing.T) {
+	db := createLineageTestDB()
+	isCasting := MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "=", Value: "Casting"}
+
+	tests := []struct {
+		name              string
+		statement         Statement
+		expectedProcesses []int
+		expectedSamples   []int
+	}{
+		{
+			name:              "downstream of Casting",
+			statement:         LineageStatement{Direction: LineageDownstream, Statement: isCasting},
+			expectedProcesses: []int{2, 3, 4},
+			expectedSamples:   []int{1, 2, 3, 4},
+		},
+		{
+			name:              "downstream of Casting within 2 steps",
+			statement:         LineageStatement{Direction: LineageDownstream, Statement: isCasting, MaxSteps: 2},
+			expectedProcesses: []int{2},
+			expectedSamples:   []int{1, 4},
+		},
+		{
+			name:              "not downstream of Casting",
+			statement:         NotStatement{Statement: LineageStatement{Direction: LineageDownstream, Statement: isCasting}},
+			expectedProcesses: []int{1, 5},
+			expectedSamples:   []int{},
+		},
+		{
+			name: "downstream of sample A",
+			statement: LineageStatement{
+				Direction: LineageDownstream,
+				Statement: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "A"},
+			},
+			expectedProcesses: []int{3, 4},
+			expectedSamples:   []int{},
+		},
+		{
+			name: "downstream of Casting and named A",
+			statement: AndStatement{
+				Left:  LineageStatement{Direction: LineageDownstream, Statement: isCasting},
+				Right: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "A"},
+			},
+			expectedProcesses: []int{2, 3, 4},
+			expectedSamples:   []int{2},
+		},
+	}
+
+	selection := Selection{ProcessSelection: ProcessSelection{All: true}, SampleSelection: SampleSelection{All: true}}
+	for _, test := range tests {
+		processes, samples := EvalStatement(db, selection, test.statement)
+		if !reflect.DeepEqual(processIDs(processes), test.expectedProcesses) {
+			t.Errorf("For %s expected processes %v, got %v", test.name, test.expectedProcesses, processIDs(processes))
+		}
+
+		if !reflect.DeepEqual(sampleIDs(samples), test.expectedSamples) {
+			t.Errorf("For %s expected samples %v, got %v", test.name, test.expectedSamples, sampleIDs(samples))
+		}
+	}
+}
+
+func TestLineageUpstream(t *testing.T) {
+	db := createLineageTestDB()
+
+	tests := []struct {
+		name              string
+		statement         Statement
+		expectedProcesses []int
+		expectedSamples   []int
+	}{
+		{
+			name: "upstream of sample A",
+			statement: LineageStatement{
+				Direction: LineageUpstream,
+				Statement: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "A"},
+			},
+			expectedProcesses: []int{1, 2},
+			expectedSamples:   []int{1},
+		},
+		{
+			name: "upstream of sample A within 1 step",
+			statement: LineageStatement{
+				Direction: LineageUpstream,
+				Statement: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "A"},
+				MaxSteps:  1,
+			},
+			expectedProcesses: []int{2},
+			expectedSamples:   []int{},
+		},
+		{
+			name: "upstream of Heat Treatment",
+			statement: LineageStatement{
+				Direction: LineageUpstream,
+				Statement: MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "=", Value: "Heat Treatment"},
+			},
+			expectedProcesses: []int{1, 2},
+			expectedSamples:   []int{1, 2},
+		},
+	}
+
+	selection := Selection{ProcessSelection: ProcessSelection{All: true}, SampleSelection: SampleSelection{All: true}}
+	for _, test := range tests {
+		processes, samples := EvalStatement(db, selection, test.statement)
+		if !reflect.DeepEqual(processIDs(processes), test.expectedProcesses) {
+			t.Errorf("For %s expected processes %v, got %v", test.name, test.expectedProcesses, processIDs(processes))
+		}
+
+		if !reflect.DeepEqual(sampleIDs(samples), test.expectedSamples) {
+			t.Errorf("For %s expected samples %v, got %v", test.name, test.expectedSamples, sampleIDs(samples))
+		}
+	}
+}
+
+func TestLineageOrdersByCreatedAt(t *testing.T) {
+	db := createLineageTestDB()
+
+	// Heat Treatment was recorded after Tensile, so A went through Tensile first despite its higher ID.
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	db.ProcessCreatedAt[1] = start
+	db.ProcessCreatedAt[2] = start
+	db.ProcessCreatedAt[3] = start.Add(2 * time.Hour)
+	db.ProcessCreatedAt[4] = start.Add(time.Hour)
+
+	tests := []struct {
+		name              string
+		statement         Statement
+		expectedProcesses []int
+		expectedSamples   []int
+	}{
+		{
+			name: "downstream of Heat Treatment",
+			statement: LineageStatement{
+				Direction: LineageDownstream,
+				Statement: MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "=", Value: "Heat Treatment"},
+			},
+			expectedProcesses: []int{},
+			expectedSamples:   []int{2},
+		},
+		{
+			name: "upstream of Tensile",
+			statement: LineageStatement{
+				Direction: LineageUpstream,
+				Statement: MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "=", Value: "Tensile"},
+			},
+			expectedProcesses: []int{1, 2},
+			expectedSamples:   []int{1, 2},
+		},
+		{
+			name: "downstream of sample A",
+			statement: LineageStatement{
+				Direction: LineageDownstream,
+				Statement: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "A"},
+			},
+			expectedProcesses: []int{3, 4},
+			expectedSamples:   []int{},
+		},
+	}
+
+	selection := Selection{ProcessSelection: ProcessSelection{All: true}, SampleSelection: SampleSelection{All: true}}
+	for _, test := range tests {
+		processes, samples := EvalStatement(db, selection, test.statement)
+		if !reflect.DeepEqual(processIDs(processes), test.expectedProcesses) {
+			t.Errorf("For %s expected processes %v, got %v", test.name, test.expectedProcesses, processIDs(processes))
+		}
+
+		if !reflect.DeepEqual(sampleIDs(samples), test.expectedSamples) {
+			t.Errorf("For %s expected samples %v, got %v", test.name, test.expectedSamples, sampleIDs(samples))
+		}
+	}
+}
+
+func TestValidateLineageStatement(t *testing.T) {
+	isCasting := MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "=", Value: "Casting"}
+
+	tests := []LineageStatement{
+		{Direction: "sideways", Statement: isCasting},
+		{Direction: LineageUpstream},
+		{Direction: LineageDownstream, Statement: isCasting, MaxSteps: -1},
+	}
+
+	for _, test := range tests {
+		if err := ValidateStatement(test); err == nil {
+			t.Errorf("Expected an error for %+v", test)
+		}
+	}
+}
+
+// createLineageTestDB creates a project where an Ingot is cast and then sectioned into A and B. A is then
+// heat treated and tensile tested. C is cast separately and never processed further.
+//
+//	Casting(1) -> Ingot(1) -> Sectioning(2) -> A(2) -> Heat Treatment(3), Tensile(4)
+//	                                        -> B(3)
+//	Casting(5) -> C(4)
+func createLineageTestDB() *DB {
+	db := NewDB(1, nil)
+
+	db.Processes = []mcmodel.Activity{
+		{ID: 1, Name: "Casting"},
+		{ID: 2, Name: "Sectioning"},
+		{ID: 3, Name: "Heat Treatment"},
+		{ID: 4, Name: "Tensile"},
+		{ID: 5, Name: "Casting"},
+	}
+
+	db.Samples = []mcmodel.Entity{
+		{ID: 1, Name: "Ingot", EntityStates: []mcmodel.EntityState{{ID: 1}}},
+		{ID: 2, Name: "A", EntityStates: []mcmodel.EntityState{{ID: 2}}},
+		{ID: 3, Name: "B", EntityStates: []mcmodel.EntityState{{ID: 3}}},
+		{ID: 4, Name: "C", EntityStates: []mcmodel.EntityState{{ID: 4}}},
+	}
+
+	processSamples := map[int][]int{
+		1: {1},
+		2: {1, 2, 3},
+		3: {2},
+		4: {2},
+		5: {4},
+	}
+
+	for processID, ids := range processSamples {
+		process := &db.Processes[processID-1]
+		db.ProcessAttributesByProcessID[processID] = map[string]*mcmodel.Attribute{}
+		for _, sampleID := range ids {
+			sample := &db.Samples[sampleID-1]
+			db.ProcessSamples[processID] = append(db.ProcessSamples[processID], sample)
+			db.SampleProcesses[sampleID] = append(db.SampleProcesses[sampleID], process)
+		}
+	}
+
+	for _, sample := range db.Samples {
+		db.SampleAttributesBySampleIDAndStates[sample.ID] = map[int]map[string]*mcmodel.Attribute{
+			sample.EntityStates[0].ID: {},
+		}
+	}
+
+	return db
+}
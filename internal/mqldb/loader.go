@@ -1,5 +1,86 @@
 package mqldb
 
-func LoadProject(projectID int) {
+import (
+	"time"
 
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// Loader reads a project's processes, samples, attributes and the mappings between them into a DB. The
+// project to read is the DB's ProjectID. GormLoader reads from the Materials Commons database, or from a
+// SQLite copy of it opened with the sqliteloader package, and JSONLoader reads from a ProjectDump file.
+type Loader interface {
+	Load(db *DB) error
+}
+
+// LoadProject creates a DB for the project and loads it using loader.
+func LoadProject(projectID int, loader Loader) (*DB, error) {
+	db := NewDBWithLoader(projectID, loader)
+	if err := db.Load(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// ProjectDump is the rows of a project that a Loader reads, before they are organized into the DB's lookups.
+// It is also the format of the JSON files JSONLoader reads, which WriteJSONDump creates. The attributes are
+// those of the processes and sample states, AttributableID being the process or sample state ID. Attribute
+// values are read from their JSON encoded Val. ProcessCreatedAt has when each process was created, which
+// mcmodel.Activity doesn't include.
+type ProjectDump struct {
+	ProjectID         int                  `json:"project_id"`
+	ProjectUpdatedAt  time.Time            `json:"project_updated_at"`
+	LoadedAt          time.Time            `json:"loaded_at"`
+	Processes         []mcmodel.Activity   `json:"processes"`
+	ProcessCreatedAt  []ProcessCreatedAt   `json:"process_created_at"`
+	Samples           []mcmodel.Entity     `json:"samples"`
+	ProcessAttributes []*mcmodel.Attribute `json:"process_attributes"`
+	SampleAttributes  []*mcmodel.Attribute `json:"sample_attributes"`
+	ProcessSamples    []Activity2Entity    `json:"process_samples"`
+}
+
+// ProcessCreatedAt is when a process was created.
+type ProcessCreatedAt struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Dump returns the rows of the DB's project. Loading the dump into a new DB recreates this one.
+func (db *DB) Dump() *ProjectDump {
+	dump := &ProjectDump{
+		ProjectID:         db.ProjectID,
+		ProjectUpdatedAt:  db.ProjectUpdatedAt,
+		LoadedAt:          db.LoadedAt,
+		ProcessAttributes: db.AllProcessAttributes,
+		SampleAttributes:  db.AllSampleAttributes,
+	}
+
+	// The attributes are already in ProcessAttributes and SampleAttributes, so they are left out of the
+	// processes and sample states.
+	for _, process := range db.Processes {
+		process.Attributes = nil
+		dump.Processes = append(dump.Processes, process)
+		if createdAt, ok := db.ProcessCreatedAt[process.ID]; ok {
+			dump.ProcessCreatedAt = append(dump.ProcessCreatedAt, ProcessCreatedAt{ID: process.ID, CreatedAt: createdAt})
+		}
+	}
+
+	for _, sample := range db.Samples {
+		states := make([]mcmodel.EntityState, 0, len(sample.EntityStates))
+		for _, state := range sample.EntityStates {
+			state.Attributes = nil
+			states = append(states, state)
+		}
+		sample.EntityStates = states
+		dump.Samples = append(dump.Samples, sample)
+	}
+
+	for _, process := range db.Processes {
+		for _, sample := range db.ProcessSamples[process.ID] {
+			dump.ProcessSamples = append(dump.ProcessSamples, Activity2Entity{ActivityID: process.ID, EntityID: sample.ID})
+		}
+	}
+
+	return dump
 }
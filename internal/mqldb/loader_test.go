@@ -0,0 +1,224 @@
+package mqldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestJSONLoader(t *testing.T) {
+	path := writeTestDump(t, createTestDump())
+
+	db, err := LoadProject(1, NewJSONLoader(path))
+	if err != nil {
+		t.Fatalf("Failed loading dump: %s", err)
+	}
+
+	checkLoadedTestProject(t, db)
+
+	if _, err := LoadProject(2, NewJSONLoader(path)); err == nil {
+		t.Fatalf("Expected an error loading the dump for project 1 as project 2")
+	}
+
+	if _, err := LoadProject(1, NewJSONLoader(filepath.Join(t.TempDir(), "missing.json"))); err == nil {
+		t.Fatalf("Expected an error loading a missing dump")
+	}
+}
+
+func TestWriteJSONDump(t *testing.T) {
+	db, err := LoadProject(1, NewJSONLoader(writeTestDump(t, createTestDump())))
+	if err != nil {
+		t.Fatalf("Failed loading dump: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJSONDump(db, &buf); err != nil {
+		t.Fatalf("Failed writing dump: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "rewritten.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed writing dump file: %s", err)
+	}
+
+	reloaded, err := LoadProject(1, NewJSONLoader(path))
+	if err != nil {
+		t.Fatalf("Failed loading rewritten dump: %s", err)
+	}
+
+	checkLoadedTestProject(t, reloaded)
+}
+
+func TestSQLiteLoader(t *testing.T) {
+	loader := createSQLiteTestLoader(t)
+
+	db, err := LoadProject(1, loader)
+	if err != nil {
+		t.Fatalf("Failed loading project: %s", err)
+	}
+
+	if len(db.Processes) != 2 || len(db.Samples) != 2 {
+		t.Fatalf("Expected 2 processes and 2 samples, got %+v and %+v", db.Processes, db.Samples)
+	}
+
+	if len(db.Samples[0].EntityStates) != 2 {
+		t.Fatalf("Expected S1 to have 2 states, got %+v", db.Samples[0].EntityStates)
+	}
+
+	temperature := db.ProcessAttributesByProcessID[1]["temperature"]
+	if temperature == nil || temperature.AttributeValues[0].ValueFloat != 500 || temperature.AttributeValues[0].Unit != "C" {
+		t.Fatalf("Expected Heat Treatment to have a temperature of 500 C, got %+v", temperature)
+	}
+
+	// S2's hardness is in its only state and below 5, and the sample in the other project isn't loaded.
+	hard := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "hardness", Operation: ">", Value: 5}
+	_, samples := EvalStatement(db, selectAllSamples(), hard)
+	if !reflect.DeepEqual(sampleIDs(samples), []int{1}) {
+		t.Fatalf("Expected sample 1 to match, got %v", sampleIDs(samples))
+	}
+
+	if processes := db.SampleProcesses[1]; len(processes) != 2 {
+		t.Fatalf("Expected S1 to have gone through 2 processes, got %+v", processes)
+	}
+
+	if createdAt := db.ProcessCreatedAt[2]; !createdAt.Equal(time.Date(2021, 6, 1, 11, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected SEM to have been created at 11:00, got %s", createdAt)
+	}
+
+	if _, err := LoadProject(99, loader); err == nil {
+		t.Fatalf("Expected an error loading a project that doesn't exist")
+	}
+}
+
+func TestLoadProgress(t *testing.T) {
+	var progress LoadProgress
+	db := NewDBWithLoader(1, createSQLiteTestLoader(t))
+	db.TrackProgress(&progress)
+
+	if err := db.Load(); err != nil {
+		t.Fatalf("Failed loading project: %s", err)
+	}
+
+	stage, counts := progress.Status()
+	expected := LoadCounts{Processes: 2, Samples: 2, ProcessAttributes: 1, SampleAttributes: 2}
+	if stage != LoadStageBuilding || counts != expected {
+		t.Fatalf("Expected stage %s with %+v, got %s with %+v", LoadStageBuilding, expected, stage, counts)
+	}
+}
+
+// createSQLiteTestLoader creates a SQLite database with the tables of the Materials Commons schema that are
+// loaded. Project 1 has a Heat Treatment process that S1 went through, and SEM that S1 and S2 went through.
+// S1 has two states and its second has a hardness, as does S2. Project 2 has a process and sample that
+// shouldn't be loaded with project 1.
+func createSQLiteTestLoader(t *testing.T) *GormLoader {
+	t.Helper()
+
+	// The sqliteloader package can't be used here as it imports mqldb.
+	sqliteDB, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "mc.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed opening SQLite database: %s", err)
+	}
+	loader := NewGormLoader(sqliteDB)
+
+	statements := []string{
+		"create table projects (id integer primary key, name text, updated_at datetime)",
+		"create table activities (id integer primary key, name text, project_id integer, created_at datetime, updated_at datetime)",
+		"create table entities (id integer primary key, name text, project_id integer, updated_at datetime)",
+		"create table entity_states (id integer primary key, entity_id integer)",
+		"create table attributes (id integer primary key, uuid text, name text, attributable_id integer, attributable_type text, updated_at datetime)",
+		"create table attribute_values (id integer primary key, uuid text, attribute_id integer, unit text, val text, updated_at datetime)",
+		"create table activity2entity (id integer primary key, activity_id integer, entity_id integer)",
+		"insert into projects (id, name) values (1, 'Alloys'), (2, 'Other')",
+		`insert into activities (id, name, project_id, created_at) values
+			(1, 'Heat Treatment', 1, '2021-06-01 10:00:00'),
+			(2, 'SEM', 1, '2021-06-01 11:00:00'),
+			(3, 'Other', 2, '2021-06-01 12:00:00')`,
+		"insert into entities (id, name, project_id) values (1, 'S1', 1), (2, 'S2', 1), (3, 'Other', 2)",
+		"insert into entity_states (id, entity_id) values (1, 1), (2, 1), (3, 2), (4, 3)",
+		`insert into attributes (id, name, attributable_id, attributable_type) values
+			(1, 'temperature', 1, 'App\Models\Activity'),
+			(2, 'hardness', 2, 'App\Models\EntityState'),
+			(3, 'hardness', 3, 'App\Models\EntityState'),
+			(4, 'hardness', 4, 'App\Models\EntityState')`,
+		`insert into attribute_values (id, attribute_id, unit, val) values
+			(1, 1, 'C', '{"value": 500}'),
+			(2, 2, '', '{"value": 7.5}'),
+			(3, 3, '', '{"value": 4}'),
+			(4, 4, '', '{"value": 9}')`,
+		"insert into activity2entity (id, activity_id, entity_id) values (1, 1, 1), (2, 2, 1), (3, 2, 2), (4, 3, 3)",
+	}
+
+	for _, statement := range statements {
+		if err := loader.db.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed creating test database with %q: %s", statement, err)
+		}
+	}
+
+	return loader
+}
+
+// createTestDump creates a project with an EBSD process that S1 went through. S1 has a hardness in its
+// second state.
+func createTestDump() ProjectDump {
+	return ProjectDump{
+		ProjectID: 1,
+		Processes: []mcmodel.Activity{{ID: 1, Name: "EBSD"}, {ID: 2, Name: "Texture"}},
+		Samples: []mcmodel.Entity{
+			{ID: 1, Name: "S1", EntityStates: []mcmodel.EntityState{{ID: 1, EntityID: 1}, {ID: 2, EntityID: 1}}},
+			{ID: 2, Name: "S2", EntityStates: []mcmodel.EntityState{{ID: 3, EntityID: 2}}},
+		},
+		ProcessAttributes: []*mcmodel.Attribute{
+			{ID: 1, Name: "Beam Type", AttributableID: 1, AttributeValues: []mcmodel.AttributeValue{{Val: `{"value": "Wide"}`}}},
+		},
+		SampleAttributes: []*mcmodel.Attribute{
+			{ID: 2, Name: "hardness", AttributableID: 2, AttributeValues: []mcmodel.AttributeValue{{Val: `{"value": 10}`}}},
+		},
+		ProcessSamples: []Activity2Entity{{ActivityID: 1, EntityID: 1}, {ActivityID: 2, EntityID: 2}},
+	}
+}
+
+func writeTestDump(t *testing.T, dump ProjectDump) string {
+	t.Helper()
+
+	b, err := json.Marshal(dump)
+	if err != nil {
+		t.Fatalf("Failed encoding dump: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "project.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("Failed writing dump: %s", err)
+	}
+
+	return path
+}
+
+// checkLoadedTestProject checks that the project createTestDump creates was loaded.
+func checkLoadedTestProject(t *testing.T, db *DB) {
+	t.Helper()
+
+	if len(db.Samples[0].EntityStates) != 2 || len(db.Samples[0].EntityStates[1].Attributes) != 1 {
+		t.Fatalf("Expected S1 to have 2 states, the second with the hardness, got %+v", db.Samples[0].EntityStates)
+	}
+
+	hardEBSDSamples := AndStatement{
+		Left:  MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "hardness", Operation: "=", Value: 10},
+		Right: MatchStatement{FieldType: ProcessAttributeFieldType, FieldName: "Beam Type", Operation: "=", Value: "Wide"},
+	}
+	processes, samples := EvalStatement(db, Selection{
+		ProcessSelection: ProcessSelection{All: true},
+		SampleSelection:  SampleSelection{All: true},
+	}, hardEBSDSamples)
+
+	if !reflect.DeepEqual(processIDs(processes), []int{1}) || !reflect.DeepEqual(sampleIDs(samples), []int{1}) {
+		t.Fatalf("Expected process 1 and sample 1 to match, got %v and %v", processIDs(processes), sampleIDs(samples))
+	}
+}
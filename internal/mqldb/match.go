@@ -2,11 +2,183 @@ package mqldb
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/materials-commons/gomcdb/mcmodel"
 )
 
+// evalAttributeMatch evaluates the match against the values of an attribute. An attribute can have multiple
+// values. With the default "any" quantifier the match succeeds when at least one value matches, with "all"
+// every value has to match. Values of a type that can't be matched, for example because they failed to
+// convert when loaded, never match.
+func evalAttributeMatch(attribute *mcmodel.Attribute, match MatchStatement) bool {
+	matched := false
+	for _, value := range attribute.AttributeValues {
+		switch {
+		case !evalAttributeValueMatch(value, match):
+			if match.Quantifier == QuantifierAll {
+				return false
+			}
+		case match.Quantifier == QuantifierAll:
+			matched = true
+		default:
+			return true
+		}
+	}
+
+	return matched
+}
+
+// isNullOperation returns true for the is-null and is-not-null operations.
+func isNullOperation(operation string) bool {
+	return operation == "is-null" || operation == "is-not-null"
+}
+
+// evalAttributeNullMatch evaluates is-null and is-not-null against an attribute. An attribute is null when it
+// is missing (attribute is nil), when it has no values, or when none of its values has a type, which is the
+// case for values that failed to convert when loaded.
+func evalAttributeNullMatch(attribute *mcmodel.Attribute, match MatchStatement) bool {
+	return attributeIsNull(attribute) == (match.Operation == "is-null")
+}
+
+// attributeIsNull returns true when the attribute is missing or has no value with a type.
+func attributeIsNull(attribute *mcmodel.Attribute) bool {
+	if attribute == nil {
+		return true
+	}
+
+	for _, value := range attribute.AttributeValues {
+		if value.ValueType != mcmodel.ValueTypeUnset {
+			return false
+		}
+	}
+
+	return true
+}
+
+// evalFieldNullMatch evaluates is-null and is-not-null against a name or id field. A field is null when it
+// is unset, that is an empty name or an id of 0.
+func evalFieldNullMatch(value interface{}, match MatchStatement) bool {
+	isNull := value == nil || value == "" || value == 0
+	return isNull == (match.Operation == "is-null")
+}
+
+func evalAttributeValueMatch(value mcmodel.AttributeValue, match MatchStatement) bool {
+	if match.Unit != "" {
+		var ok bool
+		if value, ok = convertAttributeValue(value, match.Unit); !ok {
+			return false
+		}
+	}
+
+	switch match.Operation {
+	case "in":
+		return evalInMatch(attributeValueOf(value), match)
+	case "between":
+		return evalBetweenMatch(match, func(m MatchStatement) bool { return evalAttributeValueMatch(value, m) })
+	}
+
+	switch value.ValueType {
+	case mcmodel.ValueTypeInt:
+		return tryEvalAttributeIntMatch(value.ValueInt, match)
+	case mcmodel.ValueTypeFloat:
+		return tryEvalAttributeFloatMatch(value.ValueFloat, match)
+	case mcmodel.ValueTypeString:
+		return tryEvalAttributeStringMatch(value.ValueString, match)
+	default:
+		return false
+	}
+}
+
+// attributeValueOf returns the value of an attribute value that matches its type, or nil if the value has
+// no type that can be matched.
+func attributeValueOf(value mcmodel.AttributeValue) interface{} {
+	switch value.ValueType {
+	case mcmodel.ValueTypeInt:
+		return value.ValueInt
+	case mcmodel.ValueTypeFloat:
+		return value.ValueFloat
+	case mcmodel.ValueTypeString:
+		return value.ValueString
+	default:
+		return nil
+	}
+}
+
+// valueSet holds the values of an in match so that each value can be checked with a single lookup. Numbers
+// are stored as float64 so that an int matches the same float and the other way around.
+type valueSet map[interface{}]bool
+
+func newValueSet(values []interface{}) valueSet {
+	set := make(valueSet, len(values))
+	for _, value := range values {
+		if key, ok := valueSetKey(value); ok {
+			set[key] = true
+		}
+	}
+
+	return set
+}
+
+func (s valueSet) contains(value interface{}) bool {
+	key, ok := valueSetKey(value)
+	return ok && s[key]
+}
+
+func valueSetKey(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		return v, true
+	default:
+		return nil, false
+	}
+}
+
+// evalInMatch checks if value is one of the values of an in match. It uses the set built by prepareStatement,
+// or builds it here if the statement wasn't prepared.
+func evalInMatch(value interface{}, match MatchStatement) bool {
+	set := match.set
+	if set == nil {
+		values, ok := match.Value.([]interface{})
+		if !ok {
+			return false
+		}
+		set = newValueSet(values)
+	}
+
+	return set.contains(value)
+}
+
+// evalBetweenMatch evaluates a between match as >= the lower bound and <= the upper bound, using evalFn
+// to evaluate each of the comparisons against the value being matched.
+func evalBetweenMatch(match MatchStatement, evalFn func(match MatchStatement) bool) bool {
+	bounds, ok := match.Value.([]interface{})
+	if !ok || len(bounds) != 2 {
+		return false
+	}
+
+	lower, upper := match, match
+	lower.Operation, lower.Value = ">=", bounds[0]
+	upper.Operation, upper.Value = "<=", bounds[1]
+
+	return evalFn(lower) && evalFn(upper)
+}
+
 func tryEvalAttributeIntMatch(val1 int64, match MatchStatement) bool {
+	switch match.Value.(type) {
+	case float64, float32:
+		// Compare as floats so that 2 >= 2.5 isn't truncated into 2 >= 2
+		return tryEvalAttributeFloatMatch(float64(val1), match)
+	}
+
 	val2, ok := matchValToInt(match)
 	if !ok {
 		return false
@@ -72,19 +244,47 @@ func tryEvalAttributeStringMatch(val1 string, match MatchStatement) bool {
 	if !ok {
 		return false
 	}
-	return evalStringMatch(val1, val2, match.Operation)
+	return evalStringMatchStatement(val1, val2, match)
+}
+
+// evalStringMatchStatement matches val1 against val2 using the match operation. The pattern operations use the
+// regular expression compiled by prepareStatement, or compile it here if the statement wasn't prepared.
+func evalStringMatchStatement(val1, val2 string, match MatchStatement) bool {
+	if !isPatternOperation(match.Operation) {
+		return evalStringMatch(val1, val2, match.Operation)
+	}
+
+	regex := match.regex
+	if regex == nil {
+		var err error
+		if regex, err = compilePattern(match.Operation, val2); err != nil {
+			return false
+		}
+	}
+
+	return regex.MatchString(val1)
 }
 
 func evalProcessFieldMatch(process *mcmodel.Activity, match MatchStatement) bool {
 	if process == nil {
 		return false
 	}
+
+	switch match.Operation {
+	case "is-null", "is-not-null":
+		return evalFieldNullMatch(fieldValueOf(process.Name, process.ID, match.FieldName), match)
+	case "in":
+		return evalInMatch(fieldValueOf(process.Name, process.ID, match.FieldName), match)
+	case "between":
+		return evalBetweenMatch(match, func(m MatchStatement) bool { return evalProcessFieldMatch(process, m) })
+	}
+
 	if match.FieldName == "name" {
 		name, ok := match.Value.(string)
 		if !ok {
 			return false
 		}
-		return evalStringMatch(name, process.Name, match.Operation)
+		return evalStringMatchStatement(process.Name, name, match)
 	}
 
 	if match.FieldName == "id" {
@@ -92,7 +292,7 @@ func evalProcessFieldMatch(process *mcmodel.Activity, match MatchStatement) bool
 		if !ok {
 			return false
 		}
-		return evalIntMatch(int64(id), int64(process.ID), match.Operation)
+		return evalIntMatch(int64(process.ID), int64(id), match.Operation)
 	}
 
 	return false
@@ -102,12 +302,22 @@ func evalSampleFieldMatch(sampleState *SampleState, match MatchStatement) bool {
 	if sampleState == nil {
 		return false
 	}
+
+	switch match.Operation {
+	case "is-null", "is-not-null":
+		return evalFieldNullMatch(fieldValueOf(sampleState.sample.Name, sampleState.sample.ID, match.FieldName), match)
+	case "in":
+		return evalInMatch(fieldValueOf(sampleState.sample.Name, sampleState.sample.ID, match.FieldName), match)
+	case "between":
+		return evalBetweenMatch(match, func(m MatchStatement) bool { return evalSampleFieldMatch(sampleState, m) })
+	}
+
 	if match.FieldName == "name" {
 		name, ok := match.Value.(string)
 		if !ok {
 			return false
 		}
-		return evalStringMatch(name, sampleState.sample.Name, match.Operation)
+		return evalStringMatchStatement(sampleState.sample.Name, name, match)
 	}
 
 	if match.FieldName == "id" {
@@ -115,18 +325,42 @@ func evalSampleFieldMatch(sampleState *SampleState, match MatchStatement) bool {
 		if !ok {
 			return false
 		}
-		return evalIntMatch(int64(id), int64(sampleState.sample.ID), match.Operation)
+		return evalIntMatch(int64(sampleState.sample.ID), int64(id), match.Operation)
 	}
 
 	return false
 }
 
+// fieldValueOf returns the value of the name or id field of a process or sample.
+func fieldValueOf(name string, id int, fieldName string) interface{} {
+	switch fieldName {
+	case "name":
+		return name
+	case "id":
+		return id
+	default:
+		return nil
+	}
+}
+
 func evalStringMatch(val1, val2, operation string) bool {
 	switch operation {
 	case "=":
 		return val1 == val2
 	case "<>":
 		return val1 != val2
+	case ">":
+		return val1 > val2
+	case ">=":
+		return val1 >= val2
+	case "<":
+		return val1 < val2
+	case "<=":
+		return val1 <= val2
+	case "contains":
+		return strings.Contains(val1, val2)
+	case "starts-with":
+		return strings.HasPrefix(val1, val2)
 	default:
 		return false
 	}
@@ -0,0 +1,175 @@
+package mqldb
+
+import (
+	"sort"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// sortProcesses orders processes by ID and then by the process fields and attributes in orderBy. Sample
+// fields and attributes in orderBy don't apply to processes and are skipped.
+func sortProcesses(db *DB, processes []mcmodel.Activity, orderBy []OrderBy) {
+	sort.Slice(processes, func(i, j int) bool { return processes[i].ID < processes[j].ID })
+	sort.SliceStable(processes, orderLess(orderBy, func(i int, o OrderBy) interface{} {
+		return processOrderValue(db, &processes[i], o)
+	}))
+}
+
+// sortSamples orders samples by ID and then by the sample fields and attributes in orderBy. A sample is
+// ordered by the value of an attribute in the first of its states that has one.
+func sortSamples(db *DB, samples []mcmodel.Entity, orderBy []OrderBy) {
+	sort.Slice(samples, func(i, j int) bool { return samples[i].ID < samples[j].ID })
+	sort.SliceStable(samples, orderLess(orderBy, func(i int, o OrderBy) interface{} {
+		return sampleOrderValue(db, &samples[i], 0, o)
+	}))
+}
+
+// orderLess returns a less function for sort.SliceStable that compares the values valueFn returns for
+// each OrderBy in turn. Missing (nil) values always sort last.
+func orderLess(orderBy []OrderBy, valueFn func(i int, o OrderBy) interface{}) func(i, j int) bool {
+	return func(i, j int) bool {
+		for _, o := range orderBy {
+			vi, vj := valueFn(i, o), valueFn(j, o)
+			switch {
+			case vi == nil && vj == nil:
+				continue
+			case vi == nil:
+				return false
+			case vj == nil:
+				return true
+			}
+
+			c := compareOrderValues(vi, vj)
+			if c == 0 {
+				continue
+			}
+
+			if o.Descending {
+				return c > 0
+			}
+			return c < 0
+		}
+
+		return false
+	}
+}
+
+// compareOrderValues compares two field or attribute values, returning -1, 0 or 1. Numbers are compared
+// numerically and strings lexically. Numbers sort before strings.
+func compareOrderValues(a, b interface{}) int {
+	af, aIsNumber := orderNumber(a)
+	bf, bIsNumber := orderNumber(b)
+	switch {
+	case aIsNumber && bIsNumber:
+		return compareFloats(af, bf)
+	case aIsNumber:
+		return -1
+	case bIsNumber:
+		return 1
+	}
+
+	as, _ := a.(string)
+	bs, _ := b.(string)
+	switch {
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func orderNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// processOrderValue returns the value of the field or attribute to order a process by, or nil if the
+// process doesn't have it.
+func processOrderValue(db *DB, process *mcmodel.Activity, o OrderBy) interface{} {
+	switch o.FieldType {
+	case ProcessFieldType:
+		return fieldValueOf(process.Name, process.ID, o.FieldName)
+	case ProcessAttributeFieldType:
+		return firstAttributeValue(db.ProcessAttributesByProcessID[process.ID][o.FieldName])
+	default:
+		return nil
+	}
+}
+
+// sampleOrderValue returns the value of the field or attribute to order a sample by, or nil if the sample
+// doesn't have it. Attributes are looked up in the given state, or when stateID is 0 in the first state
+// of the sample that has the attribute.
+func sampleOrderValue(db *DB, sample *mcmodel.Entity, stateID int, o OrderBy) interface{} {
+	switch o.FieldType {
+	case SampleFieldType:
+		return fieldValueOf(sample.Name, sample.ID, o.FieldName)
+	case SampleAttributeFieldType:
+		states := db.SampleAttributesBySampleIDAndStates[sample.ID]
+		if stateID != 0 {
+			return firstAttributeValue(states[stateID][o.FieldName])
+		}
+
+		for _, state := range sample.EntityStates {
+			if value := firstAttributeValue(states[state.ID][o.FieldName]); value != nil {
+				return value
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// firstAttributeValue returns the first value of the attribute that has a type, or nil if there isn't one.
+func firstAttributeValue(attribute *mcmodel.Attribute) interface{} {
+	if attribute == nil {
+		return nil
+	}
+
+	for _, value := range attribute.AttributeValues {
+		if v := attributeValueOf(value); v != nil {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// pageBounds returns the start and end index of the page of n results selected by limit and offset.
+func pageBounds(n, limit, offset int) (int, int) {
+	start := offset
+	switch {
+	case start < 0:
+		start = 0
+	case start > n:
+		start = n
+	}
+
+	end := n
+	if limit > 0 && start+limit < n {
+		end = start + limit
+	}
+
+	return start, end
+}
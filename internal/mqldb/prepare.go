@@ -0,0 +1,167 @@
+package mqldb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ValidateStatement checks that a statement can be evaluated, for example that its regular expressions
+// compile. EvalStatement treats a match that fails validation as never matching, so callers that want
+// to report these problems should call ValidateStatement first.
+func ValidateStatement(statement Statement) error {
+	_, err := prepareStatement(statement)
+	return err
+}
+
+// prepareStatement does the work for a statement that only needs to be done once per query rather than
+// once for every process, sample and attribute value the statement is evaluated against. It returns a
+// copy of the statement with that work attached to the match statements. Compiled patterns are cached
+// for the query, so a pattern used in several places is only compiled once.
+func prepareStatement(statement Statement) (Statement, error) {
+	patterns := make(map[string]*regexp.Regexp)
+	return mapMatchStatements(statement, func(match MatchStatement) (MatchStatement, error) {
+		return prepareMatchStatement(match, patterns)
+	})
+}
+
+// prepareMatchStatement compiles the pattern for like, ilike and ~ matches, builds the set of values
+// for in matches, and the sequence of processes for has-sequence matches. It also checks that the unit
+// of the match is known, as no value converts to an unknown unit and the match would never succeed.
+func prepareMatchStatement(match MatchStatement, patterns map[string]*regexp.Regexp) (MatchStatement, error) {
+	if match.Unit != "" {
+		if _, ok := lookupUnit(match.Unit); !ok {
+			return match, fmt.Errorf("unknown unit '%s'", match.Unit)
+		}
+	}
+
+	switch {
+	case isPatternOperation(match.Operation):
+		pattern, ok := match.Value.(string)
+		if !ok {
+			return match, fmt.Errorf("%s requires a string pattern, got %v", match.Operation, match.Value)
+		}
+
+		key := match.Operation + ":" + pattern
+		if regex, ok := patterns[key]; ok {
+			match.regex = regex
+			return match, nil
+		}
+
+		regex, err := compilePattern(match.Operation, pattern)
+		if err != nil {
+			return match, err
+		}
+
+		patterns[key] = regex
+		match.regex = regex
+	case match.Operation == "in":
+		values, ok := match.Value.([]interface{})
+		if !ok {
+			return match, fmt.Errorf("in requires a list of values, got %v", match.Value)
+		}
+		match.set = newValueSet(values)
+	case match.Operation == "has-sequence":
+		sequence, err := newProcessSequence(match.Value)
+		if err != nil {
+			return match, err
+		}
+		match.sequence = sequence
+	case match.Operation == "between":
+		if values, ok := match.Value.([]interface{}); !ok || len(values) != 2 {
+			return match, fmt.Errorf("between requires a lower and upper bound, got %v", match.Value)
+		}
+	}
+
+	return match, nil
+}
+
+// mapMatchStatements rebuilds statement, replacing each MatchStatement with the result of calling fn on it.
+// When fn fails, or a LineageStatement is invalid, the rest of the statement is still rebuilt, and the first
+// error is returned.
+func mapMatchStatements(statement Statement, fn func(match MatchStatement) (MatchStatement, error)) (Statement, error) {
+	var firstErr error
+	var mapFn func(statement Statement) Statement
+	mapFn = func(statement Statement) Statement {
+		switch s := statement.(type) {
+		case MatchStatement:
+			match, err := fn(s)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			return match
+		case AndStatement:
+			s.Left, s.Right = mapFn(s.Left), mapFn(s.Right)
+			return s
+		case OrStatement:
+			s.Left, s.Right = mapFn(s.Left), mapFn(s.Right)
+			return s
+		case NotStatement:
+			s.Statement = mapFn(s.Statement)
+			return s
+		case SameStateStatement:
+			s.Statement = mapFn(s.Statement)
+			return s
+		case SameSampleStatement:
+			s.Statement = mapFn(s.Statement)
+			return s
+		case LineageStatement:
+			if err := validateLineageStatement(s); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			// The rebuilt statement gets its own, empty, lineage so that it is traced again for this query.
+			s.Statement = mapFn(s.Statement)
+			s.reached = &lineage{}
+			return s
+		default:
+			return statement
+		}
+	}
+
+	return mapFn(statement), firstErr
+}
+
+// isPatternOperation returns true for the string operations that are evaluated with a regular expression.
+func isPatternOperation(operation string) bool {
+	switch operation {
+	case "like", "ilike", "~":
+		return true
+	default:
+		return false
+	}
+}
+
+// compilePattern turns the pattern for a like, ilike or ~ operation into a regular expression. For like
+// and ilike the SQL wildcards % (any sequence of characters) and _ (any single character) are supported
+// and the pattern has to match the whole value, wildcards included matching across lines. A ~ pattern
+// is a regular expression that can match anywhere in the value.
+func compilePattern(operation, pattern string) (*regexp.Regexp, error) {
+	if operation == "~" {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %s", pattern, err)
+		}
+		return regex, nil
+	}
+
+	var expr strings.Builder
+	expr.WriteString("(?s)")
+	if operation == "ilike" {
+		expr.WriteString("(?i)")
+	}
+
+	expr.WriteString("^")
+	for _, ch := range pattern {
+		switch ch {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.Compile(expr.String())
+}
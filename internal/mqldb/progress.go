@@ -0,0 +1,59 @@
+package mqldb
+
+import "sync"
+
+// The stages of loading a project, as reported by LoadProgress.
+const (
+	LoadStageProcesses     = "processes"
+	LoadStageSamples       = "samples"
+	LoadStageProcessSample = "process samples"
+	LoadStageBuilding      = "building"
+)
+
+// LoadCounts is how much of a project has been read so far.
+type LoadCounts struct {
+	Processes         int `json:"processes"`
+	Samples           int `json:"samples"`
+	ProcessAttributes int `json:"process_attributes"`
+	SampleAttributes  int `json:"sample_attributes"`
+}
+
+// LoadProgress tracks the progress of loading a project so that it can be reported while the load runs. It is
+// safe to read from other goroutines while the load updates it. A nil LoadProgress ignores updates, so loading
+// doesn't need to check whether progress is being tracked.
+type LoadProgress struct {
+	mutex  sync.Mutex
+	stage  string
+	counts LoadCounts
+}
+
+// Status returns the stage the load is in and what it has read so far.
+func (p *LoadProgress) Status() (string, LoadCounts) {
+	if p == nil {
+		return "", LoadCounts{}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.stage, p.counts
+}
+
+// update moves the load to stage, and applies updateFn, if given, to the counts.
+func (p *LoadProgress) update(stage string, updateFn func(counts *LoadCounts)) {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stage = stage
+	if updateFn != nil {
+		updateFn(&p.counts)
+	}
+}
+
+// TrackProgress has Load report its progress to progress.
+func (db *DB) TrackProgress(progress *LoadProgress) {
+	db.progress = progress
+}
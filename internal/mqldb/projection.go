@@ -0,0 +1,274 @@
+package mqldb
+
+import (
+	"sort"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// Table is a query result projected onto the fields and attributes listed in a Selection. Each column
+// is named the way it is written in a query, for example "p:name" or "s:a:hardness". A cell holds nil
+// when the process or sample doesn't have the attribute, the value when it has one, and a []interface{}
+// of the values when it has several.
+type Table struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// IsProjection returns true when the selection lists specific fields or attributes, as in
+// select p:[name, a:time], or aggregates, rather than only selecting whole processes and samples.
+func (s Selection) IsProjection() bool {
+	return s.ProcessSelection.hasFields() || s.SampleSelection.hasFields() || s.IsAggregate()
+}
+
+func (s ProcessSelection) hasFields() bool {
+	return s.Name || s.ID || len(s.Attributes) != 0
+}
+
+func (s SampleSelection) hasFields() bool {
+	return s.Name || s.ID || len(s.Attributes) != 0
+}
+
+// EvalProjection runs a query and projects the results into a Table. There is a row for each matching
+// process when only processes are selected, a row for each matching sample state when only samples are
+// selected, and a row for each matching sample state of each matching process when both are selected.
+// A p: or s: selection without fields projects the id, name and every attribute of the results.
+//
+// The rows are ordered by the selection's OrderBy, or by process and sample ID, and then limited to the
+// page given by the selection's Limit and Offset. A selection with aggregates is computed by EvalAggregate.
+func EvalProjection(db *DB, selection Selection, statement Statement) Table {
+	if selection.IsAggregate() {
+		return EvalAggregate(db, selection, statement)
+	}
+
+	statement = prepareQuery(statement)
+	processes, samples := evalStatement(db, selection, statement, nil)
+	return project(db, selection, statement, processes, samples)
+}
+
+// projectedRow is a row of a Table along with the process and sample state it came from, which are
+// used to order the rows.
+type projectedRow struct {
+	cells       []interface{}
+	process     *mcmodel.Activity
+	sampleState *SampleState
+}
+
+// project builds the Table for the processes and samples evalStatement returned for the prepared statement.
+func project(db *DB, selection Selection, statement Statement, processes []mcmodel.Activity, samples []mcmodel.Entity) Table {
+	processColumns := processProjectionColumns(db, selection.ProcessSelection, processes)
+	sampleColumns := sampleProjectionColumns(db, selection.SampleSelection, samples)
+	rows := projectRows(db, selection, statement, processes, samples, processColumns, sampleColumns)
+
+	sort.SliceStable(rows, orderLess(selection.OrderBy, func(i int, o OrderBy) interface{} {
+		return rows[i].orderValue(db, o)
+	}))
+
+	start, end := pageBounds(len(rows), selection.Limit, selection.Offset)
+
+	var table Table
+	table.Columns = append(table.Columns, processColumns.names("p:")...)
+	table.Columns = append(table.Columns, sampleColumns.names("s:")...)
+	table.Rows = [][]interface{}{}
+	for _, row := range rows[start:end] {
+		table.Rows = append(table.Rows, row.cells)
+	}
+
+	return table
+}
+
+// projectRows builds the rows for the processes and samples evalStatement returned for statement. A
+// sample matches when any of its states does, so the statement is evaluated again for each sample state
+// to leave out the states that don't match. When both processes and samples are selected it is evaluated
+// for each process and sample state pair, so that every row satisfies the statement as a whole. For
+// example, with p:name = "Texture" and s:name = "S3" the EBSD processes S3 went through don't get rows.
+// The statement is the one evalStatement was given, already prepared.
+func projectRows(db *DB, selection Selection, statement Statement, processes []mcmodel.Activity, samples []mcmodel.Entity,
+	processColumns, sampleColumns projectionColumns) []projectedRow {
+	var rows []projectedRow
+	switch {
+	case selection.ProcessSelection.All && selection.SampleSelection.All:
+		for i := range processes {
+			process := &processes[i]
+			processSamples := make(map[int]bool)
+			for _, sample := range db.ProcessSamples[process.ID] {
+				processSamples[sample.ID] = true
+			}
+
+			processCells := processColumns.processCells(db, process)
+			for j := range samples {
+				if !processSamples[samples[j].ID] {
+					continue
+				}
+
+				for _, row := range sampleColumns.sampleRows(db, process, &samples[j], statement) {
+					row.cells = append(append([]interface{}{}, processCells...), row.cells...)
+					row.process = process
+					rows = append(rows, row)
+				}
+			}
+		}
+	case selection.ProcessSelection.All:
+		for i := range processes {
+			rows = append(rows, projectedRow{cells: processColumns.processCells(db, &processes[i]), process: &processes[i]})
+		}
+	case selection.SampleSelection.All:
+		for i := range samples {
+			rows = append(rows, sampleColumns.sampleRows(db, nil, &samples[i], statement)...)
+		}
+	}
+
+	return rows
+}
+
+func (r projectedRow) orderValue(db *DB, o OrderBy) interface{} {
+	switch {
+	case (o.FieldType == ProcessFieldType || o.FieldType == ProcessAttributeFieldType) && r.process != nil:
+		return processOrderValue(db, r.process, o)
+	case r.sampleState != nil:
+		return sampleOrderValue(db, r.sampleState.sample, r.sampleState.EntityStateID, o)
+	default:
+		return nil
+	}
+}
+
+// projectionColumns are the fields and attributes projected for a process or sample.
+type projectionColumns struct {
+	id         bool
+	name       bool
+	attributes []string
+}
+
+func (c projectionColumns) names(prefix string) []string {
+	var names []string
+	if c.id {
+		names = append(names, prefix+"id")
+	}
+
+	if c.name {
+		names = append(names, prefix+"name")
+	}
+
+	for _, attribute := range c.attributes {
+		names = append(names, prefix+"a:"+attribute)
+	}
+
+	return names
+}
+
+func processProjectionColumns(db *DB, selection ProcessSelection, processes []mcmodel.Activity) projectionColumns {
+	if !selection.All {
+		return projectionColumns{}
+	}
+
+	if selection.hasFields() {
+		return projectionColumns{id: selection.ID, name: selection.Name, attributes: selection.Attributes}
+	}
+
+	attributeNames := make(map[string]bool)
+	for _, process := range processes {
+		for name := range db.ProcessAttributesByProcessID[process.ID] {
+			attributeNames[name] = true
+		}
+	}
+
+	return projectionColumns{id: true, name: true, attributes: sortedNames(attributeNames)}
+}
+
+func sampleProjectionColumns(db *DB, selection SampleSelection, samples []mcmodel.Entity) projectionColumns {
+	if !selection.All {
+		return projectionColumns{}
+	}
+
+	if selection.hasFields() {
+		return projectionColumns{id: selection.ID, name: selection.Name, attributes: selection.Attributes}
+	}
+
+	attributeNames := make(map[string]bool)
+	for _, sample := range samples {
+		for _, attributes := range db.SampleAttributesBySampleIDAndStates[sample.ID] {
+			for name := range attributes {
+				attributeNames[name] = true
+			}
+		}
+	}
+
+	return projectionColumns{id: true, name: true, attributes: sortedNames(attributeNames)}
+}
+
+func (c projectionColumns) processCells(db *DB, process *mcmodel.Activity) []interface{} {
+	return c.row(process.ID, process.Name, db.ProcessAttributesByProcessID[process.ID])
+}
+
+// sampleRows returns a row for each state of the sample that matches the statement, evaluated along with
+// process when it isn't nil. A sample without any states still gets a row, but its attribute cells are
+// all nil.
+func (c projectionColumns) sampleRows(db *DB, process *mcmodel.Activity, sample *mcmodel.Entity, statement Statement) []projectedRow {
+	if len(sample.EntityStates) == 0 {
+		return []projectedRow{{cells: c.row(sample.ID, sample.Name, nil), sampleState: &SampleState{sample: sample}}}
+	}
+
+	var rows []projectedRow
+	for _, state := range sample.EntityStates {
+		sampleState := &SampleState{sample: sample, EntityStateID: state.ID}
+		if statement != nil && !eval(db, process, sampleState, statement, nil) {
+			continue
+		}
+
+		cells := c.row(sample.ID, sample.Name, db.SampleAttributesBySampleIDAndStates[sample.ID][state.ID])
+		rows = append(rows, projectedRow{cells: cells, sampleState: sampleState})
+	}
+
+	return rows
+}
+
+func (c projectionColumns) row(id int, name string, attributes map[string]*mcmodel.Attribute) []interface{} {
+	var row []interface{}
+	if c.id {
+		row = append(row, id)
+	}
+
+	if c.name {
+		row = append(row, name)
+	}
+
+	for _, attributeName := range c.attributes {
+		row = append(row, projectAttribute(attributes[attributeName]))
+	}
+
+	return row
+}
+
+// projectAttribute returns the cell value for an attribute. Values that failed to convert when loaded
+// are left out.
+func projectAttribute(attribute *mcmodel.Attribute) interface{} {
+	if attribute == nil {
+		return nil
+	}
+
+	var values []interface{}
+	for _, value := range attribute.AttributeValues {
+		if v := attributeValueOf(value); v != nil {
+			values = append(values, v)
+		}
+	}
+
+	switch len(values) {
+	case 0:
+		return nil
+	case 1:
+		return values[0]
+	default:
+		return values
+	}
+}
+
+func sortedNames(names map[string]bool) []string {
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+
+	sort.Strings(sorted)
+	return sorted
+}
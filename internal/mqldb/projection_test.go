@@ -0,0 +1,177 @@
+package mqldb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestProjectProcesses(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		ProcessSelection: ProcessSelection{All: true, Name: true, Attributes: []string{"Beam Type"}},
+	}
+	isEBSD := MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "=", Value: "EBSD"}
+
+	table := EvalProjection(db, selection, isEBSD)
+
+	expectedColumns := []string{"p:name", "p:a:Beam Type"}
+	if !reflect.DeepEqual(table.Columns, expectedColumns) {
+		t.Fatalf("Expected columns %v, got %v", expectedColumns, table.Columns)
+	}
+
+	expectedRows := [][]interface{}{
+		{"EBSD", "Thin"},
+		{"EBSD", "Wide"},
+	}
+	checkRows(t, table, expectedRows)
+}
+
+func TestProjectSampleStates(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		SampleSelection: SampleSelection{All: true, Name: true, Attributes: []string{"grain size", "mg"}},
+	}
+	isS2 := MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "S2"}
+
+	table := EvalProjection(db, selection, isS2)
+
+	// A row for each state. Only the second state has a grain size, and it has two values.
+	expectedRows := [][]interface{}{
+		{"S2", nil, 0.4},
+		{"S2", []interface{}{int64(11), int64(15)}, 0.3},
+	}
+	checkRows(t, table, expectedRows)
+}
+
+func TestProjectOnlyMatchingSampleStates(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		SampleSelection: SampleSelection{All: true, Name: true, Attributes: []string{"grain size"}},
+	}
+	grainSize := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "grain size", Operation: ">", Value: 12}
+
+	table := EvalProjection(db, selection, grainSize)
+	checkRows(t, table, [][]interface{}{{"S2", []interface{}{int64(11), int64(15)}}})
+}
+
+func TestProjectProcessesAndSamples(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		ProcessSelection: ProcessSelection{All: true, ID: true},
+		SampleSelection:  SampleSelection{All: true, Name: true},
+	}
+	statement := AndStatement{
+		Left:  MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "=", Value: "Texture"},
+		Right: MatchStatement{FieldType: SampleFieldType, FieldName: "name", Operation: "=", Value: "S3"},
+	}
+
+	table := EvalProjection(db, selection, statement)
+
+	expectedColumns := []string{"p:id", "s:name"}
+	if !reflect.DeepEqual(table.Columns, expectedColumns) {
+		t.Fatalf("Expected columns %v, got %v", expectedColumns, table.Columns)
+	}
+
+	// The second Texture process joined to each of the two states of S3
+	checkRows(t, table, [][]interface{}{{4, "S3"}, {4, "S3"}})
+}
+
+func TestProjectWithoutFields(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{ProcessSelection: ProcessSelection{All: true}}
+	if selection.IsProjection() {
+		t.Fatalf("Expected a selection without fields to not be a projection")
+	}
+
+	isTexture := MatchStatement{FieldType: ProcessFieldType, FieldName: "name", Operation: "=", Value: "Texture"}
+	table := EvalProjection(db, selection, isTexture)
+
+	expectedColumns := []string{"p:id", "p:name", "p:a:PF scale max", "p:a:note"}
+	if !reflect.DeepEqual(table.Columns, expectedColumns) {
+		t.Fatalf("Expected columns %v, got %v", expectedColumns, table.Columns)
+	}
+
+	checkRows(t, table, [][]interface{}{
+		{3, "Texture", int64(2), "ignore these results"},
+		{4, "Texture", int64(3), nil},
+	})
+}
+
+// checkRows compares the rows of the table to the expected rows without depending on their order.
+func checkRows(t *testing.T, table Table, expected [][]interface{}) {
+	t.Helper()
+
+	if len(table.Rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d: %v", len(expected), len(table.Rows), table.Rows)
+	}
+
+	rows := append([][]interface{}{}, table.Rows...)
+	sort.Slice(rows, func(i, j int) bool { return fmt.Sprint(rows[i]) < fmt.Sprint(rows[j]) })
+	sort.Slice(expected, func(i, j int) bool { return fmt.Sprint(expected[i]) < fmt.Sprint(expected[j]) })
+
+	for i := range rows {
+		if !reflect.DeepEqual(rows[i], expected[i]) {
+			t.Fatalf("Expected rows %v, got %v", expected, rows)
+		}
+	}
+}
+
+func TestProjectOrderByAndLimit(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		SampleSelection: SampleSelection{All: true, Name: true, Attributes: []string{"zn"}},
+		OrderBy:         []OrderBy{{FieldType: SampleAttributeFieldType, FieldName: "zn", Descending: true}},
+		Limit:           3,
+		Offset:          1,
+	}
+
+	table := EvalProjection(db, selection, nil)
+
+	// The zn values by state are 0.5/0.5 (S1), 0.5/0.6 (S2) and 0.68/0.45 (S3). Rows with equal
+	// values stay in sample and state order, so the page ends with the two states of S1.
+	expectedRows := [][]interface{}{
+		{"S2", 0.6},
+		{"S1", 0.5},
+		{"S1", 0.5},
+	}
+	if !reflect.DeepEqual(table.Rows, expectedRows) {
+		t.Fatalf("Expected rows %v, got %v", expectedRows, table.Rows)
+	}
+}
+
+func TestEvalQuery(t *testing.T) {
+	db := createTestDB()
+
+	selection := Selection{
+		SampleSelection: SampleSelection{All: true, Name: true, Attributes: []string{"zn"}},
+		Limit:           1,
+	}
+	highZn := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "zn", Operation: ">", Value: 0.55}
+
+	result := EvalQuery(db, selection, highZn, false)
+	expectedProcesses, expectedSamples := EvalStatement(db, selection, highZn)
+	if !reflect.DeepEqual(result.Processes, expectedProcesses) || !reflect.DeepEqual(result.Samples, expectedSamples) {
+		t.Fatalf("Expected the results of EvalStatement %+v, got %+v", expectedSamples, result.Samples)
+	}
+
+	if expectedTable := EvalProjection(db, selection, highZn); result.Table == nil || !reflect.DeepEqual(*result.Table, expectedTable) {
+		t.Fatalf("Expected the table of EvalProjection %+v, got %+v", expectedTable, result.Table)
+	}
+
+	if result.Explanations != nil {
+		t.Fatalf("Expected no explanations when they weren't asked for, got %+v", result.Explanations)
+	}
+
+	// Without fields or attributes there is no table
+	if result = EvalQuery(db, selectAllSamples(), highZn, false); result.Table != nil {
+		t.Fatalf("Expected no table for a selection without fields, got %+v", result.Table)
+	}
+}
@@ -0,0 +1,171 @@
+package mqldb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// ChangeLoader is a Loader that can also read what changed in a project since a DB was loaded, so that the
+// DB can be refreshed without reading the whole project again.
+type ChangeLoader interface {
+	Loader
+	LoadChanges(db *DB, progress *LoadProgress) (*ProjectChanges, error)
+}
+
+// ProjectChanges is what changed in a project since a DB was loaded. Processes, Samples and the attributes
+// are those that were added or changed. The IDs are of everything currently in the project, anything in the
+// DB that isn't in them was deleted. ProcessCreatedAt, SampleStates and ProcessSamples are complete, as they
+// are small.
+type ProjectChanges struct {
+	ProjectUpdatedAt time.Time
+	LoadedAt         time.Time
+
+	Processes        []mcmodel.Activity
+	ProcessIDs       []int
+	ProcessCreatedAt []ProcessCreatedAt
+
+	// Samples don't have their EntityStates, which are in SampleStates.
+	Samples      []mcmodel.Entity
+	SampleIDs    []int
+	SampleStates []mcmodel.EntityState
+
+	ProcessAttributes   []*mcmodel.Attribute
+	ProcessAttributeIDs []int
+	SampleAttributes    []*mcmodel.Attribute
+	SampleAttributeIDs  []int
+
+	ProcessSamples []Activity2Entity
+}
+
+// RefreshProject returns a DB with the current state of the project in db. When loader is a ChangeLoader only
+// what changed since db was loaded is read, otherwise the whole project is loaded again. Either way db is left
+// as it was, so queries can keep using it until the refreshed DB replaces it. The refresh reports its progress
+// to progress, which can be nil.
+func RefreshProject(db *DB, loader Loader, progress *LoadProgress) (*DB, error) {
+	changeLoader, ok := loader.(ChangeLoader)
+	if !ok {
+		refreshed := NewDBWithLoader(db.ProjectID, loader)
+		refreshed.TrackProgress(progress)
+		if err := refreshed.Load(); err != nil {
+			return nil, err
+		}
+
+		return refreshed, nil
+	}
+
+	changes, err := changeLoader.LoadChanges(db, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := db.applyChanges(changes, progress)
+	refreshed.loader = loader
+	return refreshed, nil
+}
+
+// ApplyChanges returns a new DB with the changes applied to the project in db. Attributes that didn't change
+// are shared with db rather than copied, their values were already converted so they aren't converted again.
+// Neither DB modifies them, so db can still be queried.
+func (db *DB) ApplyChanges(changes *ProjectChanges) *DB {
+	return db.applyChanges(changes, nil)
+}
+
+func (db *DB) applyChanges(changes *ProjectChanges, progress *LoadProgress) *DB {
+	current := db.Dump()
+	dump := &ProjectDump{
+		ProjectID:         db.ProjectID,
+		ProjectUpdatedAt:  changes.ProjectUpdatedAt,
+		LoadedAt:          changes.LoadedAt,
+		Processes:         mergeProcesses(current.Processes, changes.Processes, changes.ProcessIDs),
+		ProcessCreatedAt:  changes.ProcessCreatedAt,
+		Samples:           mergeSamples(current.Samples, changes.Samples, changes.SampleIDs, changes.SampleStates),
+		ProcessAttributes: mergeAttributes(current.ProcessAttributes, changes.ProcessAttributes, changes.ProcessAttributeIDs),
+		SampleAttributes:  mergeAttributes(current.SampleAttributes, changes.SampleAttributes, changes.SampleAttributeIDs),
+		ProcessSamples:    changes.ProcessSamples,
+	}
+
+	refreshed := NewDBWithLoader(db.ProjectID, db.loader)
+	refreshed.TrackProgress(progress)
+	refreshed.loadDump(dump)
+	return refreshed
+}
+
+// mergeProcesses returns the processes with the given ids, taking the changed version of a process over
+// the current one.
+func mergeProcesses(current, changed []mcmodel.Activity, ids []int) []mcmodel.Activity {
+	byID := make(map[int]mcmodel.Activity)
+	for _, process := range current {
+		byID[process.ID] = process
+	}
+
+	for _, process := range changed {
+		byID[process.ID] = process
+	}
+
+	merged := make([]mcmodel.Activity, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		if process, ok := byID[id]; ok {
+			merged = append(merged, process)
+		}
+	}
+
+	return merged
+}
+
+// mergeSamples returns the samples with the given ids, taking the changed version of a sample over the
+// current one. The states of each sample are replaced with those in states.
+func mergeSamples(current, changed []mcmodel.Entity, ids []int, states []mcmodel.EntityState) []mcmodel.Entity {
+	byID := make(map[int]mcmodel.Entity)
+	for _, sample := range current {
+		byID[sample.ID] = sample
+	}
+
+	for _, sample := range changed {
+		byID[sample.ID] = sample
+	}
+
+	statesBySampleID := make(map[int][]mcmodel.EntityState)
+	for _, state := range states {
+		statesBySampleID[state.EntityID] = append(statesBySampleID[state.EntityID], state)
+	}
+
+	merged := make([]mcmodel.Entity, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		if sample, ok := byID[id]; ok {
+			sample.EntityStates = statesBySampleID[id]
+			merged = append(merged, sample)
+		}
+	}
+
+	return merged
+}
+
+// mergeAttributes returns the attributes with the given ids, taking the changed version of an attribute
+// over the current one.
+func mergeAttributes(current, changed []*mcmodel.Attribute, ids []int) []*mcmodel.Attribute {
+	byID := make(map[int]*mcmodel.Attribute)
+	for _, attr := range current {
+		byID[attr.ID] = attr
+	}
+
+	for _, attr := range changed {
+		byID[attr.ID] = attr
+	}
+
+	merged := make([]*mcmodel.Attribute, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		if attr, ok := byID[id]; ok {
+			merged = append(merged, attr)
+		}
+	}
+
+	return merged
+}
+
+func sortedIDs(ids []int) []int {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+	return sorted
+}
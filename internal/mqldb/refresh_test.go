@@ -0,0 +1,135 @@
+package mqldb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRefreshProject(t *testing.T) {
+	loader := createSQLiteTestLoader(t)
+
+	db, err := LoadProject(1, loader)
+	if err != nil {
+		t.Fatalf("Failed loading project: %s", err)
+	}
+
+	// Rename SEM, add an EBSD process that S2 went through, delete S1's hardness, change S2's hardness and
+	// delete the Heat Treatment process along with its temperature.
+	updatedAt := db.LoadedAt.Add(time.Minute)
+	changes := []struct {
+		statement string
+		values    []interface{}
+	}{
+		{"update activities set name = 'SEM 2', updated_at = ? where id = 2", []interface{}{updatedAt}},
+		{"insert into activities (id, name, project_id, created_at, updated_at) values (5, 'EBSD', 1, ?, ?)", []interface{}{updatedAt, updatedAt}},
+		{"insert into activity2entity (id, activity_id, entity_id) values (5, 5, 2)", nil},
+		{"delete from attributes where id = 2", nil},
+		{"update attribute_values set val = '{\"value\": 12}', updated_at = ? where id = 3", []interface{}{updatedAt}},
+		{"delete from activities where id = 1", nil},
+		{"delete from attributes where id = 1", nil},
+		{"delete from activity2entity where activity_id = 1", nil},
+	}
+
+	for _, change := range changes {
+		if err := loader.db.Exec(change.statement, change.values...).Error; err != nil {
+			t.Fatalf("Failed changing test database with %q: %s", change.statement, err)
+		}
+	}
+
+	refreshed, err := RefreshProject(db, loader, nil)
+	if err != nil {
+		t.Fatalf("Failed refreshing project: %s", err)
+	}
+
+	var names []string
+	for _, process := range refreshed.Processes {
+		names = append(names, process.Name)
+	}
+
+	if !reflect.DeepEqual(names, []string{"SEM 2", "EBSD"}) {
+		t.Fatalf("Expected processes SEM 2 and EBSD, got %v", names)
+	}
+
+	if len(refreshed.AllProcessAttributes) != 0 {
+		t.Fatalf("Expected the temperature to be deleted, got %+v", refreshed.AllProcessAttributes)
+	}
+
+	hard := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "hardness", Operation: ">", Value: 5}
+	if _, samples := EvalStatement(refreshed, selectAllSamples(), hard); !reflect.DeepEqual(sampleIDs(samples), []int{2}) {
+		t.Fatalf("Expected sample 2 to match after the refresh, got %v", sampleIDs(samples))
+	}
+
+	if processes := refreshed.SampleProcesses[2]; len(processes) != 2 || processes[1].Name != "EBSD" {
+		t.Fatalf("Expected S2 to have gone through SEM 2 and EBSD, got %+v", processes)
+	}
+
+	if createdAt := refreshed.ProcessCreatedAt[5]; !createdAt.Equal(updatedAt) {
+		t.Fatalf("Expected EBSD to have been created at %s, got %s", updatedAt, createdAt)
+	}
+
+	if refreshed.LoadedAt.Before(db.LoadedAt) {
+		t.Fatalf("Expected the refreshed DB to be loaded no earlier than %s, got %s", db.LoadedAt, refreshed.LoadedAt)
+	}
+
+	// The DB that was refreshed is left as it was.
+	if _, samples := EvalStatement(db, selectAllSamples(), hard); !reflect.DeepEqual(sampleIDs(samples), []int{1}) {
+		t.Fatalf("Expected sample 1 to match before the refresh, got %v", sampleIDs(samples))
+	}
+
+	if len(db.Processes) != 2 || db.Processes[1].Name != "SEM" {
+		t.Fatalf("Expected the original processes to be unchanged, got %+v", db.Processes)
+	}
+}
+
+func TestRefreshProjectDeletedValue(t *testing.T) {
+	loader := createSQLiteTestLoader(t)
+
+	// S2's hardness gets a second value, 6, which is then deleted. Deleting it doesn't update the hardness.
+	if err := loader.db.Exec("insert into attribute_values (id, attribute_id, unit, val) values (5, 3, '', '{\"value\": 6}')").Error; err != nil {
+		t.Fatalf("Failed adding value: %s", err)
+	}
+
+	db, err := LoadProject(1, loader)
+	if err != nil {
+		t.Fatalf("Failed loading project: %s", err)
+	}
+
+	hard := MatchStatement{FieldType: SampleAttributeFieldType, FieldName: "hardness", Operation: ">", Value: 5}
+	if _, samples := EvalStatement(db, selectAllSamples(), hard); !reflect.DeepEqual(sampleIDs(samples), []int{1, 2}) {
+		t.Fatalf("Expected samples 1 and 2 to match before the refresh, got %v", sampleIDs(samples))
+	}
+
+	if err := loader.db.Exec("delete from attribute_values where id = 5").Error; err != nil {
+		t.Fatalf("Failed deleting value: %s", err)
+	}
+
+	refreshed, err := RefreshProject(db, loader, nil)
+	if err != nil {
+		t.Fatalf("Failed refreshing project: %s", err)
+	}
+
+	if _, samples := EvalStatement(refreshed, selectAllSamples(), hard); !reflect.DeepEqual(sampleIDs(samples), []int{1}) {
+		t.Fatalf("Expected sample 1 to match after the refresh, got %v", sampleIDs(samples))
+	}
+}
+
+func TestRefreshProjectWithoutChangeLoader(t *testing.T) {
+	path := writeTestDump(t, createTestDump())
+	db, err := LoadProject(1, NewJSONLoader(path))
+	if err != nil {
+		t.Fatalf("Failed loading dump: %s", err)
+	}
+
+	// A JSONLoader can't tell what changed, so the whole project is loaded again.
+	refreshed, err := RefreshProject(db, NewJSONLoader(path), nil)
+	if err != nil {
+		t.Fatalf("Failed refreshing project: %s", err)
+	}
+
+	if refreshed == db {
+		t.Fatalf("Expected a new DB")
+	}
+
+	checkLoadedTestProject(t, refreshed)
+}
@@ -1,8 +1,17 @@
 package mqldb
 
+// Selection determines what a query returns. Results are ordered by ID unless OrderBy is given, in
+// which case ties are still broken by ID. Offset skips that many results and a Limit of 0 returns all
+// the remaining results. When there are Aggregates the query returns them, grouped by GroupBy, instead
+// (see EvalAggregate).
 type Selection struct {
 	ProcessSelection ProcessSelection
 	SampleSelection  SampleSelection
+	Aggregates       []Aggregate
+	GroupBy          []GroupBy
+	OrderBy          []OrderBy
+	Limit            int
+	Offset           int
 }
 
 type ProcessSelection struct {
@@ -18,3 +27,11 @@ type SampleSelection struct {
 	ID         bool
 	Attributes []string
 }
+
+// OrderBy is a field or attribute to order results by. FieldType is one of ProcessFieldType,
+// ProcessAttributeFieldType, SampleFieldType or SampleAttributeFieldType.
+type OrderBy struct {
+	FieldType  int    `json:"field_type"`
+	FieldName  string `json:"field_name"`
+	Descending bool   `json:"descending"`
+}
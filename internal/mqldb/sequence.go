@@ -0,0 +1,135 @@
+package mqldb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// processSequence is the pattern for a has-sequence match. The processes have to appear in a sample's
+// history in order, though other processes can come between them. excluded[i] holds the processes that
+// can't appear before processes[i], back to the process before it. The last entry of excluded is for
+// the processes that can't appear after the last process in the sequence.
+//
+// For example ("Solution Treatment", not "Quench", "Aging") has the processes Solution Treatment and
+// Aging, and excluded is [{}, {Quench}, {}].
+type processSequence struct {
+	processes []string
+	excluded  []map[string]bool
+}
+
+// newProcessSequence builds the pattern from the Value of a has-sequence match. Each step is either a
+// process name, or an excluded process written as {"not": name}.
+func newProcessSequence(value interface{}) (*processSequence, error) {
+	steps, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("has-sequence requires a list of processes, got %v", value)
+	}
+
+	sequence := &processSequence{excluded: []map[string]bool{{}}}
+	for _, step := range steps {
+		switch s := step.(type) {
+		case string:
+			sequence.processes = append(sequence.processes, s)
+			sequence.excluded = append(sequence.excluded, map[string]bool{})
+		case map[string]interface{}:
+			name, ok := s["not"].(string)
+			if !ok {
+				return nil, fmt.Errorf("has-sequence step %v must be a process name or {\"not\": name}", step)
+			}
+			sequence.excluded[len(sequence.excluded)-1][name] = true
+		default:
+			return nil, fmt.Errorf("has-sequence step %v must be a process name or {\"not\": name}", step)
+		}
+	}
+
+	if len(sequence.processes) == 0 {
+		return nil, fmt.Errorf("has-sequence needs at least one process that isn't excluded")
+	}
+
+	return sequence, nil
+}
+
+// matches checks whether the sequence appears in history, the names of a sample's processes in the
+// order it went through them. A process can be matched at more than one place in the history, and a
+// later one may be the only one without an excluded process in the way, so every place a process in the
+// sequence could be matched is tracked rather than just the first.
+func (s *processSequence) matches(history []string) bool {
+	// matched[i] is true when the sequence so far can end with history[i]. Before the first process of
+	// the sequence it can end anywhere, as long as none of the processes excluded before it come first.
+	var matched []bool
+	for i, process := range s.processes {
+		next := make([]bool, len(history))
+		excluded := s.excluded[i]
+
+		// Walk the history once. open is true when a previous match can reach the current position without
+		// passing an excluded process.
+		open := i == 0
+		for j, name := range history {
+			if open && name == process {
+				next[j] = true
+			}
+
+			if excluded[name] {
+				open = false
+			}
+
+			if i != 0 && matched[j] {
+				open = true
+			}
+		}
+
+		matched = next
+	}
+
+	// Finally none of the processes excluded after the last process in the sequence can follow it.
+	excluded := s.excluded[len(s.processes)]
+	for j := len(history) - 1; j >= 0; j-- {
+		if matched[j] {
+			return true
+		}
+
+		if excluded[history[j]] {
+			return false
+		}
+	}
+
+	return false
+}
+
+// evalSampleFuncMatchHasSequence implements the has-sequence function for samples. It determines whether
+// the sample went through the processes in the sequence in order. The processes of a sample are ordered by
+// when they were created.
+func evalSampleFuncMatchHasSequence(state *SampleState, db *DB, match MatchStatement) bool {
+	sequence := match.sequence
+	if sequence == nil {
+		var err error
+		if sequence, err = newProcessSequence(match.Value); err != nil {
+			return false
+		}
+	}
+
+	var history []string
+	for _, process := range processHistory(db, db.SampleProcesses[state.sample.ID]) {
+		history = append(history, process.Name)
+	}
+
+	return sequence.matches(history)
+}
+
+// processHistory returns the processes in the order they were created in, going by DB.ProcessCreatedAt.
+// Processes created at the same time are ordered by ID. This is the order a sample is taken to have gone
+// through its processes in, both by has-sequence and by upstream and downstream.
+func processHistory(db *DB, processes []*mcmodel.Activity) []*mcmodel.Activity {
+	ordered := append([]*mcmodel.Activity{}, processes...)
+	sort.Slice(ordered, func(i, j int) bool {
+		createdI, createdJ := db.ProcessCreatedAt[ordered[i].ID], db.ProcessCreatedAt[ordered[j].ID]
+		if !createdI.Equal(createdJ) {
+			return createdI.Before(createdJ)
+		}
+		return ordered[i].ID < ordered[j].ID
+	})
+
+	return ordered
+}
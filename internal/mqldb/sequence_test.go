@@ -0,0 +1,116 @@
+package mqldb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProcessSequenceMatches(t *testing.T) {
+	tests := []struct {
+		steps    []interface{}
+		history  []string
+		expected bool
+	}{
+		{[]interface{}{"ST", "Age"}, []string{"ST", "Age"}, true},
+		{[]interface{}{"ST", "Age"}, []string{"ST", "Quench", "Age"}, true},
+		{[]interface{}{"ST", "Age"}, []string{"Age", "ST"}, false},
+		{[]interface{}{"ST", "Age"}, []string{"ST"}, false},
+		{[]interface{}{"ST", map[string]interface{}{"not": "Quench"}, "Age"}, []string{"ST", "Quench", "Age"}, false},
+		{[]interface{}{"ST", map[string]interface{}{"not": "Quench"}, "Age"}, []string{"Quench", "ST", "Age", "Quench"}, true},
+
+		// The second ST doesn't have a Quench before Age, even though the first one does
+		{[]interface{}{"ST", map[string]interface{}{"not": "Quench"}, "Age"}, []string{"ST", "Quench", "ST", "Age"}, true},
+
+		// Excluded processes before the first and after the last process in the sequence
+		{[]interface{}{map[string]interface{}{"not": "Cast"}, "ST"}, []string{"Cast", "ST"}, false},
+		{[]interface{}{map[string]interface{}{"not": "Cast"}, "ST"}, []string{"ST", "Cast", "ST"}, true},
+		{[]interface{}{"ST", map[string]interface{}{"not": "Age"}}, []string{"ST", "Age"}, false},
+		{[]interface{}{"ST", map[string]interface{}{"not": "Age"}}, []string{"ST", "Age", "ST"}, true},
+	}
+
+	for _, test := range tests {
+		sequence, err := newProcessSequence(test.steps)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %s", test.steps, err)
+		}
+
+		if matched := sequence.matches(test.history); matched != test.expected {
+			t.Errorf("Expected %v matching %v against %v, got %v", test.expected, test.steps, test.history, matched)
+		}
+	}
+}
+
+func TestHasSequence(t *testing.T) {
+	db := createLineageTestDB()
+
+	tests := []struct {
+		steps             []interface{}
+		expectedSamples   []int
+		expectedProcesses []int
+	}{
+		{[]interface{}{"Casting", "Sectioning"}, []int{1}, []int{1, 2}},
+		{[]interface{}{"Sectioning", "Tensile"}, []int{2}, []int{2, 3, 4}},
+		{[]interface{}{"Sectioning", map[string]interface{}{"not": "Heat Treatment"}, "Tensile"}, []int{}, []int{}},
+		{[]interface{}{"Tensile", "Sectioning"}, []int{}, []int{}},
+	}
+
+	selection := Selection{ProcessSelection: ProcessSelection{All: true}, SampleSelection: SampleSelection{All: true}}
+	for _, test := range tests {
+		hasSequence := MatchStatement{FieldType: SampleFuncType, Operation: "has-sequence", Value: test.steps}
+		processes, samples := EvalStatement(db, selection, hasSequence)
+		if !reflect.DeepEqual(sampleIDs(samples), test.expectedSamples) {
+			t.Errorf("For %v expected samples %v, got %v", test.steps, test.expectedSamples, sampleIDs(samples))
+		}
+
+		if !reflect.DeepEqual(processIDs(processes), test.expectedProcesses) {
+			t.Errorf("For %v expected processes %v, got %v", test.steps, test.expectedProcesses, processIDs(processes))
+		}
+	}
+}
+
+func TestValidateHasSequence(t *testing.T) {
+	tests := []interface{}{
+		"Casting",
+		[]interface{}{map[string]interface{}{"not": "Quench"}},
+		[]interface{}{"Casting", 5},
+		[]interface{}{"Casting", map[string]interface{}{"without": "Quench"}},
+	}
+
+	for _, test := range tests {
+		hasSequence := MatchStatement{FieldType: SampleFuncType, Operation: "has-sequence", Value: test}
+		if err := ValidateStatement(hasSequence); err == nil {
+			t.Errorf("Expected an error for %v", test)
+		}
+	}
+}
+
+func TestHasSequenceOrdersByCreatedAt(t *testing.T) {
+	db := createLineageTestDB()
+
+	// Tensile was recorded after Heat Treatment but done before it. Casting and Sectioning were created at
+	// the same time, so they stay in the order of their IDs.
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	db.ProcessCreatedAt[1] = start
+	db.ProcessCreatedAt[2] = start
+	db.ProcessCreatedAt[3] = start.Add(2 * time.Hour)
+	db.ProcessCreatedAt[4] = start.Add(time.Hour)
+
+	tests := []struct {
+		steps           []interface{}
+		expectedSamples []int
+	}{
+		{[]interface{}{"Tensile", "Heat Treatment"}, []int{2}},
+		{[]interface{}{"Heat Treatment", "Tensile"}, []int{}},
+		{[]interface{}{"Casting", "Sectioning"}, []int{1}},
+	}
+
+	selection := Selection{SampleSelection: SampleSelection{All: true}}
+	for _, test := range tests {
+		hasSequence := MatchStatement{FieldType: SampleFuncType, Operation: "has-sequence", Value: test.steps}
+		_, samples := EvalStatement(db, selection, hasSequence)
+		if !reflect.DeepEqual(sampleIDs(samples), test.expectedSamples) {
+			t.Errorf("For %v expected samples %v, got %v", test.steps, test.expectedSamples, sampleIDs(samples))
+		}
+	}
+}
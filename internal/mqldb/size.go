@@ -0,0 +1,74 @@
+package mqldb
+
+import (
+	"reflect"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+var (
+	processSize        = int64(reflect.TypeOf(mcmodel.Activity{}).Size())
+	sampleSize         = int64(reflect.TypeOf(mcmodel.Entity{}).Size())
+	sampleStateSize    = int64(reflect.TypeOf(mcmodel.EntityState{}).Size())
+	attributeSize      = int64(reflect.TypeOf(mcmodel.Attribute{}).Size())
+	attributeValueSize = int64(reflect.TypeOf(mcmodel.AttributeValue{}).Size())
+	pointerSize        = int64(reflect.TypeOf(&mcmodel.Activity{}).Size())
+)
+
+// mapEntrySize is a rough allowance for a map entry, its key and value along with the map's own overhead.
+const mapEntrySize = 48
+
+// ApproximateSize estimates how much memory the DB uses, in bytes. It counts the processes, samples, states,
+// attributes and their values, including the strings they hold, and the lookups built over them. It is meant
+// for comparing DBs and keeping a budget, not as an exact measure.
+func (db *DB) ApproximateSize() int64 {
+	var size int64
+
+	for _, process := range db.Processes {
+		// Each process has a copy of its attributes, the values of which are shared with AllProcessAttributes.
+		size += processSize + int64(len(process.Name)) + int64(len(process.Attributes))*attributeSize
+	}
+
+	for _, sample := range db.Samples {
+		size += sampleSize + int64(len(sample.Name))
+		for _, state := range sample.EntityStates {
+			size += sampleStateSize + int64(len(state.Attributes))*attributeSize
+		}
+	}
+
+	size += attributesSize(db.AllProcessAttributes)
+	size += attributesSize(db.AllSampleAttributes)
+
+	for _, attributes := range db.ProcessAttributesByProcessID {
+		size += mapEntrySize + int64(len(attributes))*mapEntrySize
+	}
+
+	for _, states := range db.SampleAttributesBySampleIDAndStates {
+		size += mapEntrySize
+		for _, attributes := range states {
+			size += mapEntrySize + int64(len(attributes))*mapEntrySize
+		}
+	}
+
+	for _, samples := range db.ProcessSamples {
+		size += mapEntrySize + int64(len(samples))*pointerSize
+	}
+
+	for _, processes := range db.SampleProcesses {
+		size += mapEntrySize + int64(len(processes))*pointerSize
+	}
+
+	return size
+}
+
+func attributesSize(attributes []*mcmodel.Attribute) int64 {
+	var size int64
+	for _, attr := range attributes {
+		size += pointerSize + attributeSize + int64(len(attr.Name)+len(attr.UUID)+len(attr.AttributableType))
+		for _, value := range attr.AttributeValues {
+			size += attributeValueSize + int64(len(value.UUID)+len(value.Unit)+len(value.Val)+len(value.ValueString))
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,24 @@
+package mqldb
+
+import (
+	"testing"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+func TestApproximateSize(t *testing.T) {
+	if size := NewDBWithLoader(1, nil).ApproximateSize(); size != 0 {
+		t.Fatalf("Expected an empty DB to have a size of 0, got %d", size)
+	}
+
+	db := createTestDB()
+	size := db.ApproximateSize()
+	if size <= 0 {
+		t.Fatalf("Expected a positive size, got %d", size)
+	}
+
+	db.Samples = append(db.Samples, mcmodel.Entity{ID: 4, Name: "S4", EntityStates: []mcmodel.EntityState{{ID: 7}}})
+	if grown := db.ApproximateSize(); grown <= size {
+		t.Fatalf("Expected the size to grow from %d when a sample is added, got %d", size, grown)
+	}
+}
@@ -0,0 +1,230 @@
+package mqldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// A snapshot is a binary copy of a loaded DB that can be read back much faster than loading the project
+// from the database. It starts with a fixed size header followed by the gob encoded ProjectDump of the DB.
+// The header identifies the file as a snapshot, the version of the format and the project, and has the
+// length and CRC-32 checksum of the dump so that a truncated or corrupted snapshot is rejected.
+//
+// SnapshotVersion must be bumped whenever ProjectDump, or the types it contains, changes in a way that
+// makes older snapshots unreadable. Snapshots with a different version are rejected, and the project is
+// then loaded from the database.
+const SnapshotVersion = 1
+
+var snapshotMagic = [4]byte{'M', 'Q', 'L', 'S'}
+
+type snapshotHeader struct {
+	Magic            [4]byte
+	Version          uint32
+	ProjectID        int64
+	ProjectUpdatedAt int64
+	CreatedAt        int64
+	Length           uint64
+	Checksum         uint32
+}
+
+// SnapshotInfo describes a snapshot, as read from its header.
+type SnapshotInfo struct {
+	ProjectID int
+
+	// ProjectUpdatedAt is when the project was last updated as of the DB the snapshot was made from.
+	ProjectUpdatedAt time.Time
+
+	// CreatedAt is when the snapshot was written.
+	CreatedAt time.Time
+}
+
+// SnapshotPath returns the path of the snapshot for the project in dir.
+func SnapshotPath(dir string, projectID int) string {
+	return filepath.Join(dir, fmt.Sprintf("project-%d.mqlsnap", projectID))
+}
+
+// WriteSnapshot writes a snapshot of db to w.
+func WriteSnapshot(db *DB, w io.Writer) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(snapshotDump(db)); err != nil {
+		return fmt.Errorf("failed encoding snapshot of project %d: %s", db.ProjectID, err)
+	}
+
+	header := snapshotHeader{
+		Magic:            snapshotMagic,
+		Version:          SnapshotVersion,
+		ProjectID:        int64(db.ProjectID),
+		ProjectUpdatedAt: snapshotTime(db.ProjectUpdatedAt),
+		CreatedAt:        time.Now().UnixNano(),
+		Length:           uint64(payload.Len()),
+		Checksum:         crc32.ChecksumIEEE(payload.Bytes()),
+	}
+
+	if err := binary.Write(w, binary.BigEndian, &header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+// SaveSnapshot writes a snapshot of db to SnapshotPath in dir. The snapshot is written to a temporary file
+// that then replaces any existing snapshot, so a snapshot that is being read is never partially written.
+func SaveSnapshot(db *DB, dir string) error {
+	f, err := os.CreateTemp(dir, fmt.Sprintf(".project-%d-*.mqlsnap", db.ProjectID))
+	if err != nil {
+		return err
+	}
+
+	if err := WriteSnapshot(db, f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), SnapshotPath(dir, db.ProjectID))
+}
+
+// ReadSnapshotInfo reads the header of the snapshot at path, without reading the rest of it.
+func ReadSnapshotInfo(path string) (*SnapshotInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header, err := readSnapshotHeader(f, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotInfo{
+		ProjectID:        int(header.ProjectID),
+		ProjectUpdatedAt: snapshotHeaderTime(header.ProjectUpdatedAt),
+		CreatedAt:        time.Unix(0, header.CreatedAt),
+	}, nil
+}
+
+func readSnapshotHeader(r io.Reader, path string) (*snapshotHeader, error) {
+	var header snapshotHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed reading snapshot %s: %s", path, err)
+	}
+
+	if header.Magic != snapshotMagic {
+		return nil, fmt.Errorf("%s is not a snapshot", path)
+	}
+
+	if header.Version != SnapshotVersion {
+		return nil, fmt.Errorf("snapshot %s is version %d, expected version %d", path, header.Version, SnapshotVersion)
+	}
+
+	return &header, nil
+}
+
+// snapshotTime converts t for the header. The zero time, for a project whose update time isn't known, is
+// outside the range UnixNano can represent so it is written as 0.
+func snapshotTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func snapshotHeaderTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, t)
+}
+
+// SnapshotLoader loads a project from a snapshot written by WriteSnapshot or SaveSnapshot.
+type SnapshotLoader struct {
+	path string
+}
+
+func NewSnapshotLoader(path string) *SnapshotLoader {
+	return &SnapshotLoader{path: path}
+}
+
+func (l *SnapshotLoader) Load(db *DB) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header, err := readSnapshotHeader(f, l.path)
+	if err != nil {
+		return err
+	}
+
+	if int(header.ProjectID) != db.ProjectID {
+		return fmt.Errorf("snapshot %s is for project %d, not %d", l.path, header.ProjectID, db.ProjectID)
+	}
+
+	payload := make([]byte, header.Length)
+	if _, err := io.ReadFull(f, payload); err != nil {
+		return fmt.Errorf("failed reading snapshot %s: %s", l.path, err)
+	}
+
+	if crc32.ChecksumIEEE(payload) != header.Checksum {
+		return fmt.Errorf("snapshot %s is corrupt, its checksum doesn't match", l.path)
+	}
+
+	var dump ProjectDump
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&dump); err != nil {
+		return fmt.Errorf("failed decoding snapshot %s: %s", l.path, err)
+	}
+
+	db.loadDump(&dump)
+
+	return nil
+}
+
+// snapshotDump returns the dump of db to write to a snapshot. Only the JSON encoded Val of each attribute
+// value is kept, the converted values are recreated when the snapshot is loaded the same way they are when
+// loading from the database. This keeps the snapshot small, and keeps the interface{} values of complex
+// attribute values, which gob can't encode without registering their types, out of it.
+func snapshotDump(db *DB) *ProjectDump {
+	dump := db.Dump()
+	dump.ProcessAttributes = snapshotAttributes(dump.ProcessAttributes)
+	dump.SampleAttributes = snapshotAttributes(dump.SampleAttributes)
+	return dump
+}
+
+func snapshotAttributes(attributes []*mcmodel.Attribute) []*mcmodel.Attribute {
+	snapshot := make([]*mcmodel.Attribute, 0, len(attributes))
+	for _, attr := range attributes {
+		a := *attr
+		a.AttributeValues = make([]mcmodel.AttributeValue, 0, len(attr.AttributeValues))
+		for _, value := range attr.AttributeValues {
+			a.AttributeValues = append(a.AttributeValues, mcmodel.AttributeValue{
+				ID:          value.ID,
+				UUID:        value.UUID,
+				AttributeID: value.AttributeID,
+				Unit:        value.Unit,
+				Val:         value.Val,
+			})
+		}
+		snapshot = append(snapshot, &a)
+	}
+
+	return snapshot
+}
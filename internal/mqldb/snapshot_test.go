@@ -0,0 +1,89 @@
+package mqldb
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dump := createTestDump()
+	dump.ProjectUpdatedAt = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	db, err := LoadProject(1, NewJSONLoader(writeTestDump(t, dump)))
+	if err != nil {
+		t.Fatalf("Failed loading dump: %s", err)
+	}
+
+	dir := t.TempDir()
+	if err := SaveSnapshot(db, dir); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+
+	info, err := ReadSnapshotInfo(SnapshotPath(dir, 1))
+	if err != nil {
+		t.Fatalf("Failed reading snapshot info: %s", err)
+	}
+
+	if info.ProjectID != 1 || !info.ProjectUpdatedAt.Equal(dump.ProjectUpdatedAt) || info.CreatedAt.IsZero() {
+		t.Fatalf("Unexpected snapshot info %+v", info)
+	}
+
+	loaded, err := LoadProject(1, NewSnapshotLoader(SnapshotPath(dir, 1)))
+	if err != nil {
+		t.Fatalf("Failed loading snapshot: %s", err)
+	}
+
+	checkLoadedTestProject(t, loaded)
+
+	if !loaded.ProjectUpdatedAt.Equal(dump.ProjectUpdatedAt) {
+		t.Fatalf("Expected project updated at %s, got %s", dump.ProjectUpdatedAt, loaded.ProjectUpdatedAt)
+	}
+
+	if _, err := LoadProject(2, NewSnapshotLoader(SnapshotPath(dir, 1))); err == nil {
+		t.Fatalf("Expected an error loading the snapshot for project 1 as project 2")
+	}
+}
+
+func TestSnapshotRejected(t *testing.T) {
+	db, err := LoadProject(1, NewJSONLoader(writeTestDump(t, createTestDump())))
+	if err != nil {
+		t.Fatalf("Failed loading dump: %s", err)
+	}
+
+	dir := t.TempDir()
+	if err := SaveSnapshot(db, dir); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+
+	path := SnapshotPath(dir, 1)
+	snapshot, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed reading snapshot: %s", err)
+	}
+
+	headerSize := binary.Size(snapshotHeader{})
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+	}{
+		{"corrupt payload", func(b []byte) []byte { b[len(b)-1] ^= 0xff; return b }},
+		{"truncated payload", func(b []byte) []byte { return b[:len(b)-10] }},
+		{"truncated header", func(b []byte) []byte { return b[:headerSize-1] }},
+		{"not a snapshot", func(b []byte) []byte { b[0] = 'X'; return b }},
+		{"different version", func(b []byte) []byte { binary.BigEndian.PutUint32(b[4:], SnapshotVersion+1); return b }},
+	}
+
+	for _, test := range tests {
+		b := test.modify(append([]byte{}, snapshot...))
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatalf("Failed writing snapshot: %s", err)
+		}
+
+		if _, err := LoadProject(1, NewSnapshotLoader(path)); err == nil {
+			t.Errorf("Expected an error loading a snapshot with a %s", test.name)
+		}
+	}
+}
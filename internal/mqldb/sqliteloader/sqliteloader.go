@@ -0,0 +1,21 @@
+// Package sqliteloader loads projects from a SQLite database with the Materials Commons schema. It is kept
+// out of mqldb because the SQLite driver needs cgo, which programs that only read from MySQL, such as
+// mqlservd, shouldn't have to link.
+package sqliteloader
+
+import (
+	"github.com/materials-commons/mql/internal/mqldb"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// New opens a SQLite database with the Materials Commons schema, such as a copy of the tables for a
+// project, and returns a loader for it.
+func New(path string) (*mqldb.GormLoader, error) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	return mqldb.NewGormLoader(db), nil
+}
@@ -0,0 +1,20 @@
+package sqliteloader
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/materials-commons/mql/internal/mqldb"
+)
+
+func TestNew(t *testing.T) {
+	loader, err := New(filepath.Join(t.TempDir(), "mc.db"))
+	if err != nil {
+		t.Fatalf("Failed opening SQLite database: %s", err)
+	}
+
+	// The database is empty, so there is no project to load.
+	if _, err := mqldb.LoadProject(1, loader); err == nil {
+		t.Fatalf("Expected an error loading a project from an empty database")
+	}
+}
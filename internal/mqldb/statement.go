@@ -1,5 +1,7 @@
 package mqldb
 
+import "regexp"
+
 const (
 	ProcessFieldType          = 1
 	SampleFieldType           = 2
@@ -9,6 +11,12 @@ const (
 	SampleFuncType            = 6
 )
 
+// Quantifiers control how a match is evaluated against attributes that have multiple values.
+const (
+	QuantifierAny = "any" // At least one value has to match, this is the default
+	QuantifierAll = "all" // Every value has to match
+)
+
 type Statement interface {
 	statementNode()
 }
@@ -33,11 +41,85 @@ type OrStatement struct {
 func (s OrStatement) statementNode() {
 }
 
+type NotStatement struct {
+	// Ignored field that is here to distinguish json from the other statements
+	Not       int       `json:"not"`
+	Statement Statement `json:"statement"`
+}
+
+func (s NotStatement) statementNode() {
+}
+
+// SameStateStatement requires the sample conditions in Statement to all hold in the same state of a
+// sample. Without it, in a process context, each condition can be satisfied by a different sample or
+// state of the process.
+type SameStateStatement struct {
+	// Ignored field that is here to distinguish json from the other statements
+	SameState int       `json:"same_state"`
+	Statement Statement `json:"statement"`
+}
+
+func (s SameStateStatement) statementNode() {
+}
+
+// SameSampleStatement requires the sample conditions in Statement to all hold on the same sample, though
+// each condition can be satisfied in a different state of the sample.
+type SameSampleStatement struct {
+	// Ignored field that is here to distinguish json from the other statements
+	SameSample int       `json:"same_sample"`
+	Statement  Statement `json:"statement"`
+}
+
+func (s SameSampleStatement) statementNode() {
+}
+
+// Lineage directions for a LineageStatement.
+const (
+	LineageUpstream   = "upstream"
+	LineageDownstream = "downstream"
+)
+
+// LineageStatement matches the processes and samples upstream or downstream of the ones that match
+// Statement, following the samples from process to process. MaxSteps limits how far to follow them,
+// where each step goes from a process to one of its samples or from a sample to one of its processes.
+// A MaxSteps of 0 doesn't limit the number of steps. See lineage.go for how the lineage is traced.
+type LineageStatement struct {
+	Direction string    `json:"lineage"`
+	Statement Statement `json:"statement"`
+	MaxSteps  int       `json:"max_steps,omitempty"`
+
+	// The processes and samples the lineage reaches, computed the first time the statement is
+	// evaluated. See prepareStatement.
+	reached *lineage
+}
+
+func (s LineageStatement) statementNode() {
+}
+
+// MatchStatement matches a field, attribute or function against a value. Besides the comparison operators
+// (=, <>, <, <=, >, >=) string values support the pattern operations like, ilike (case-insensitive like),
+// contains, starts-with and ~ (regular expression). The in and between operations take a list as their
+// Value: the values to match for in, and the inclusive lower and upper bound for between. The is-null
+// and is-not-null operations have no Value. They check whether a field or attribute has a value. The
+// has-sequence sample function takes the list of process names a sample has to have gone through in
+// order, where a process that can't come between them is written as {"not": name}.
 type MatchStatement struct {
-	FieldType int         `json:"field_type"`
-	FieldName string      `json:"field_name"`
-	Operation string      `json:"operation"`
-	Value     interface{} `json:"value"`
+	FieldType  int         `json:"field_type"`
+	FieldName  string      `json:"field_name"`
+	Operation  string      `json:"operation"`
+	Value      interface{} `json:"value"`
+	Quantifier string      `json:"quantifier,omitempty"`
+
+	// Unit of a numeric Value, for example "C" or "mm". Attribute values recorded in a different unit
+	// are converted to this unit before they are compared. When Unit is empty values are compared as is.
+	// ValidateStatement rejects units that aren't in the conversion table in units.go.
+	Unit string `json:"unit,omitempty"`
+
+	// Compiled pattern for like, ilike and ~ operations, the set of values for in, and the processes for
+	// has-sequence. See prepareStatement.
+	regex    *regexp.Regexp
+	set      valueSet
+	sequence *processSequence
 }
 
 func (s MatchStatement) statementNode() {
@@ -74,6 +156,15 @@ func hasProcessMatchStatement(statement Statement) bool {
 			return true
 		}
 		return false
+
+	case NotStatement:
+		return hasProcessMatchStatement(s.Statement)
+
+	case SameStateStatement:
+		return hasProcessMatchStatement(s.Statement)
+
+	case SameSampleStatement:
+		return hasProcessMatchStatement(s.Statement)
 	}
 
 	return false
@@ -110,6 +201,54 @@ func hasSampleMatchStatement(statement Statement) bool {
 			return true
 		}
 		return false
+
+	case NotStatement:
+		return hasSampleMatchStatement(s.Statement)
+
+	case SameStateStatement:
+		// Matching on the states of a sample is always a sample match
+		return true
+
+	case SameSampleStatement:
+		return true
+	}
+
+	return false
+}
+
+// hasNotStatement returns true if there is a NotStatement anywhere in statement.
+func hasNotStatement(statement Statement) bool {
+	switch s := statement.(type) {
+	case AndStatement:
+		return hasNotStatement(s.Left) || hasNotStatement(s.Right)
+	case OrStatement:
+		return hasNotStatement(s.Left) || hasNotStatement(s.Right)
+	case NotStatement:
+		return true
+	case SameStateStatement:
+		return hasNotStatement(s.Statement)
+	case SameSampleStatement:
+		return hasNotStatement(s.Statement)
+	}
+
+	return false
+}
+
+// hasLineageStatement returns true if there is a LineageStatement anywhere in statement.
+func hasLineageStatement(statement Statement) bool {
+	switch s := statement.(type) {
+	case AndStatement:
+		return hasLineageStatement(s.Left) || hasLineageStatement(s.Right)
+	case OrStatement:
+		return hasLineageStatement(s.Left) || hasLineageStatement(s.Right)
+	case NotStatement:
+		return hasLineageStatement(s.Statement)
+	case SameStateStatement:
+		return hasLineageStatement(s.Statement)
+	case SameSampleStatement:
+		return hasLineageStatement(s.Statement)
+	case LineageStatement:
+		return true
 	}
 
 	return false
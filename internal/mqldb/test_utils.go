@@ -253,6 +253,19 @@ func createTestDB() *DB {
 			},
 		},
 	}
+	db.SampleAttributesBySampleIDAndStates[2][4]["grain size"] = &mcmodel.Attribute{
+		Name: "grain size",
+		AttributeValues: []mcmodel.AttributeValue{
+			{
+				ValueType: mcmodel.ValueTypeInt,
+				ValueInt:  11,
+			},
+			{
+				ValueType: mcmodel.ValueTypeInt,
+				ValueInt:  15,
+			},
+		},
+	}
 
 	db.Samples = append(db.Samples, mcmodel.Entity{
 		Name: "S3",
@@ -284,6 +297,19 @@ func createTestDB() *DB {
 			},
 		},
 	}
+	db.SampleAttributesBySampleIDAndStates[3][5]["grain size"] = &mcmodel.Attribute{
+		Name: "grain size",
+		AttributeValues: []mcmodel.AttributeValue{
+			{
+				ValueType: mcmodel.ValueTypeInt,
+				ValueInt:  8,
+			},
+			{
+				ValueType: mcmodel.ValueTypeInt,
+				ValueInt:  12,
+			},
+		},
+	}
 
 	db.SampleAttributesBySampleIDAndStates[3][6] = make(map[string]*mcmodel.Attribute)
 	db.SampleAttributesBySampleIDAndStates[3][6]["zn"] = &mcmodel.Attribute{
@@ -305,50 +331,21 @@ func createTestDB() *DB {
 		},
 	}
 
-	// Now set up the mapping of processes to samples, and samples to processes
-	db.ProcessSamples = make(map[int][]*mcmodel.Entity)
-
-	// EBSD
-	db.ProcessSamples[1] = []*mcmodel.Entity{
-		{ID: 1, Name: "S1"},
-		{ID: 2, Name: "S2"},
-	}
-
-	// EBSD
-	db.ProcessSamples[2] = []*mcmodel.Entity{
-		{ID: 3, Name: "S3"},
-	}
-
-	// Texture
-	db.ProcessSamples[3] = []*mcmodel.Entity{
-		{ID: 1, Name: "S1"},
-		{ID: 2, Name: "S2"},
-	}
+	// Now set up the mapping of processes to samples, and samples to processes. As in
+	// loadProcessSampleMappings these point at the entries in db.Samples and db.Processes.
+	s1, s2, s3 := &db.Samples[0], &db.Samples[1], &db.Samples[2]
+	ebsd1, ebsd2, texture1, texture2 := &db.Processes[0], &db.Processes[1], &db.Processes[2], &db.Processes[3]
 
-	// Texture
-	db.ProcessSamples[4] = []*mcmodel.Entity{
-		{ID: 3, Name: "S3"},
-	}
+	db.ProcessSamples = make(map[int][]*mcmodel.Entity)
+	db.ProcessSamples[ebsd1.ID] = []*mcmodel.Entity{s1, s2}
+	db.ProcessSamples[ebsd2.ID] = []*mcmodel.Entity{s3}
+	db.ProcessSamples[texture1.ID] = []*mcmodel.Entity{s1, s2}
+	db.ProcessSamples[texture2.ID] = []*mcmodel.Entity{s3}
 
 	db.SampleProcesses = make(map[int][]*mcmodel.Activity)
-
-	// S1
-	db.SampleProcesses[1] = []*mcmodel.Activity{
-		{ID: 1, Name: "EBSD"},
-		{ID: 3, Name: "Texture"},
-	}
-
-	// S2
-	db.SampleProcesses[2] = []*mcmodel.Activity{
-		{ID: 1, Name: "EBSD"},
-		{ID: 3, Name: "Texture"},
-	}
-
-	// S3
-	db.SampleProcesses[3] = []*mcmodel.Activity{
-		{ID: 2, Name: "EBSD"},
-		{ID: 4, Name: "Texture"},
-	}
+	db.SampleProcesses[s1.ID] = []*mcmodel.Activity{ebsd1, texture1}
+	db.SampleProcesses[s2.ID] = []*mcmodel.Activity{ebsd1, texture1}
+	db.SampleProcesses[s3.ID] = []*mcmodel.Activity{ebsd2, texture2}
 
 	return db
 }
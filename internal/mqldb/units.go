@@ -0,0 +1,201 @@
+package mqldb
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+)
+
+// unit describes how to convert a value in a unit to the base unit of its dimension, for example
+// mm to m. Converting to the base unit is value*factor + offset. The offset is only used for
+// temperatures.
+type unit struct {
+	dimension string
+	factor    float64
+	offset    float64
+}
+
+// units is the conversion table. Each dimension has a base unit with a factor of 1: K for temperature,
+// m for length, Pa for pressure and stress, s for time, kg for mass and J for energy.
+var units = map[string]unit{
+	// Temperature
+	"K":          {"temperature", 1, 0},
+	"kelvin":     {"temperature", 1, 0},
+	"C":          {"temperature", 1, 273.15},
+	"°C":         {"temperature", 1, 273.15},
+	"degC":       {"temperature", 1, 273.15},
+	"celsius":    {"temperature", 1, 273.15},
+	"F":          {"temperature", 5.0 / 9.0, 459.67 * 5.0 / 9.0},
+	"°F":         {"temperature", 5.0 / 9.0, 459.67 * 5.0 / 9.0},
+	"degF":       {"temperature", 5.0 / 9.0, 459.67 * 5.0 / 9.0},
+	"fahrenheit": {"temperature", 5.0 / 9.0, 459.67 * 5.0 / 9.0},
+
+	// Length
+	"km":       {"length", 1e3, 0},
+	"m":        {"length", 1, 0},
+	"cm":       {"length", 1e-2, 0},
+	"mm":       {"length", 1e-3, 0},
+	"um":       {"length", 1e-6, 0},
+	"µm":       {"length", 1e-6, 0},
+	"micron":   {"length", 1e-6, 0},
+	"nm":       {"length", 1e-9, 0},
+	"angstrom": {"length", 1e-10, 0},
+	"Å":        {"length", 1e-10, 0},
+	"in":       {"length", 0.0254, 0},
+	"ft":       {"length", 0.3048, 0},
+
+	// Pressure and stress
+	"Pa":  {"pressure", 1, 0},
+	"kPa": {"pressure", 1e3, 0},
+	"MPa": {"pressure", 1e6, 0},
+	"GPa": {"pressure", 1e9, 0},
+	"bar": {"pressure", 1e5, 0},
+	"atm": {"pressure", 101325, 0},
+	"psi": {"pressure", 6894.757293168, 0},
+	"ksi": {"pressure", 6894757.293168, 0},
+
+	// Time
+	"ms":      {"time", 1e-3, 0},
+	"s":       {"time", 1, 0},
+	"sec":     {"time", 1, 0},
+	"min":     {"time", 60, 0},
+	"h":       {"time", 3600, 0},
+	"hr":      {"time", 3600, 0},
+	"hours":   {"time", 3600, 0},
+	"day":     {"time", 86400, 0},
+	"days":    {"time", 86400, 0},
+	"seconds": {"time", 1, 0},
+	"minutes": {"time", 60, 0},
+
+	// Mass
+	"kg": {"mass", 1, 0},
+	"g":  {"mass", 1e-3, 0},
+	"mg": {"mass", 1e-6, 0},
+
+	// Energy
+	"J":   {"energy", 1, 0},
+	"kJ":  {"energy", 1e3, 0},
+	"eV":  {"energy", 1.602176634e-19, 0},
+	"keV": {"energy", 1.602176634e-16, 0},
+}
+
+// unitNamesByLowerName maps the lower case name of each unit to its name, so that units can be found
+// regardless of case, for example "MPA" for MPa. Names that differ only by case are left out as they are
+// ambiguous.
+var unitNamesByLowerName = func() map[string]string {
+	byLowerName := make(map[string]string)
+	ambiguous := make(map[string]bool)
+	for name := range units {
+		lower := strings.ToLower(name)
+		if _, ok := byLowerName[lower]; ok {
+			ambiguous[lower] = true
+		}
+		byLowerName[lower] = name
+	}
+
+	for name := range ambiguous {
+		delete(byLowerName, name)
+	}
+
+	return byLowerName
+}()
+
+// unitPrefixes are the metric prefixes used in the names of units.
+const unitPrefixes = "GMkcmuµn"
+
+// lookupUnit finds a unit by its name, or failing that regardless of case. The case of a prefix always has
+// to match though, as m and M are milli and mega: "Mm" isn't taken to be mm, nor "mpa" to be MPa.
+func lookupUnit(name string) (unit, bool) {
+	if u, ok := units[name]; ok {
+		return u, true
+	}
+
+	canonical, ok := unitNamesByLowerName[strings.ToLower(name)]
+	if !ok {
+		return unit{}, false
+	}
+
+	prefix, _ := utf8.DecodeRuneInString(canonical)
+	if isPrefixedUnit(canonical) && !strings.HasPrefix(name, string(prefix)) {
+		return unit{}, false
+	}
+
+	return units[canonical], true
+}
+
+// isPrefixedUnit returns true when the name of a unit is a metric prefix followed by another unit of the
+// same dimension, as in mm or kPa. min isn't m followed by in, as minutes aren't a length.
+func isPrefixedUnit(name string) bool {
+	prefix, size := utf8.DecodeRuneInString(name)
+	if !strings.ContainsRune(unitPrefixes, prefix) || size == len(name) {
+		return false
+	}
+
+	unprefixed, ok := units[name[size:]]
+	return ok && unprefixed.dimension == units[name].dimension
+}
+
+// convertUnit converts value from one unit to another. It returns false if either unit is unknown or
+// the units measure different things, for example mm and K.
+func convertUnit(value float64, from, to string) (float64, bool) {
+	if from == to {
+		return value, true
+	}
+
+	fromUnit, ok := lookupUnit(from)
+	if !ok {
+		return 0, false
+	}
+
+	toUnit, ok := lookupUnit(to)
+	if !ok || fromUnit.dimension != toUnit.dimension {
+		return 0, false
+	}
+
+	base := value*fromUnit.factor + fromUnit.offset
+	return roundConverted((base - toUnit.offset) / toUnit.factor), true
+}
+
+// roundConverted rounds a converted value to 12 significant digits, which removes the floating point
+// error the conversion introduces. Without this 773.15 K converts to 499.99999999999994 C and doesn't
+// match a:temperature = 500 C.
+func roundConverted(value float64) float64 {
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(value, 'g', 12, 64), 64)
+	if err != nil {
+		return value
+	}
+
+	return rounded
+}
+
+// convertAttributeValue converts a numeric attribute value into the unit of a match, so that
+// a:temperature > 500 C matches a value stored as 800 K. Values with no unit are assumed to already
+// be in the unit of the match and are returned unchanged. It returns false when the value can't be
+// converted, because it isn't a number or its unit is unknown or measures something else.
+func convertAttributeValue(value mcmodel.AttributeValue, toUnit string) (mcmodel.AttributeValue, bool) {
+	if value.Unit == "" || value.Unit == toUnit {
+		return value, true
+	}
+
+	var number float64
+	switch value.ValueType {
+	case mcmodel.ValueTypeInt:
+		number = float64(value.ValueInt)
+	case mcmodel.ValueTypeFloat:
+		number = value.ValueFloat
+	default:
+		return value, false
+	}
+
+	converted, ok := convertUnit(number, value.Unit, toUnit)
+	if !ok {
+		return value, false
+	}
+
+	value.ValueType = mcmodel.ValueTypeFloat
+	value.ValueFloat = converted
+	value.Unit = toUnit
+	return value, true
+}
@@ -0,0 +1,47 @@
+package mqldb
+
+import "testing"
+
+func TestConvertUnit(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from     string
+		to       string
+		expected float64
+		ok       bool
+	}{
+		{773.15, "K", "C", 500, true},
+		{500, "C", "K", 773.15, true},
+		{212, "F", "C", 100, true},
+		{-40, "C", "F", -40, true},
+		{2.5, "mm", "um", 2500, true},
+		{1, "in", "mm", 25.4, true},
+		{10, "MPa", "Pa", 1e7, true},
+		{1, "ksi", "MPa", 6.89475729317, true},
+		{2, "h", "min", 120, true},
+		{1, "MPA", "kPa", 1000, true},
+		{5, "c", "K", 278.15, true},
+		{5, "Celsius", "K", 278.15, true},
+		{2, "h", "Min", 120, true},
+		{2, "MIN", "s", 120, true},
+		{1, "min", "Sec", 60, true},
+		{1, "mpa", "kPa", 0, false},
+		{1, "Mm", "m", 0, false},
+		{1, "Mg", "g", 0, false},
+		{5, "mm", "K", 0, false},
+		{5, "furlong", "m", 0, false},
+		{5, "widgets", "widgets", 5, true},
+	}
+
+	for _, test := range tests {
+		converted, ok := convertUnit(test.value, test.from, test.to)
+		if ok != test.ok {
+			t.Errorf("Converting %v %s to %s expected ok = %t, got %t", test.value, test.from, test.to, test.ok, ok)
+			continue
+		}
+
+		if ok && converted != test.expected {
+			t.Errorf("Converting %v %s to %s expected %v, got %v", test.value, test.from, test.to, test.expected, converted)
+		}
+	}
+}
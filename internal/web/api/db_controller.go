@@ -1,27 +1,93 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"sync"
+	"path/filepath"
+	"strconv"
+	"time"
 
+	"github.com/apex/log"
 	"github.com/labstack/echo/v4"
 	"github.com/materials-commons/gomcdb/mcmodel"
+	"github.com/materials-commons/mql/internal/mql"
+	"github.com/materials-commons/mql/internal/mql/parser"
 	"github.com/materials-commons/mql/internal/mqldb"
 	"gorm.io/gorm"
 )
 
 var (
-	DB               *gorm.DB
-	mutex            sync.Mutex
-	mqlDBByProjectID map[int]*mqldb.DB
+	DB       *gorm.DB
+	projects *projectRegistry
+
+	// snapshotDir is where snapshots of loaded projects are kept, snapshots are not used when it is empty.
+	// A snapshot older than snapshotMaxAge, when it is set, is stale.
+	snapshotDir    string
+	snapshotMaxAge time.Duration
+
+	// queryLoadWait is how long a query on a project that isn't loaded waits for it to load.
+	queryLoadWait = 30 * time.Second
 )
 
+// loadRetryAfter is the number of seconds a query on a project that is still loading is told to wait before
+// trying again.
+const loadRetryAfter = 10
+
 func Init(db *gorm.DB) {
 	DB = db
-	mqlDBByProjectID = make(map[int]*mqldb.DB)
+	projects = newProjectRegistry()
+}
+
+// SetProjectBudget limits the projects kept loaded to maxProjects, and to maxMemory bytes as estimated by
+// mqldb.DB.ApproximateSize. The least recently queried projects are unloaded to stay within the budget, and
+// are loaded again when next queried. A limit of 0 is unlimited.
+func SetProjectBudget(maxProjects int, maxMemory int64) {
+	projects.maxProjects = maxProjects
+	projects.maxMemory = maxMemory
 }
 
+// EnableSnapshots turns on saving a snapshot of each project loaded from the database into dir, and loading
+// projects from their snapshot when it isn't stale. A snapshot is stale when the project was updated after
+// the snapshot was made, or when the snapshot is older than maxAge. A maxAge of 0 means snapshots never get
+// too old. Changes to a project's processes, samples and attributes don't always update the project, so a
+// snapshot that isn't stale is refreshed with what changed in the database since it was made.
+func EnableSnapshots(dir string, maxAge time.Duration) {
+	snapshotDir = dir
+	snapshotMaxAge = maxAge
+}
+
+// WarmStart loads the projects that have a snapshot that isn't stale, so they are ready to query as soon as
+// the server starts. Projects with a stale snapshot are left to be loaded from the database when they are
+// next requested.
+func WarmStart() {
+	if snapshotDir == "" {
+		return
+	}
+
+	paths, err := filepath.Glob(filepath.Join(snapshotDir, "project-*.mqlsnap"))
+	if err != nil {
+		log.Errorf("Failed finding snapshots in %s: %s", snapshotDir, err)
+		return
+	}
+
+	for _, path := range paths {
+		info, err := mqldb.ReadSnapshotInfo(path)
+		if err != nil {
+			log.Errorf("Skipping snapshot: %s", err)
+			continue
+		}
+
+		if db := loadProjectSnapshot(info.ProjectID, nil); db != nil {
+			projects.swap(info.ProjectID, db)
+		}
+	}
+}
+
+// LoadProjectController starts loading the project in the background, and returns its load status. The load is
+// a job whose progress can be followed with LoadStatusController. Loading a project that is already loaded, or
+// being loaded, doesn't start another job. Projects are also loaded when first queried, so calling this is only
+// needed to have a project ready ahead of its queries.
 func LoadProjectController(c echo.Context) error {
 	var req struct {
 		ProjectID int `json:"project_id"`
@@ -31,38 +97,99 @@ func LoadProjectController(c echo.Context) error {
 		return err
 	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	projects.start(req.ProjectID, loadJobLoad, loadProjectJob(req.ProjectID))
 
-	if _, ok := mqlDBByProjectID[req.ProjectID]; ok {
-		// Project already loaded nothing to do
-		return nil
+	return loadStatusResponse(c, req.ProjectID)
+}
+
+// UnloadProjectController removes a project from memory. Queries already running against it finish, and the
+// project is loaded again if it is queried later.
+func UnloadProjectController(c echo.Context) error {
+	var req struct {
+		ProjectID int `json:"project_id"`
 	}
 
-	if err := loadProjectDB(req.ProjectID); err != nil {
-		return badRequest(err)
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if !projects.unload(req.ProjectID) {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("project %d was never loaded", req.ProjectID))
 	}
 
 	return nil
 }
 
+// ReloadProjectController starts refreshing a loaded project in the background with what changed in the database
+// since it was loaded, or loading it in full when it isn't loaded or full is set. Queries keep running against the
+// current mqldb until the refreshed one replaces it.
 func ReloadProjectController(c echo.Context) error {
 	var req struct {
-		ProjectID int `json:"project_id"`
+		ProjectID int  `json:"project_id"`
+		Full      bool `json:"full"`
 	}
 
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	projects.start(req.ProjectID, loadJobReload, func(current *mqldb.DB, progress *mqldb.LoadProgress) (*mqldb.DB, error) {
+		db, err := reloadProjectDB(req.ProjectID, current, req.Full, progress)
+		if err != nil {
+			return nil, err
+		}
 
-	if err := loadProjectDB(req.ProjectID); err != nil {
-		return badRequest(err)
+		saveProjectSnapshot(db)
+		return db, nil
+	})
+
+	return loadStatusResponse(c, req.ProjectID)
+}
+
+// LoadStatusController returns whether a project is loaded and the status of its last load job, for example:
+//
+//	GET /api/load-status/12
+//
+//	{"project_id": 12, "loaded": false, "job": {"id": 3, "project_id": 12, "kind": "load", "status": "running",
+//	 "stage": "samples", "progress": {"processes": 410, "samples": 0, "process_attributes": 2203,
+//	 "sample_attributes": 0}, "started_at": "..."}}
+func LoadStatusController(c echo.Context) error {
+	projectID, err := strconv.Atoi(c.Param("project_id"))
+	if err != nil {
+		return badRequest(fmt.Errorf("illegal project: %s", c.Param("project_id")))
 	}
 
-	return nil
+	status, ok := projects.status(projectID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("project %d was never loaded", projectID))
+	}
+
+	return c.JSON(http.StatusOK, &status)
+}
+
+// LoadJobController returns the status of a load job by its id.
+func LoadJobController(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("job_id"))
+	if err != nil {
+		return badRequest(fmt.Errorf("illegal job: %s", c.Param("job_id")))
+	}
+
+	status, ok := projects.job(id)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no load job %d", id))
+	}
+
+	return c.JSON(http.StatusOK, &status)
+}
+
+// loadStatusResponse returns the project's load status, with a 202 while its load job is running.
+func loadStatusResponse(c echo.Context, projectID int) error {
+	status, _ := projects.status(projectID)
+	if status.Job != nil && status.Job.Status == loadJobRunning {
+		return c.JSON(http.StatusAccepted, &status)
+	}
+
+	return c.JSON(http.StatusOK, &status)
 }
 
 func ExecuteQueryController(c echo.Context) error {
@@ -71,6 +198,12 @@ func ExecuteQueryController(c echo.Context) error {
 		ProjectID       int                    `json:"project_id"`
 		SelectProcesses bool                   `json:"select_processes"`
 		SelectSamples   bool                   `json:"select_samples"`
+		Aggregates      []mqldb.Aggregate      `json:"aggregates"`
+		GroupBy         []mqldb.GroupBy        `json:"group_by"`
+		OrderBy         []mqldb.OrderBy        `json:"order_by"`
+		Limit           int                    `json:"limit"`
+		Offset          int                    `json:"offset"`
+		Explain         bool                   `json:"explain"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -82,13 +215,17 @@ func ExecuteQueryController(c echo.Context) error {
 	}
 
 	statement := mqldb.MapToStatement(req.Statement)
+	if err := mqldb.ValidateStatement(statement); err != nil {
+		return badRequest(err)
+	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	if err := mqldb.ValidateAggregates(mqldb.Selection{Aggregates: req.Aggregates, GroupBy: req.GroupBy, OrderBy: req.OrderBy}); err != nil {
+		return badRequest(err)
+	}
 
-	db, ok := mqlDBByProjectID[req.ProjectID]
-	if !ok {
-		return badRequest(fmt.Errorf("project %d was never loaded", req.ProjectID))
+	db, err := acquireProject(c, req.ProjectID)
+	if err != nil {
+		return err
 	}
 
 	selection := mqldb.Selection{
@@ -98,30 +235,227 @@ func ExecuteQueryController(c echo.Context) error {
 		ProcessSelection: mqldb.ProcessSelection{
 			All: req.SelectProcesses,
 		},
+		Aggregates: req.Aggregates,
+		GroupBy:    req.GroupBy,
+		OrderBy:    req.OrderBy,
+		Limit:      req.Limit,
+		Offset:     req.Offset,
+	}
+
+	resp := mqldb.EvalQuery(db, selection, statement, req.Explain)
+	return c.JSON(http.StatusOK, &resp)
+}
+
+// ExecuteMQLController parses, compiles and executes an MQL query, for example:
+//
+//	select s: where has-process:"Heat Treatment" and a:hardness > 5
+//
+// The p: and s: selections in the query determine whether processes and/or samples are returned.
+// When the selections list fields or attributes, as in p:[name, a:time], the response also has a
+// table with a column for each of them. Aggregate queries, such as select count(s:) group by p:name,
+// only return the table. Syntax errors are returned as a 400 with the position of
+// each error.
+//
+// When explain is set the response also has an explanation for each process and sample returned, saying
+// which match statements held for it, in which sample state and against which value, and whether it matched
+// directly or was reached via a related process or sample that did. See mqldb.EvalQuery.
+func ExecuteMQLController(c echo.Context) error {
+	var req struct {
+		Query     string `json:"query"`
+		ProjectID int    `json:"project_id"`
+		Explain   bool   `json:"explain"`
 	}
 
-	var resp struct {
-		Processes []mcmodel.Activity `json:"processes"`
-		Samples   []mcmodel.Entity   `json:"samples"`
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if req.ProjectID == 0 {
+		return badRequest(fmt.Errorf("illegal project: %d", req.ProjectID))
 	}
 
-	resp.Processes, resp.Samples = mqldb.EvalStatement(db, selection, statement)
+	selection, statement, err := mql.Compile(req.Query)
+	if err != nil {
+		var parseErrors parser.ParseErrors
+		if errors.As(err, &parseErrors) {
+			return parseErrorsRequest(c, parseErrors)
+		}
+		return badRequest(err)
+	}
 
+	db, err := acquireProject(c, req.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	resp := mqldb.EvalQuery(db, selection, statement, req.Explain)
 	return c.JSON(http.StatusOK, &resp)
 }
 
-// loadProjectDB will load the mqldb for the project and save it into mqlDBByProjectID. It does not attempt to lock
-// access to mqlDBByProjectID. If this is important then the call must acquire the mutex.Lock().
-func loadProjectDB(projectID int) error {
-	db := mqldb.NewDB(projectID, DB)
-	if err := db.Load(); err != nil {
-		return fmt.Errorf("failed to load project: %d", projectID)
+// CatalogController returns the processes and attributes in a project, with a summary of each attribute's
+// values, for autocomplete and query builders. The project is loaded if it isn't already. The optional top
+// parameter is how many of the most common string values to list for each attribute, for example:
+//
+//	GET /api/catalog/12?top=5
+//
+//	{"project_id": 12, "processes": [{"name": "Heat Treatment", "count": 14}],
+//	 "process_attributes": [{"name": "temperature", "count": 14, "types": ["float"], "units": ["c"],
+//	 "ranges": [{"unit": "c", "min": 200, "max": 450}]}],
+//	 "sample_attributes": [{"name": "alloy", "count": 20, "types": ["string"], "units": [],
+//	 "top_values": [{"name": "zn45", "count": 12}, {"name": "zn60", "count": 8}]}]}
+func CatalogController(c echo.Context) error {
+	projectID, err := strconv.Atoi(c.Param("project_id"))
+	if err != nil {
+		return badRequest(fmt.Errorf("illegal project: %s", c.Param("project_id")))
 	}
 
-	mqlDBByProjectID[projectID] = db
-	return nil
+	topValues := mqldb.CatalogTopValues
+	if top := c.QueryParam("top"); top != "" {
+		if topValues, err = strconv.Atoi(top); err != nil || topValues < 1 {
+			return badRequest(fmt.Errorf("illegal top: %s", top))
+		}
+	}
+
+	db, err := acquireProject(c, projectID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, mqldb.BuildCatalog(db, topValues))
+}
+
+// acquireProject returns the DB for a query on the project, loading the project if it isn't loaded. When the
+// project is still loading after the query has waited for it the error is a 503 with a Retry-After header, as
+// the query will work once the project is loaded, and otherwise it is a 400.
+func acquireProject(c echo.Context, projectID int) (*mqldb.DB, error) {
+	db, err := projects.acquire(projectID, queryLoadWait, loadProjectJob(projectID))
+	if err == nil {
+		return db, nil
+	}
+
+	var loading *projectLoadingError
+	if errors.As(err, &loading) {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(loadRetryAfter))
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+
+	return nil, badRequest(err)
+}
+
+// loadProjectJob returns the function for a job that loads the project.
+func loadProjectJob(projectID int) func(*mqldb.DB, *mqldb.LoadProgress) (*mqldb.DB, error) {
+	return func(_ *mqldb.DB, progress *mqldb.LoadProgress) (*mqldb.DB, error) {
+		return loadProject(projectID, progress)
+	}
+}
+
+// loadProject loads the mqldb for the project from its snapshot when snapshots are enabled and the snapshot
+// isn't stale, and otherwise from the database. The load reports its progress to progress.
+func loadProject(projectID int, progress *mqldb.LoadProgress) (*mqldb.DB, error) {
+	if db := loadProjectSnapshot(projectID, progress); db != nil {
+		return db, nil
+	}
+
+	db, err := reloadProjectDB(projectID, nil, true, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	saveProjectSnapshot(db)
+
+	return db, nil
+}
+
+// reloadProjectDB reads the project from the database. When current is the loaded mqldb for the project only
+// what changed since it was loaded is read, unless full is set.
+func reloadProjectDB(projectID int, current *mqldb.DB, full bool, progress *mqldb.LoadProgress) (*mqldb.DB, error) {
+	if current == nil || full {
+		db := mqldb.NewDB(projectID, DB)
+		db.TrackProgress(progress)
+		if err := db.Load(); err != nil {
+			return nil, fmt.Errorf("failed to load project %d: %w", projectID, err)
+		}
+
+		return db, nil
+	}
+
+	db, err := mqldb.RefreshProject(current, mqldb.NewGormLoader(DB), progress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload project %d: %w", projectID, err)
+	}
+
+	return db, nil
+}
+
+// saveProjectSnapshot replaces the project's snapshot with one of db when snapshots are enabled.
+func saveProjectSnapshot(db *mqldb.DB) {
+	if snapshotDir == "" {
+		return
+	}
+
+	if err := mqldb.SaveSnapshot(db, snapshotDir); err != nil {
+		log.Errorf("Failed saving snapshot of project %d: %s", db.ProjectID, err)
+	}
+}
+
+// loadProjectSnapshot loads the project from its snapshot and refreshes it with what changed in the database
+// since the snapshot was made. It returns nil when snapshots aren't enabled, or the snapshot is missing, stale
+// or can't be read or refreshed, in which case the project needs to be loaded from the database. The refresh
+// reports its progress to progress, which can be nil.
+func loadProjectSnapshot(projectID int, progress *mqldb.LoadProgress) *mqldb.DB {
+	if snapshotDir == "" {
+		return nil
+	}
+
+	path := mqldb.SnapshotPath(snapshotDir, projectID)
+	info, err := mqldb.ReadSnapshotInfo(path)
+	if err != nil {
+		return nil
+	}
+
+	if snapshotMaxAge != 0 && time.Since(info.CreatedAt) > snapshotMaxAge {
+		log.Infof("Snapshot of project %d is older than %s, loading from database", projectID, snapshotMaxAge)
+		return nil
+	}
+
+	var project mcmodel.Project
+	if err := DB.First(&project, projectID).Error; err != nil {
+		return nil
+	}
+
+	if project.UpdatedAt.After(info.ProjectUpdatedAt) {
+		log.Infof("Project %d was updated after its snapshot was made, loading from database", projectID)
+		return nil
+	}
+
+	db, err := mqldb.LoadProject(projectID, mqldb.NewSnapshotLoader(path))
+	if err != nil {
+		log.Errorf("Failed loading snapshot of project %d, loading from database: %s", projectID, err)
+		return nil
+	}
+
+	refreshed, err := mqldb.RefreshProject(db, mqldb.NewGormLoader(DB), progress)
+	if err != nil {
+		log.Errorf("Failed refreshing snapshot of project %d, loading from database: %s", projectID, err)
+		return nil
+	}
+
+	return refreshed
 }
 
 func badRequest(err error) *echo.HTTPError {
 	return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s", err))
 }
+
+// parseErrorsRequest returns a 400 whose body lists each parse error along with its line and column.
+func parseErrorsRequest(c echo.Context, parseErrors parser.ParseErrors) error {
+	resp := struct {
+		Message string             `json:"message"`
+		Errors  parser.ParseErrors `json:"errors"`
+	}{
+		Message: "query has syntax errors",
+		Errors:  parseErrors,
+	}
+
+	return c.JSON(http.StatusBadRequest, &resp)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/materials-commons/mql/internal/mqldb"
+)
+
+func TestAcquireProjectStillLoading(t *testing.T) {
+	savedProjects, savedWait := projects, queryLoadWait
+	defer func() { projects, queryLoadWait = savedProjects, savedWait }()
+
+	projects = newProjectRegistry()
+	queryLoadWait = time.Millisecond
+
+	release := make(chan bool)
+	defer close(release)
+	projects.start(1, loadJobLoad, func(*mqldb.DB, *mqldb.LoadProgress) (*mqldb.DB, error) {
+		<-release
+		return mqldb.NewDBWithLoader(1, nil), nil
+	})
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/api/execute-query", nil), rec)
+
+	_, err := acquireProject(c, 1)
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected a 503 for a project that is still loading, got %v", err)
+	}
+
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter == "" {
+		t.Fatalf("Expected a Retry-After header")
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/materials-commons/mql/internal/mqldb"
+)
+
+// The kinds of load job.
+const (
+	loadJobLoad   = "load"
+	loadJobReload = "reload"
+)
+
+// The status of a load job.
+const (
+	loadJobRunning = "running"
+	loadJobDone    = "done"
+	loadJobFailed  = "failed"
+)
+
+// loadJob is a load or reload of a project that runs in the background. Its progress and status can be read
+// while it runs.
+type loadJob struct {
+	id        int
+	projectID int
+	kind      string
+	startedAt time.Time
+	progress  *mqldb.LoadProgress
+
+	// done is closed when the job finishes.
+	done chan struct{}
+
+	// mutex guards the fields set when the job finishes.
+	mutex      sync.Mutex
+	status     string
+	err        error
+	finishedAt time.Time
+}
+
+// LoadJobStatus is the state of a load job as returned by the API.
+type LoadJobStatus struct {
+	ID         int              `json:"id"`
+	ProjectID  int              `json:"project_id"`
+	Kind       string           `json:"kind"`
+	Status     string           `json:"status"`
+	Error      string           `json:"error,omitempty"`
+	Stage      string           `json:"stage,omitempty"`
+	Progress   mqldb.LoadCounts `json:"progress"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+}
+
+// ProjectLoadStatus is whether a project is loaded, along with its last load job. Loaded is true while a reload
+// runs, as queries use the DB the reload will replace. ApproximateSize is the estimated memory the project's DB
+// uses in bytes, and LastAccessedAt when it was last queried, which are what eviction goes by.
+type ProjectLoadStatus struct {
+	ProjectID       int            `json:"project_id"`
+	Loaded          bool           `json:"loaded"`
+	ApproximateSize int64          `json:"approximate_size"`
+	LastAccessedAt  *time.Time     `json:"last_accessed_at,omitempty"`
+	Job             *LoadJobStatus `json:"job,omitempty"`
+}
+
+func newLoadJob(id, projectID int, kind string) *loadJob {
+	return &loadJob{
+		id:        id,
+		projectID: projectID,
+		kind:      kind,
+		startedAt: time.Now(),
+		progress:  &mqldb.LoadProgress{},
+		done:      make(chan struct{}),
+		status:    loadJobRunning,
+	}
+}
+
+// finish records the outcome of the job.
+func (j *loadJob) finish(err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.err = err
+	j.finishedAt = time.Now()
+	j.status = loadJobDone
+	if err != nil {
+		j.status = loadJobFailed
+	}
+
+	close(j.done)
+}
+
+func (j *loadJob) running() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.status == loadJobRunning
+}
+
+// failure returns the error the job failed with, or nil if it is running or succeeded.
+func (j *loadJob) failure() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.err
+}
+
+func (j *loadJob) jobStatus() LoadJobStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	status := LoadJobStatus{
+		ID:        j.id,
+		ProjectID: j.projectID,
+		Kind:      j.kind,
+		Status:    j.status,
+		StartedAt: j.startedAt,
+	}
+
+	status.Stage, status.Progress = j.progress.Status()
+
+	if j.err != nil {
+		status.Error = j.err.Error()
+	}
+
+	if j.status != loadJobRunning {
+		finishedAt := j.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+
+	return status
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/materials-commons/mql/internal/mqldb"
+)
+
+// loadedProject is a project in the registry. A loaded mqldb.DB is never modified, a reload builds a new one
+// and swaps it in, so queries only need the lock long enough to get the current DB and then run without it.
+type loadedProject struct {
+	// lastAccess is when the project was last queried, in Unix nanoseconds. It is updated atomically by queries
+	// holding the read lock. It is first so that it is 64-bit aligned for the atomic operations.
+	lastAccess int64
+
+	sync.RWMutex
+	db *mqldb.DB
+
+	// size is the approximate memory db uses, in bytes.
+	size int64
+
+	// job is the last load or reload of the project. Only one runs at a time, so that two reloads don't race
+	// to swap in their DB.
+	job *loadJob
+}
+
+// setDB replaces the project's DB. The caller must hold the write lock.
+func (p *loadedProject) setDB(db *mqldb.DB, size int64) {
+	p.db = db
+	p.size = size
+	p.touch()
+}
+
+func (p *loadedProject) touch() {
+	atomic.StoreInt64(&p.lastAccess, time.Now().UnixNano())
+}
+
+// maxLoadJobs is how many of the most recent load jobs the registry keeps the status of.
+const maxLoadJobs = 1000
+
+// projectRegistry holds the projects that have been requested and their load jobs. Its mutex guards the maps,
+// each project has its own lock so that loading or querying one project doesn't hold up the others. The mutex
+// may be taken while holding a project's lock, but never the other way around.
+//
+// When the loaded projects go over maxProjects, or their approximate size goes over maxMemory bytes, the least
+// recently used projects are evicted. A budget of 0 is unlimited.
+type projectRegistry struct {
+	mutex     sync.Mutex
+	projects  map[int]*loadedProject
+	jobs      map[int]*loadJob
+	lastJobID int
+
+	maxProjects int
+	maxMemory   int64
+}
+
+func newProjectRegistry() *projectRegistry {
+	return &projectRegistry{
+		projects: make(map[int]*loadedProject),
+		jobs:     make(map[int]*loadJob),
+	}
+}
+
+// project returns the registry entry for the project, adding an empty one if there isn't one yet.
+func (r *projectRegistry) project(projectID int) *loadedProject {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p, ok := r.projects[projectID]
+	if !ok {
+		p = &loadedProject{}
+		r.projects[projectID] = p
+	}
+
+	return p
+}
+
+func (r *projectRegistry) newJob(projectID int, kind string) *loadJob {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.lastJobID++
+	job := newLoadJob(r.lastJobID, projectID, kind)
+	r.jobs[job.id] = job
+	delete(r.jobs, job.id-maxLoadJobs)
+	return job
+}
+
+// swap replaces the project's DB, for projects loaded outside of a job.
+func (r *projectRegistry) swap(projectID int, db *mqldb.DB) {
+	size := db.ApproximateSize()
+	p := r.project(projectID)
+
+	p.Lock()
+	p.setDB(db, size)
+	p.Unlock()
+
+	r.evict(projectID)
+}
+
+// lookup returns the DB for the project. While the project is reloaded the current DB is returned. It returns an
+// error if the project hasn't finished loading, or loading it failed.
+func (r *projectRegistry) lookup(projectID int) (*mqldb.DB, error) {
+	r.mutex.Lock()
+	p, ok := r.projects[projectID]
+	r.mutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("project %d was never loaded", projectID)
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+
+	switch {
+	case p.db != nil:
+		p.touch()
+		return p.db, nil
+	case p.job != nil && p.job.running():
+		return nil, &projectLoadingError{projectID: projectID}
+	case p.job != nil && p.job.failure() != nil:
+		return nil, fmt.Errorf("loading project %d failed: %s", projectID, p.job.failure())
+	default:
+		return nil, fmt.Errorf("project %d was never loaded", projectID)
+	}
+}
+
+// projectLoadingError is the error for a project that hasn't finished loading yet.
+type projectLoadingError struct {
+	projectID int
+}
+
+func (e *projectLoadingError) Error() string {
+	return fmt.Sprintf("project %d is still loading, check /api/load-status/%d", e.projectID, e.projectID)
+}
+
+// acquire returns the DB for the project, loading it with loadFn if it isn't loaded. A query that has to load
+// the project waits up to wait for it, after which it gets a projectLoadingError.
+func (r *projectRegistry) acquire(projectID int, wait time.Duration, loadFn func(current *mqldb.DB, progress *mqldb.LoadProgress) (*mqldb.DB, error)) (*mqldb.DB, error) {
+	if db, err := r.lookup(projectID); err == nil {
+		return db, nil
+	}
+
+	if job := r.start(projectID, loadJobLoad, loadFn); job != nil {
+		select {
+		case <-job.done:
+		case <-time.After(wait):
+		}
+	}
+
+	return r.lookup(projectID)
+}
+
+// start runs loadFn as a background job for the project. loadFn is passed the project's current DB, nil if it
+// isn't loaded, and the job's progress, and its DB replaces the current one. A load doesn't start a job when the
+// project is already loaded. When a job for the project is already running that job is returned instead of
+// starting another. The returned job is nil when there is nothing to do.
+func (r *projectRegistry) start(projectID int, kind string, loadFn func(current *mqldb.DB, progress *mqldb.LoadProgress) (*mqldb.DB, error)) *loadJob {
+	p := r.project(projectID)
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.job != nil && p.job.running() {
+		return p.job
+	}
+
+	if kind == loadJobLoad && p.db != nil {
+		// Project already loaded nothing to do
+		return nil
+	}
+
+	job := r.newJob(projectID, kind)
+	p.job = job
+	current := p.db
+
+	go func() {
+		db, err := loadFn(current, job.progress)
+
+		var size int64
+		if err == nil {
+			size = db.ApproximateSize()
+		}
+
+		p.Lock()
+		if err == nil {
+			p.setDB(db, size)
+		}
+		job.finish(err)
+		p.Unlock()
+
+		if err == nil {
+			r.evict(projectID)
+		}
+	}()
+
+	return job
+}
+
+// unload removes the project from the registry. Queries already running against its DB finish, and a load job
+// that is running completes but its DB is dropped. It returns false if the project was never loaded.
+func (r *projectRegistry) unload(projectID int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.projects[projectID]; !ok {
+		return false
+	}
+
+	delete(r.projects, projectID)
+	return true
+}
+
+// evict unloads the least recently used projects until the loaded projects are within the budget. The project
+// that was just loaded, keep, isn't evicted even if it alone is over the budget. Projects being reloaded aren't
+// evicted either, though they count towards the budget.
+func (r *projectRegistry) evict(keep int) {
+	if r.maxProjects == 0 && r.maxMemory == 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	projects := make(map[int]*loadedProject, len(r.projects))
+	for id, p := range r.projects {
+		projects[id] = p
+	}
+	r.mutex.Unlock()
+
+	type candidate struct {
+		projectID  int
+		p          *loadedProject
+		size       int64
+		lastAccess int64
+	}
+
+	var (
+		candidates []candidate
+		count      int
+		total      int64
+	)
+
+	for id, p := range projects {
+		p.RLock()
+		if p.db != nil {
+			count++
+			total += p.size
+			if id != keep && (p.job == nil || !p.job.running()) {
+				candidates = append(candidates, candidate{id, p, p.size, atomic.LoadInt64(&p.lastAccess)})
+			}
+		}
+		p.RUnlock()
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].lastAccess < candidates[j].lastAccess })
+
+	for _, c := range candidates {
+		if (r.maxProjects == 0 || count <= r.maxProjects) && (r.maxMemory == 0 || total <= r.maxMemory) {
+			return
+		}
+
+		r.mutex.Lock()
+		evicted := r.projects[c.projectID] == c.p
+		if evicted {
+			delete(r.projects, c.projectID)
+		}
+		r.mutex.Unlock()
+
+		if evicted {
+			log.Infof("Evicting project %d (about %d bytes) to stay within budget", c.projectID, c.size)
+			count--
+			total -= c.size
+		}
+	}
+}
+
+// status returns whether the project is loaded and the status of its last load job. ok is false if the project
+// was never loaded. A project that was loaded without a job, from a snapshot at startup, has no job.
+func (r *projectRegistry) status(projectID int) (status ProjectLoadStatus, ok bool) {
+	r.mutex.Lock()
+	p, found := r.projects[projectID]
+	r.mutex.Unlock()
+
+	if !found {
+		return ProjectLoadStatus{}, false
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+
+	status = ProjectLoadStatus{ProjectID: projectID, Loaded: p.db != nil, ApproximateSize: p.size}
+	if lastAccess := atomic.LoadInt64(&p.lastAccess); lastAccess != 0 {
+		lastAccessedAt := time.Unix(0, lastAccess)
+		status.LastAccessedAt = &lastAccessedAt
+	}
+
+	if p.job != nil {
+		jobStatus := p.job.jobStatus()
+		status.Job = &jobStatus
+	}
+
+	return status, true
+}
+
+// job returns the status of the load job with the given id.
+func (r *projectRegistry) job(id int) (LoadJobStatus, bool) {
+	r.mutex.Lock()
+	job, ok := r.jobs[id]
+	r.mutex.Unlock()
+
+	if !ok {
+		return LoadJobStatus{}, false
+	}
+
+	return job.jobStatus(), true
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/materials-commons/gomcdb/mcmodel"
+	"github.com/materials-commons/mql/internal/mqldb"
+)
+
+func TestProjectRegistryLoad(t *testing.T) {
+	r := newProjectRegistry()
+
+	if _, err := r.lookup(1); err == nil {
+		t.Fatalf("Expected an error for a project that was never loaded")
+	}
+
+	// Hold the load until the project has been looked up while it loads.
+	release := make(chan bool)
+	loads := 0
+	loadFn := func(current *mqldb.DB, progress *mqldb.LoadProgress) (*mqldb.DB, error) {
+		loads++
+		<-release
+		return mqldb.NewDBWithLoader(1, nil), nil
+	}
+
+	job := r.start(1, loadJobLoad, loadFn)
+	if job == nil {
+		t.Fatalf("Expected a load job to start")
+	}
+
+	if again := r.start(1, loadJobLoad, loadFn); again != job {
+		t.Fatalf("Expected the running job to be returned rather than starting another")
+	}
+
+	if _, err := r.lookup(1); err == nil {
+		t.Fatalf("Expected an error looking up a project that is loading")
+	}
+
+	if status, _ := r.status(1); status.Loaded || status.Job == nil || status.Job.Status != loadJobRunning {
+		t.Fatalf("Expected a running load job, got %+v", status)
+	}
+
+	close(release)
+	waitForJob(t, r, job.id)
+
+	if db, err := r.lookup(1); err != nil || db.ProjectID != 1 {
+		t.Fatalf("Expected the DB for project 1, got %+v, %v", db, err)
+	}
+
+	if again := r.start(1, loadJobLoad, loadFn); again != nil {
+		t.Fatalf("Expected no job for a project that is already loaded")
+	}
+
+	if loads != 1 {
+		t.Fatalf("Expected the project to be loaded once, it was loaded %d times", loads)
+	}
+}
+
+func TestProjectRegistryLoadFailed(t *testing.T) {
+	r := newProjectRegistry()
+
+	job := r.start(1, loadJobLoad, func(*mqldb.DB, *mqldb.LoadProgress) (*mqldb.DB, error) {
+		return nil, errors.New("no project")
+	})
+
+	status := waitForJob(t, r, job.id)
+	if status.Status != loadJobFailed || status.Error != "no project" || status.FinishedAt == nil {
+		t.Fatalf("Expected the job to fail, got %+v", status)
+	}
+
+	if _, err := r.lookup(1); err == nil {
+		t.Fatalf("Expected an error for a project that failed to load")
+	}
+}
+
+func TestProjectRegistryReload(t *testing.T) {
+	r := newProjectRegistry()
+	first := mqldb.NewDBWithLoader(1, nil)
+	r.swap(1, first)
+
+	// Queries see the current DB while the reload builds the new one.
+	second := mqldb.NewDBWithLoader(1, nil)
+	job := r.start(1, loadJobReload, func(current *mqldb.DB, progress *mqldb.LoadProgress) (*mqldb.DB, error) {
+		if current != first {
+			t.Errorf("Expected the reload to be passed the current DB")
+		}
+
+		if db, _ := r.lookup(1); db != first {
+			t.Errorf("Expected lookups during the reload to return the current DB")
+		}
+
+		return second, nil
+	})
+
+	waitForJob(t, r, job.id)
+
+	if db, _ := r.lookup(1); db != second {
+		t.Fatalf("Expected the reloaded DB to be swapped in")
+	}
+
+	// A failed reload leaves the current DB in place.
+	job = r.start(1, loadJobReload, func(*mqldb.DB, *mqldb.LoadProgress) (*mqldb.DB, error) {
+		return nil, errors.New("failed")
+	})
+
+	waitForJob(t, r, job.id)
+
+	if db, _ := r.lookup(1); db != second {
+		t.Fatalf("Expected the DB to be unchanged after a failed reload")
+	}
+}
+
+func TestProjectRegistryAcquire(t *testing.T) {
+	r := newProjectRegistry()
+	loadFn := func(*mqldb.DB, *mqldb.LoadProgress) (*mqldb.DB, error) { return mqldb.NewDBWithLoader(1, nil), nil }
+
+	// The first query loads the project.
+	if db, err := r.acquire(1, time.Second, loadFn); err != nil || db == nil {
+		t.Fatalf("Expected the project to be loaded by the query, got %+v, %v", db, err)
+	}
+
+	// A query that gives up waiting gets an error saying the project is loading.
+	release := make(chan bool)
+	slowLoadFn := func(*mqldb.DB, *mqldb.LoadProgress) (*mqldb.DB, error) {
+		<-release
+		return mqldb.NewDBWithLoader(2, nil), nil
+	}
+
+	var loading *projectLoadingError
+	if _, err := r.acquire(2, time.Millisecond, slowLoadFn); !errors.As(err, &loading) {
+		t.Fatalf("Expected an error saying project 2 is still loading, got %v", err)
+	}
+
+	close(release)
+	if db, err := r.acquire(2, time.Second, slowLoadFn); err != nil || db.ProjectID != 2 {
+		t.Fatalf("Expected the DB for project 2, got %+v, %v", db, err)
+	}
+}
+
+func TestProjectRegistryEvictByCount(t *testing.T) {
+	r := newProjectRegistry()
+	r.maxProjects = 2
+
+	r.swap(1, testDB(1, 1))
+	r.swap(2, testDB(2, 1))
+
+	// Querying project 1 leaves project 2 as the least recently used.
+	time.Sleep(time.Millisecond)
+	if _, err := r.lookup(1); err != nil {
+		t.Fatalf("Unexpected error looking up project 1: %s", err)
+	}
+
+	r.swap(3, testDB(3, 1))
+
+	checkLoaded(t, r, map[int]bool{1: true, 2: false, 3: true})
+}
+
+func TestProjectRegistryEvictByMemory(t *testing.T) {
+	r := newProjectRegistry()
+	small, large := testDB(1, 1), testDB(2, 100)
+	r.maxMemory = large.ApproximateSize() + small.ApproximateSize()/2
+
+	r.swap(1, small)
+	time.Sleep(time.Millisecond)
+	r.swap(2, large)
+
+	// The project just loaded is kept even though it is over the budget on its own.
+	r.maxMemory = large.ApproximateSize() / 2
+	r.swap(3, testDB(3, 100))
+
+	checkLoaded(t, r, map[int]bool{1: false, 2: false, 3: true})
+}
+
+func TestProjectRegistryUnload(t *testing.T) {
+	r := newProjectRegistry()
+	r.swap(1, testDB(1, 1))
+
+	if !r.unload(1) {
+		t.Fatalf("Expected project 1 to be unloaded")
+	}
+
+	if r.unload(1) {
+		t.Fatalf("Expected unloading project 1 again to fail")
+	}
+
+	checkLoaded(t, r, map[int]bool{1: false})
+}
+
+// testDB creates a DB with the given number of processes, to give it a size.
+func testDB(projectID, processes int) *mqldb.DB {
+	db := mqldb.NewDBWithLoader(projectID, nil)
+	for i := 1; i <= processes; i++ {
+		db.Processes = append(db.Processes, mcmodel.Activity{ID: i, Name: "Heat Treatment"})
+	}
+
+	return db
+}
+
+func checkLoaded(t *testing.T, r *projectRegistry, expected map[int]bool) {
+	t.Helper()
+
+	for projectID, loaded := range expected {
+		if _, err := r.lookup(projectID); (err == nil) != loaded {
+			t.Errorf("Expected project %d loaded to be %t, got error %v", projectID, loaded, err)
+		}
+	}
+}
+
+// waitForJob waits for the job to finish and returns its final status.
+func waitForJob(t *testing.T, r *projectRegistry, id int) LoadJobStatus {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		status, ok := r.job(id)
+		if !ok {
+			t.Fatalf("No job %d", id)
+		}
+
+		if status.Status != loadJobRunning {
+			return status
+		}
+	}
+
+	t.Fatalf("Timed out waiting for job %d", id)
+	return LoadJobStatus{}
+}